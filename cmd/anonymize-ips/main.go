@@ -0,0 +1,80 @@
+// Command anonymize-ips applies the configured IP privacy mode to the click
+// events stored before it was enabled. It reads the same environment as the
+// server and can be run again safely if it is interrupted.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	pgxdriver "github.com/wb-go/wbf/dbpg/pgx-driver"
+	wbflogger "github.com/wb-go/wbf/logger"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/config"
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/logger"
+	"github.com/MyNameIsWhaaat/shortener/internal/service"
+	"github.com/MyNameIsWhaaat/shortener/internal/store"
+)
+
+func main() {
+	batchSize := flag.Int("batch", 1000, "number of rows updated per statement")
+	flag.Parse()
+
+	logger.Init()
+
+	cfg := config.Load()
+
+	mode := domain.IPPrivacyMode(cfg.IPPrivacyMode)
+	if mode == domain.IPPrivacyOff {
+		logger.Error("IP_PRIVACY_MODE is off, nothing to anonymize")
+		os.Exit(1)
+	}
+	if mode == domain.IPPrivacyHash && cfg.IPHashKey == "" {
+		logger.Error("IP_HASH_KEY is required to hash stored addresses")
+		os.Exit(1)
+	}
+
+	anonymizer, err := service.NewIPAnonymizer(mode, cfg.IPv4Prefix, cfg.IPv6Prefix, cfg.IPHashKey, cfg.IPHashRotation)
+	if err != nil {
+		logger.Error("Invalid IP_PRIVACY_MODE", "mode", cfg.IPPrivacyMode, "error", err)
+		os.Exit(1)
+	}
+
+	appLogger, err := wbflogger.InitLogger(
+		wbflogger.ZerologEngine,
+		"anonymize-ips",
+		"dev",
+	)
+	if err != nil {
+		logger.Error("Failed to init wbf logger", "error", err)
+		os.Exit(1)
+	}
+
+	pg, err := pgxdriver.New(cfg.PostgresDSN, appLogger)
+	if err != nil {
+		logger.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
+	}
+	defer pg.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	pgStore := store.NewPostgresStoreFromPool(pg.Pool)
+
+	started := time.Now()
+	logger.Info("Anonymizing stored click addresses", "mode", mode)
+
+	rows, err := pgStore.AnonymizeClickIPs(ctx, *batchSize, anonymizer.Anonymize)
+	if err != nil {
+		logger.Error("Failed to anonymize click addresses", "rows", rows, "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("Click addresses anonymized", "rows", rows, "took", time.Since(started).String())
+}
@@ -0,0 +1,65 @@
+// Command prune-visitors deletes the daily and monthly unique visitor
+// sketches stored in PostgreSQL that are older than the longest range the
+// analytics API reports. It reads the same environment as the server and can
+// be run again safely if it is interrupted; schedule it daily, e.g. with cron.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	pgxdriver "github.com/wb-go/wbf/dbpg/pgx-driver"
+	wbflogger "github.com/wb-go/wbf/logger"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/config"
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/logger"
+	"github.com/MyNameIsWhaaat/shortener/internal/store"
+)
+
+func main() {
+	batchSize := flag.Int("batch", 1000, "number of rows deleted per statement")
+	flag.Parse()
+
+	logger.Init()
+
+	cfg := config.Load()
+
+	appLogger, err := wbflogger.InitLogger(
+		wbflogger.ZerologEngine,
+		"prune-visitors",
+		"dev",
+	)
+	if err != nil {
+		logger.Error("Failed to init wbf logger", "error", err)
+		os.Exit(1)
+	}
+
+	pg, err := pgxdriver.New(cfg.PostgresDSN, appLogger)
+	if err != nil {
+		logger.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
+	}
+	defer pg.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	pgStore := store.NewPostgresStoreFromPool(pg.Pool)
+
+	started := time.Now()
+	oldestDay, oldestMonth := domain.OldestVisitorPeriods(started)
+	logger.Info("Pruning visitor sketches", "before_day", oldestDay, "before_month", oldestMonth)
+
+	rows, err := pgStore.PruneVisitorSketches(ctx, *batchSize, oldestDay, oldestMonth)
+	if err != nil {
+		logger.Error("Failed to prune visitor sketches", "rows", rows, "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("Visitor sketches pruned", "rows", rows, "took", time.Since(started).String())
+}
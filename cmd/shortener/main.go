@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"os"
@@ -15,10 +17,13 @@ import (
 	"github.com/MyNameIsWhaaat/shortener/internal/api"
 	"github.com/MyNameIsWhaaat/shortener/internal/cache"
 	"github.com/MyNameIsWhaaat/shortener/internal/config"
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
 	handler "github.com/MyNameIsWhaaat/shortener/internal/httpapi"
 	"github.com/MyNameIsWhaaat/shortener/internal/logger"
 	"github.com/MyNameIsWhaaat/shortener/internal/service"
+	"github.com/MyNameIsWhaaat/shortener/internal/spool"
 	"github.com/MyNameIsWhaaat/shortener/internal/store"
+	"github.com/MyNameIsWhaaat/shortener/internal/ui"
 )
 
 func main() {
@@ -57,6 +62,8 @@ func main() {
 	logger.Info("Database connected successfully")
 
 	var cacheClient cache.Cache
+	var idempotencyStore store.IdempotencyStore = pgStore
+	var visitorStore store.VisitorStore = pgStore
 	redisCache, err := cache.NewRedisCache(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, cfg.CacheTTL)
 	if err != nil {
 		logger.Warn("Failed to initialize Redis cache, continuing without cache", "error", err)
@@ -64,21 +71,70 @@ func main() {
 	} else {
 		defer redisCache.Close()
 		cacheClient = redisCache
+		idempotencyStore = redisCache
+		visitorStore = redisCache
 		logger.Info("Redis cache initialized successfully")
 	}
 
+	var clickSpool *spool.Spool
+	if cfg.ClickSpoolDir != "" {
+		clickSpool, err = spool.Open(cfg.ClickSpoolDir, cfg.ClickSpoolMaxSize)
+		if err != nil {
+			logger.Warn("Failed to open click spool, continuing without it", "dir", cfg.ClickSpoolDir, "error", err)
+			clickSpool = nil
+		} else {
+			defer clickSpool.Close()
+			logger.Info("Click spool opened", "dir", cfg.ClickSpoolDir, "pending_bytes", clickSpool.Size())
+		}
+	}
+
+	visitorSalt := cfg.VisitorSalt
+	if visitorSalt == "" {
+		visitorSalt = randomSecret()
+		logger.Warn("VISITOR_SALT is not set, unique visitors will be counted anew after a restart")
+	}
+	visitorCounter := service.NewVisitorCounter(visitorStore, visitorSalt)
+
+	ipHashKey := cfg.IPHashKey
+	if ipHashKey == "" && domain.IPPrivacyMode(cfg.IPPrivacyMode) == domain.IPPrivacyHash {
+		ipHashKey = randomSecret()
+		logger.Warn("IP_HASH_KEY is not set, address hashes will change after a restart")
+	}
+	anonymizer, err := service.NewIPAnonymizer(domain.IPPrivacyMode(cfg.IPPrivacyMode), cfg.IPv4Prefix, cfg.IPv6Prefix, ipHashKey, cfg.IPHashRotation)
+	if err != nil {
+		logger.Error("Invalid IP_PRIVACY_MODE", "mode", cfg.IPPrivacyMode, "error", err)
+		os.Exit(1)
+	}
+	logger.Info("IP privacy mode", "mode", anonymizer.Mode())
+
+	clickIngester := service.NewClickIngester(pgStore, visitorCounter, clickSpool, pgStore, cfg.ClickQueueSize, cfg.ClickBatchSize, cfg.ClickFlushInterval, cfg.ClickWorkers)
+
 	shortenerService := service.NewShortenerService(
 		pgStore,
 		cfg.BaseURL,
 		cfg.ShortCodeLength,
 		pgStore,
 		cacheClient,
+		cfg.DeduplicateURLs,
+		cfg.CountBotClicks,
+		clickIngester,
+		visitorCounter,
+		anonymizer,
 	)
 
-	analyticsService := service.NewAnalyticsService(pgStore)
-	h := handler.NewHandler(shortenerService, analyticsService)
+	analyticsService := service.NewAnalyticsService(pgStore, visitorCounter, anonymizer)
+	branding := ui.Branding{Name: cfg.BrandName, LogoURL: cfg.BrandLogoURL, SupportURL: cfg.SupportURL}
+	h := handler.NewHandler(shortenerService, analyticsService, idempotencyStore, cfg.IdempotencyTTL, branding)
 	server := api.NewServer(cfg, h)
 
+	// Redis expires idempotency keys itself; in PostgreSQL keys that are never
+	// sent again would stay forever
+	maintenanceCtx, stopMaintenance := context.WithCancel(context.Background())
+	defer stopMaintenance()
+	if idempotencyStore == store.IdempotencyStore(pgStore) {
+		go purgeIdempotencyKeys(maintenanceCtx, pgStore, cfg.IdempotencyPurgeInterval)
+	}
+
 	sigCh := make(chan os.Signal, 1)
 	errCh := make(chan error, 1)
 
@@ -92,6 +148,7 @@ func main() {
 
 	logger.Info("Server is running. Press Ctrl+C to stop.")
 
+	exitCode := 0
 	select {
 	case sig := <-sigCh:
 		logger.Info("Shutdown signal received", "signal", sig.String())
@@ -106,9 +163,63 @@ func main() {
 	case err := <-errCh:
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("Server stopped with error", "error", err)
-			os.Exit(1)
+			exitCode = 1
+		}
+	}
+
+	// Queued clicks are written, or spooled, whichever way the server stopped
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := clickIngester.Close(flushCtx); err != nil {
+		logger.Error("Failed to flush queued clicks", "error", err, "clicks", clickIngester.Stats().QueueLength)
+	}
+
+	if exitCode != 0 {
+		// os.Exit skips deferred calls, so the spool is closed here
+		if clickSpool != nil {
+			if err := clickSpool.Close(); err != nil {
+				logger.Error("Failed to close click spool", "error", err)
+			}
 		}
+		os.Exit(exitCode)
 	}
 
 	logger.Info("Application stopped")
 }
+
+// purgeIdempotencyKeys deletes expired idempotency keys every interval until
+// ctx is done
+func purgeIdempotencyKeys(ctx context.Context, pgStore *store.PostgresStore, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purgeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			purged, err := pgStore.PurgeExpiredIdempotencyKeys(purgeCtx)
+			cancel()
+			if err != nil {
+				logger.Error("Failed to purge idempotency keys", "error", err)
+			} else if purged > 0 {
+				logger.Info("Purged expired idempotency keys", "keys", purged)
+			}
+		}
+	}
+}
+
+// randomSecret returns a random key for when none is configured
+func randomSecret() string {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		logger.Error("Failed to generate secret", "error", err)
+		os.Exit(1)
+	}
+	return hex.EncodeToString(secret)
+}
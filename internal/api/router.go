@@ -32,16 +32,42 @@ func NewRouter(h *handler.Handler) *Router {
 func (r *Router) setupRoutes() {
 	api := r.router.PathPrefix("/api").Subrouter()
 	{
-		api.HandleFunc("/shorten", r.handler.Shorten).Methods("POST")
+		api.HandleFunc("/shorten", r.handler.Idempotent(r.handler.Shorten)).Methods("POST")
+		api.HandleFunc("/shorten/batch", r.handler.Idempotent(r.handler.ShortenBatch)).Methods("POST")
 		api.HandleFunc("/urls", r.handler.GetAllURLs).Methods("GET")
 		api.HandleFunc("/urls/popular", r.handler.GetPopularURLs).Methods("GET")
+		api.HandleFunc("/urls/search", r.handler.SearchURLs).Methods("GET")
+		api.HandleFunc("/urls/{short_code}", r.handler.UpdateURL).Methods("PATCH")
+		api.HandleFunc("/urls/{short_code}", r.handler.DeleteURL).Methods("DELETE")
+		api.HandleFunc("/urls/{short_code}/disable", r.handler.DisableURL).Methods("POST")
+		api.HandleFunc("/urls/{short_code}/enable", r.handler.EnableURL).Methods("POST")
+		api.HandleFunc("/urls/{short_code}/targeting", r.handler.SetTargeting).Methods("PUT")
+		api.HandleFunc("/urls/{short_code}/variants", r.handler.SetVariants).Methods("PUT")
+		api.HandleFunc("/urls/{short_code}/interstitial", r.handler.SetInterstitial).Methods("PUT")
+		api.HandleFunc("/urls/{short_code}/deeplinks", r.handler.SetDeepLinks).Methods("PUT")
+		api.HandleFunc("/urls/{short_code}/tags", r.handler.AddTags).Methods("POST")
+		api.HandleFunc("/urls/{short_code}/tags/{tag}", r.handler.RemoveTag).Methods("DELETE")
+		api.HandleFunc("/tags", r.handler.GetTags).Methods("GET")
+		api.HandleFunc("/domains", r.handler.ListDomains).Methods("GET")
+		api.HandleFunc("/domains", r.handler.AddDomain).Methods("POST")
+		api.HandleFunc("/domains/{domain}", r.handler.DeleteDomain).Methods("DELETE")
+		api.HandleFunc("/clicks/stats", r.handler.ClickStats).Methods("GET")
 		api.HandleFunc("/analytics/{short_code}", r.handler.GetAnalytics).Methods("GET")
 		api.HandleFunc("/analytics/{short_code}/daily", r.handler.GetDailyStats).Methods("GET")
 		api.HandleFunc("/analytics/{short_code}/monthly", r.handler.GetMonthlyStats).Methods("GET")
 		api.HandleFunc("/analytics/{short_code}/devices", r.handler.GetDeviceStats).Methods("GET")
+		api.HandleFunc("/analytics/{short_code}/browsers", r.handler.GetBrowserStats).Methods("GET")
+		api.HandleFunc("/analytics/{short_code}/os", r.handler.GetOSStats).Methods("GET")
+		api.HandleFunc("/analytics/{short_code}/variants", r.handler.GetVariantStats).Methods("GET")
+		api.HandleFunc("/analytics/{short_code}/bots", r.handler.GetBotStats).Methods("GET")
+		api.HandleFunc("/analytics/{short_code}/visitors", r.handler.GetUniqueVisitors).Methods("GET")
 	}
 
-	r.router.HandleFunc("/s/{short_code}", r.handler.Redirect).Methods("GET")
+	r.router.HandleFunc("/s/{short_code}+", r.handler.Preview).Methods("GET")
+	r.router.HandleFunc("/s/{short_code}", r.handler.Redirect).Methods("GET", "HEAD")
+	r.router.HandleFunc("/s/{short_code}", r.handler.Unlock).Methods("POST")
+	r.router.HandleFunc("/s/{short_code}/{path:.+}", r.handler.Redirect).Methods("GET", "HEAD")
+	r.router.HandleFunc("/s/{short_code}/{path:.+}", r.handler.Unlock).Methods("POST")
 	r.router.HandleFunc("/health", r.handler.Health).Methods("GET")
 
 	uiMux := http.NewServeMux()
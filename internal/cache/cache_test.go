@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/MyNameIsWhaaat/shortener/internal/domain"
 )
@@ -44,3 +45,31 @@ func TestNoOpCache(t *testing.T) {
 		t.Errorf("Close should not fail, got %v", err)
 	}
 }
+
+func TestRedisCacheTTLFor(t *testing.T) {
+	rc := &RedisCache{ttl: time.Hour}
+	now := time.Now()
+
+	soon := now.Add(10 * time.Minute)
+	later := now.Add(48 * time.Hour)
+	past := now.Add(-time.Minute)
+
+	tests := []struct {
+		name string
+		url  *domain.URL
+		want time.Duration
+	}{
+		{"no expiration", &domain.URL{}, time.Hour},
+		{"expires before ttl", &domain.URL{ExpiresAt: &soon}, 10 * time.Minute},
+		{"expires after ttl", &domain.URL{ExpiresAt: &later}, time.Hour},
+		{"already expired", &domain.URL{ExpiresAt: &past}, -time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rc.ttlFor(tt.url, now); got != tt.want {
+				t.Errorf("expected ttl %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
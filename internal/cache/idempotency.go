@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+const idempotencyKeyPrefix = "idem:"
+
+// ReserveIdempotencyKey claims key with an in-flight record
+func (rc *RedisCache) ReserveIdempotencyKey(ctx context.Context, key, requestHash string, ttl time.Duration) (bool, error) {
+	data, err := json.Marshal(&domain.IdempotencyRecord{RequestHash: requestHash})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+
+	ok, err := rc.client.SetNX(ctx, idempotencyKeyPrefix+key, string(data), ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	return ok, nil
+}
+
+// GetIdempotencyRecord returns the record stored for key, or nil if there is none
+func (rc *RedisCache) GetIdempotencyRecord(ctx context.Context, key string) (*domain.IdempotencyRecord, error) {
+	val, err := rc.client.Get(ctx, idempotencyKeyPrefix+key).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency record: %w", err)
+	}
+
+	var record domain.IdempotencyRecord
+	if err := json.Unmarshal([]byte(val), &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal idempotency record: %w", err)
+	}
+
+	return &record, nil
+}
+
+// SaveIdempotencyRecord stores the final response for key
+func (rc *RedisCache) SaveIdempotencyRecord(ctx context.Context, key string, record *domain.IdempotencyRecord, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+
+	if err := rc.client.Set(ctx, idempotencyKeyPrefix+key, string(data), ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save idempotency record: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey removes key so the request can be retried
+func (rc *RedisCache) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if err := rc.client.Del(ctx, idempotencyKeyPrefix+key).Err(); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+
+	return nil
+}
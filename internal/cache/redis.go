@@ -80,19 +80,37 @@ func (rc *RedisCache) Get(ctx context.Context, shortCode string) (*domain.URL, e
 // Set stores a URL in cache with TTL
 func (rc *RedisCache) Set(ctx context.Context, shortCode string, url *domain.URL) error {
 	key := urlKeyPrefix + shortCode
+
+	ttl := rc.ttlFor(url, time.Now())
+	if ttl <= 0 {
+		return nil // Already expired, nothing to cache
+	}
+
 	data, err := json.Marshal(url)
 	if err != nil {
 		return fmt.Errorf("failed to marshal URL: %w", err)
 	}
 
-	if err := rc.client.Set(ctx, key, string(data), rc.ttl).Err(); err != nil {
+	if err := rc.client.Set(ctx, key, string(data), ttl).Err(); err != nil {
 		return fmt.Errorf("failed to set cache: %w", err)
 	}
 
-	logger.Info("Cached URL", "short_code", shortCode, "ttl", rc.ttl)
+	logger.Info("Cached URL", "short_code", shortCode, "ttl", ttl)
 	return nil
 }
 
+// ttlFor returns the cache TTL for a URL, capped so that the cache entry
+// never outlives the link's expiration date
+func (rc *RedisCache) ttlFor(url *domain.URL, now time.Time) time.Duration {
+	ttl := rc.ttl
+	if url.ExpiresAt != nil {
+		if remaining := url.ExpiresAt.Sub(now); remaining < ttl {
+			ttl = remaining
+		}
+	}
+	return ttl
+}
+
 // Invalidate removes a URL from cache
 func (rc *RedisCache) Invalidate(ctx context.Context, shortCode string) error {
 	key := urlKeyPrefix + shortCode
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	visitorKeyPrefix = "uv:"
+
+	// Daily and monthly sketches are kept a bit longer than the longest
+	// range the analytics API reports
+	visitorDayTTL   = 366 * 24 * time.Hour
+	visitorMonthTTL = 25 * 31 * 24 * time.Hour
+)
+
+// visitorKey names the HyperLogLog of a link for period, "all" for the
+// all-time sketch
+func visitorKey(host, shortCode, period string) string {
+	if host == "" {
+		return visitorKeyPrefix + shortCode + ":" + period
+	}
+	return visitorKeyPrefix + host + "/" + shortCode + ":" + period
+}
+
+// AddVisitors adds the fingerprints of events to Redis HyperLogLogs with
+// PFADD, one round trip per batch
+func (rc *RedisCache) AddVisitors(ctx context.Context, events []*domain.ClickEvent) error {
+	type sketch struct {
+		ttl      time.Duration
+		visitors []any
+	}
+
+	sketches := make(map[string]*sketch)
+	add := func(key string, ttl time.Duration, visitor string) {
+		if sketches[key] == nil {
+			sketches[key] = &sketch{ttl: ttl}
+		}
+		sketches[key].visitors = append(sketches[key].visitors, visitor)
+	}
+
+	for _, event := range events {
+		if event.Visitor == "" {
+			continue
+		}
+		day, month := domain.VisitorPeriods(event.CreatedAt)
+		add(visitorKey(event.Domain, event.ShortCode, "all"), 0, event.Visitor)
+		add(visitorKey(event.Domain, event.ShortCode, day), visitorDayTTL, event.Visitor)
+		add(visitorKey(event.Domain, event.ShortCode, month), visitorMonthTTL, event.Visitor)
+	}
+	if len(sketches) == 0 {
+		return nil
+	}
+
+	_, err := rc.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, s := range sketches {
+			pipe.PFAdd(ctx, key, s.visitors...)
+			if s.ttl > 0 {
+				pipe.Expire(ctx, key, s.ttl)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add visitors: %w", err)
+	}
+
+	return nil
+}
+
+// CountVisitors reads the estimates with PFCOUNT
+func (rc *RedisCache) CountVisitors(ctx context.Context, host, shortCode string, days, months []string) (*domain.UniqueVisitors, error) {
+	var (
+		total   *redis.IntCmd
+		daily   = make(map[string]*redis.IntCmd, len(days))
+		monthly = make(map[string]*redis.IntCmd, len(months))
+	)
+
+	_, err := rc.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		total = pipe.PFCount(ctx, visitorKey(host, shortCode, "all"))
+		for _, day := range days {
+			daily[day] = pipe.PFCount(ctx, visitorKey(host, shortCode, day))
+		}
+		for _, month := range months {
+			monthly[month] = pipe.PFCount(ctx, visitorKey(host, shortCode, month))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count visitors: %w", err)
+	}
+
+	visitors := &domain.UniqueVisitors{
+		Total:   total.Val(),
+		Daily:   make(map[string]int64),
+		Monthly: make(map[string]int64),
+	}
+	for day, cmd := range daily {
+		if n := cmd.Val(); n > 0 {
+			visitors.Daily[day] = n
+		}
+	}
+	for month, cmd := range monthly {
+		if n := cmd.Val(); n > 0 {
+			visitors.Monthly[month] = n
+		}
+	}
+
+	return visitors, nil
+}
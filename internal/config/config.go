@@ -31,9 +31,33 @@ type Config struct {
 
 	ShortCodeLength int
 	CacheTTL        time.Duration
+	DeduplicateURLs bool
+	IdempotencyTTL  time.Duration
+	CountBotClicks  bool
+
+	IdempotencyPurgeInterval time.Duration
+
+	ClickQueueSize     int
+	ClickBatchSize     int
+	ClickFlushInterval time.Duration
+	ClickWorkers       int
+	ClickSpoolDir      string
+	ClickSpoolMaxSize  int64
+
+	VisitorSalt string
+
+	IPPrivacyMode  string
+	IPv4Prefix     int
+	IPv6Prefix     int
+	IPHashKey      string
+	IPHashRotation time.Duration
 
 	RateLimitEnabled bool
 	RateLimit        int
+
+	BrandName    string
+	BrandLogoURL string
+	SupportURL   string
 }
 
 func Load() *Config {
@@ -60,9 +84,33 @@ func Load() *Config {
 
 		ShortCodeLength: getEnvAsInt("SHORT_CODE_LENGTH", 6),
 		CacheTTL:        getEnvAsDuration("CACHE_TTL", 24*time.Hour),
+		DeduplicateURLs: getEnvAsBool("DEDUPLICATE_URLS", false),
+		IdempotencyTTL:  getEnvAsDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		CountBotClicks:  getEnvAsBool("COUNT_BOT_CLICKS", false),
+
+		IdempotencyPurgeInterval: getEnvAsDuration("IDEMPOTENCY_PURGE_INTERVAL", time.Hour),
+
+		ClickQueueSize:     getEnvAsInt("CLICK_QUEUE_SIZE", 10000),
+		ClickBatchSize:     getEnvAsInt("CLICK_BATCH_SIZE", 500),
+		ClickFlushInterval: getEnvAsDuration("CLICK_FLUSH_INTERVAL", time.Second),
+		ClickWorkers:       getEnvAsInt("CLICK_WORKERS", 2),
+		ClickSpoolDir:      getEnv("CLICK_SPOOL_DIR", ""),
+		ClickSpoolMaxSize:  int64(getEnvAsInt("CLICK_SPOOL_MAX_MB", 256)) << 20,
+
+		VisitorSalt: getEnv("VISITOR_SALT", ""),
+
+		IPPrivacyMode:  getEnv("IP_PRIVACY_MODE", "truncate"),
+		IPv4Prefix:     getEnvAsInt("IP_V4_PREFIX", 24),
+		IPv6Prefix:     getEnvAsInt("IP_V6_PREFIX", 48),
+		IPHashKey:      getEnv("IP_HASH_KEY", ""),
+		IPHashRotation: getEnvAsDuration("IP_HASH_ROTATION", 24*time.Hour),
 
 		RateLimitEnabled: getEnvAsBool("RATE_LIMIT_ENABLED", false),
 		RateLimit:        getEnvAsInt("RATE_LIMIT", 100),
+
+		BrandName:    getEnv("BRAND_NAME", "URL Shortener"),
+		BrandLogoURL: getEnv("BRAND_LOGO_URL", ""),
+		SupportURL:   getEnv("SUPPORT_URL", ""),
 	}
 
 	cfg.PostgresDSN = buildPostgresDSN(cfg)
@@ -113,6 +113,28 @@ func TestLoad(t *testing.T) {
 		}
 	})
 
+	t.Run("deduplicate URLs", func(t *testing.T) {
+		os.Setenv("DEDUPLICATE_URLS", "true")
+		defer os.Unsetenv("DEDUPLICATE_URLS")
+
+		cfg := Load()
+
+		if !cfg.DeduplicateURLs {
+			t.Error("expected DeduplicateURLs to be enabled")
+		}
+	})
+
+	t.Run("count bot clicks", func(t *testing.T) {
+		os.Setenv("COUNT_BOT_CLICKS", "true")
+		defer os.Unsetenv("COUNT_BOT_CLICKS")
+
+		cfg := Load()
+
+		if !cfg.CountBotClicks {
+			t.Error("expected CountBotClicks to be enabled")
+		}
+	})
+
 	t.Run("cache TTL", func(t *testing.T) {
 		os.Setenv("CACHE_TTL", "48h")
 
@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"net/http"
+	"strings"
+)
+
+// botPatterns are lowercase fragments of the user agents of crawlers, link
+// unfurlers, security scanners and HTTP libraries. Keep the list sorted by
+// kind when adding new entries.
+var botPatterns = []string{
+	// Generic markers
+	"bot", "crawler", "crawl", "spider", "slurp", "scraper", "preview", "fetcher", "monitor",
+
+	// Messengers and social networks unfurling links. The in-app browsers of
+	// these apps mention the app name too, so only the unfurler tokens are
+	// listed; Tumblr's "tumblr/... (crawler)" is caught by "crawler".
+	"facebookexternalhit", "facebookcatalog", "meta-externalagent", "whatsapp",
+	"telegrambot", "slackbot", "slack-imgproxy", "discordbot", "skypeuripreview",
+	"viber", "vkshare", "linkedinbot", "pinterestbot", "redditbot", "embedly",
+	"quora link preview", "snapchat-preview", "snap url preview", "mastodon",
+	"bluesky", "iframely",
+
+	// Mail and link security scanners
+	"urlscan", "safelinks", "mimecast", "proofpoint", "barracuda", "forcepoint",
+	"virustotal", "phishtank", "checkpoint", "zscaler",
+
+	// Headless browsers and HTTP libraries
+	"headlesschrome", "phantomjs", "lighthouse", "pagespeed", "curl/", "wget/",
+	"python-requests", "python-urllib", "aiohttp", "httpx", "go-http-client",
+	"okhttp", "java/", "apache-httpclient", "node-fetch", "axios/", "libwww-perl",
+	"httpie", "postmanruntime", "insomnia",
+}
+
+// IsBot reports whether a request to a short link comes from an automated
+// client rather than a person following the link. Besides known user agents
+// it treats HEAD requests and requests without an Accept header as bots:
+// browsers always send Accept, while unfurlers often probe with HEAD.
+func IsBot(method, userAgent, accept string) bool {
+	if method == http.MethodHead {
+		return true
+	}
+	if strings.TrimSpace(userAgent) == "" || strings.TrimSpace(accept) == "" {
+		return true
+	}
+
+	return isBotAgent(strings.ToLower(userAgent))
+}
+
+// isBotAgent reports whether a lowercased user agent matches botPatterns
+func isBotAgent(ua string) bool {
+	for _, pattern := range botPatterns {
+		if strings.Contains(ua, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -6,20 +6,76 @@ import (
 
 type ClickEvent struct {
 	ID        int64     `json:"id" db:"id"`
+	Domain    string    `json:"domain,omitempty" db:"domain"`
 	ShortCode string    `json:"short_code" db:"short_code"`
 	UserAgent string    `json:"user_agent" db:"user_agent"`
 	IP        string    `json:"ip" db:"ip"`
+	IPHash    string    `json:"ip_hash,omitempty" db:"ip_hash"`
 	Referer   string    `json:"referer" db:"referer"`
+	Variant   string    `json:"variant,omitempty" db:"variant"`
+	IsBot     bool      `json:"is_bot,omitempty" db:"is_bot"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+
+	// Client parsed from UserAgent when the click is tracked
+	Browser        string `json:"browser,omitempty" db:"browser"`
+	BrowserVersion string `json:"browser_version,omitempty" db:"browser_version"`
+	OS             string `json:"os,omitempty" db:"os"`
+	OSVersion      string `json:"os_version,omitempty" db:"os_version"`
+	Device         string `json:"device,omitempty" db:"device"`
+
+	// Visitor is the fingerprint used to count unique visitors. It is only
+	// set for counted clicks and is not saved to click_events.
+	Visitor string `json:"visitor,omitempty" db:"-"`
 }
 
 type AnalyticsResponse struct {
+	Domain       string           `json:"domain,omitempty"`
 	ShortCode    string           `json:"short_code"`
 	OriginalURL  string           `json:"original_url"`
 	CreatedAt    time.Time        `json:"created_at"`
 	TotalClicks  int64            `json:"total_clicks"`
+	BotClicks    int64            `json:"bot_clicks"`
 	DailyStats   map[string]int64 `json:"daily_stats"`
 	MonthlyStats map[string]int64 `json:"monthly_stats"`
 	Devices      map[string]int64 `json:"devices"`
+	Browsers     map[string]int64 `json:"browsers"`
+	OS           map[string]int64 `json:"os"`
+	Variants     map[string]int64 `json:"variants,omitempty"`
 	RecentClicks []ClickEvent     `json:"recent_clicks"`
+
+	UniqueVisitors *UniqueVisitors `json:"unique_visitors,omitempty"`
+}
+
+// BotStats describes the automated traffic of a link, which is kept out of
+// the regular statistics.
+type BotStats struct {
+	Domain      string           `json:"domain,omitempty"`
+	ShortCode   string           `json:"short_code"`
+	TotalClicks int64            `json:"total_clicks"`
+	DailyStats  map[string]int64 `json:"daily_stats"`
+	UserAgents  map[string]int64 `json:"user_agents"`
+}
+
+// ClickCount is the number of clicks to add to a link's counter
+type ClickCount struct {
+	Domain    string `json:"domain,omitempty"`
+	ShortCode string `json:"short_code"`
+	Clicks    int64  `json:"clicks"`
+}
+
+// IngestStats reports the state of the click ingestion queue
+type IngestStats struct {
+	QueueLength   int   `json:"queue_length"`
+	QueueCapacity int   `json:"queue_capacity"`
+	Enqueued      int64 `json:"enqueued"`
+	Dropped       int64 `json:"dropped"`
+	Written       int64 `json:"written"`
+	Failed        int64 `json:"failed"`
+	Batches       int64 `json:"batches"`
+
+	// Spooled clicks were written to the on-disk spool after a failed batch
+	// write, Replayed ones made it from the spool to the database later
+	Spooled    int64 `json:"spooled"`
+	Replayed   int64 `json:"replayed"`
+	SpoolBytes int64 `json:"spool_bytes"`
 }
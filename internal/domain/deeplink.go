@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DeepLinks open the mobile apps of the destination. App URLs may use a
+// custom scheme (myapp://item/42) or be universal/app links over https.
+// FallbackURL is where visitors without the app are sent, typically the
+// App Store or Play Store page.
+type DeepLinks struct {
+	IOSURL      string `json:"ios_url,omitempty"`
+	AndroidURL  string `json:"android_url,omitempty"`
+	FallbackURL string `json:"fallback_url,omitempty"`
+}
+
+func (d DeepLinks) IsEmpty() bool {
+	return d.IOSURL == "" && d.AndroidURL == "" && d.FallbackURL == ""
+}
+
+// AppURL returns the app URL for the visitor's platform, or an empty string
+// when the link has none for it.
+func (d DeepLinks) AppURL(v Visitor) string {
+	switch v.OS {
+	case OSIOS:
+		return d.IOSURL
+	case OSAndroid:
+		return d.AndroidURL
+	}
+	return ""
+}
+
+// unsafeSchemes can run code in the page that opens them and are never
+// accepted as app URLs.
+var unsafeSchemes = map[string]bool{
+	"javascript": true,
+	"data":       true,
+	"vbscript":   true,
+	"file":       true,
+}
+
+// IsValidAppURL reports whether raw is an absolute URL that may be used to
+// open an app.
+func IsValidAppURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme == "" {
+		return false
+	}
+	if unsafeSchemes[strings.ToLower(parsed.Scheme)] {
+		return false
+	}
+	if IsWebURL(raw) && parsed.Host == "" {
+		return false
+	}
+	return true
+}
+
+// IsWebURL reports whether raw uses the http or https scheme. Other app URLs
+// need a bridge page to be opened from a browser.
+func IsWebURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	return scheme == "http" || scheme == "https"
+}
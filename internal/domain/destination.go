@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"net/url"
+	"strings"
+)
+
+// UTMParams are campaign parameters added to the destination of a link on
+// redirect unless the destination or the visitor already set them.
+type UTMParams struct {
+	Source   string `json:"utm_source,omitempty"`
+	Medium   string `json:"utm_medium,omitempty"`
+	Campaign string `json:"utm_campaign,omitempty"`
+}
+
+func (p *UTMParams) IsEmpty() bool {
+	return p == nil || (p.Source == "" && p.Medium == "" && p.Campaign == "")
+}
+
+// Destination returns the URL a visitor is redirected to, starting from
+// target (the original URL or the destination of a targeting rule). With
+// ForwardQuery set the incoming query parameters are merged into it, then
+// the link's UTM defaults are added. Parameters already present are never
+// overwritten: the destination's own ones win over incoming ones, and both
+// win over UTM defaults. The destination's own query string is kept byte
+// for byte, with the added parameters appended to it.
+func (u *URL) Destination(target string, incoming url.Values) string {
+	forward := u.ForwardQuery && len(incoming) > 0
+	if !forward && u.UTM.IsEmpty() {
+		return target
+	}
+
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+
+	query := parsed.Query()
+	added := url.Values{}
+	present := func(key string) bool {
+		_, inQuery := query[key]
+		_, inAdded := added[key]
+		return inQuery || inAdded
+	}
+
+	if forward {
+		for key, values := range incoming {
+			if present(key) {
+				continue
+			}
+			added[key] = values
+		}
+	}
+
+	if !u.UTM.IsEmpty() {
+		defaults := [...]struct{ key, value string }{
+			{"utm_source", u.UTM.Source},
+			{"utm_medium", u.UTM.Medium},
+			{"utm_campaign", u.UTM.Campaign},
+		}
+		for _, param := range defaults {
+			if param.value == "" {
+				continue
+			}
+			if present(param.key) {
+				continue
+			}
+			added.Set(param.key, param.value)
+		}
+	}
+
+	if len(added) == 0 {
+		return target
+	}
+
+	if parsed.RawQuery != "" && !strings.HasSuffix(parsed.RawQuery, "&") {
+		parsed.RawQuery += "&"
+	}
+	parsed.RawQuery += added.Encode()
+	return parsed.String()
+}
@@ -1,6 +1,8 @@
 package domain
 
 import (
+	"net/http"
+	"net/url"
 	"testing"
 	"time"
 )
@@ -146,3 +148,411 @@ func TestAnalyticsResponse(t *testing.T) {
 		t.Error("expected device stats entry")
 	}
 }
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"already normalized", "https://example.com/path", "https://example.com/path"},
+		{"uppercase host and scheme", "HTTPS://Example.COM/Path", "https://example.com/Path"},
+		{"default port", "http://example.com:80/", "http://example.com/"},
+		{"custom port", "https://example.com:8443/", "https://example.com:8443/"},
+		{"empty path", "https://example.com", "https://example.com/"},
+		{"fragment", "https://example.com/page#section", "https://example.com/page"},
+		{"query order", "https://example.com/?b=2&a=1", "https://example.com/?a=1&b=2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeURL(tt.url); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestURLFingerprint(t *testing.T) {
+	if URLFingerprint("https://Example.com") != URLFingerprint("https://example.com/") {
+		t.Error("expected equivalent URLs to share a fingerprint")
+	}
+
+	if URLFingerprint("https://example.com/a") == URLFingerprint("https://example.com/b") {
+		t.Error("expected different URLs to have different fingerprints")
+	}
+
+	if len(URLFingerprint("https://example.com")) != 64 {
+		t.Error("expected hex-encoded SHA-256 fingerprint")
+	}
+}
+
+func TestURLRedirectStatus(t *testing.T) {
+	url := &URL{ShortCode: "abc123"}
+	if url.RedirectStatus() != http.StatusFound {
+		t.Errorf("expected legacy link to redirect with 302, got %d", url.RedirectStatus())
+	}
+
+	url.RedirectType = http.StatusMovedPermanently
+	if url.RedirectStatus() != http.StatusMovedPermanently {
+		t.Errorf("expected 301, got %d", url.RedirectStatus())
+	}
+
+	for status, valid := range map[int]bool{301: true, 302: true, 307: true, 308: true, 200: false, 303: false, 0: false} {
+		if IsValidRedirectType(status) != valid {
+			t.Errorf("IsValidRedirectType(%d) = %v, want %v", status, !valid, valid)
+		}
+	}
+}
+
+func TestURLDestination(t *testing.T) {
+	utm := &UTMParams{Source: "newsletter", Medium: "email"}
+
+	tests := []struct {
+		name     string
+		url      URL
+		incoming string
+		expected string
+	}{
+		{
+			name:     "query dropped without forwarding",
+			url:      URL{OriginalURL: "https://example.com/page"},
+			incoming: "ref=newsletter",
+			expected: "https://example.com/page",
+		},
+		{
+			name:     "query forwarded",
+			url:      URL{OriginalURL: "https://example.com/page?lang=en", ForwardQuery: true},
+			incoming: "ref=newsletter",
+			expected: "https://example.com/page?lang=en&ref=newsletter",
+		},
+		{
+			name:     "destination parameters win",
+			url:      URL{OriginalURL: "https://example.com/page?lang=en", ForwardQuery: true},
+			incoming: "lang=de",
+			expected: "https://example.com/page?lang=en",
+		},
+		{
+			name:     "utm defaults added",
+			url:      URL{OriginalURL: "https://example.com/page#top", UTM: utm},
+			expected: "https://example.com/page?utm_medium=email&utm_source=newsletter#top",
+		},
+		{
+			name:     "explicit utm kept",
+			url:      URL{OriginalURL: "https://example.com/page?utm_source=ads", UTM: utm},
+			expected: "https://example.com/page?utm_source=ads&utm_medium=email",
+		},
+		{
+			name:     "destination query kept as is",
+			url:      URL{OriginalURL: "https://example.com/page?z=1&flag&a=%7e", ForwardQuery: true, UTM: utm},
+			incoming: "ref=newsletter",
+			expected: "https://example.com/page?z=1&flag&a=%7e&ref=newsletter&utm_medium=email&utm_source=newsletter",
+		},
+		{
+			name:     "forwarded utm kept",
+			url:      URL{OriginalURL: "https://example.com/page", ForwardQuery: true, UTM: utm},
+			incoming: "utm_source=partner",
+			expected: "https://example.com/page?utm_medium=email&utm_source=partner",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			incoming, err := url.ParseQuery(tt.incoming)
+			if err != nil {
+				t.Fatalf("failed to parse query: %v", err)
+			}
+			if got := tt.url.Destination(tt.url.OriginalURL, incoming); got != tt.expected {
+				t.Errorf("Destination() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVisitorClassification(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		device    string
+		os        string
+	}{
+		{"iphone", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148", DeviceMobile, OSIOS},
+		{"ipad", "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15", DeviceTablet, OSIOS},
+		{"android phone", "Mozilla/5.0 (Linux; Android 14; Pixel 8) Chrome/120.0 Mobile Safari/537.36", DeviceMobile, OSAndroid},
+		{"android tablet", "Mozilla/5.0 (Linux; Android 14; SM-X910) Chrome/120.0 Safari/537.36", DeviceTablet, OSAndroid},
+		{"windows", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0", DeviceDesktop, OSWindows},
+		{"mac", "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) Safari/605.1.15", DeviceDesktop, OSMacOS},
+		{"linux", "Mozilla/5.0 (X11; Linux x86_64) Firefox/121.0", DeviceDesktop, OSLinux},
+		{"unknown", "curl/8.4.0", DeviceDesktop, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DeviceClass(tt.userAgent); got != tt.device {
+				t.Errorf("DeviceClass() = %q, want %q", got, tt.device)
+			}
+			if got := OSFamily(tt.userAgent); got != tt.os {
+				t.Errorf("OSFamily() = %q, want %q", got, tt.os)
+			}
+		})
+	}
+}
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		expected  UserAgent
+	}{
+		{"empty", "", UserAgent{}},
+		{
+			"chrome on windows",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.6478.127 Safari/537.36",
+			UserAgent{"chrome", "126", OSWindows, "10", DeviceDesktop},
+		},
+		{
+			"edge on windows 7",
+			"Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/109.0.0.0 Safari/537.36 Edg/109.0.1518.140",
+			UserAgent{"edge", "109", OSWindows, "7", DeviceDesktop},
+		},
+		{
+			"safari on mac",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15",
+			UserAgent{"safari", "17.4", OSMacOS, "10.15", DeviceDesktop},
+		},
+		{
+			"firefox on linux",
+			"Mozilla/5.0 (X11; Linux x86_64; rv:127.0) Gecko/20100101 Firefox/127.0",
+			UserAgent{"firefox", "127", OSLinux, "", DeviceDesktop},
+		},
+		{
+			"chrome on chromebook",
+			"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36",
+			UserAgent{"chrome", "126", OSChromeOS, "", DeviceDesktop},
+		},
+		{
+			"safari on iphone",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+			UserAgent{"safari", "17.5", OSIOS, "17.5", DeviceMobile},
+		},
+		{
+			"chrome on ipad",
+			"Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/126.0.6478.54 Mobile/15E148 Safari/604.1",
+			UserAgent{"chrome", "126", OSIOS, "17", DeviceTablet},
+		},
+		{
+			"in-app browser on iphone",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148",
+			UserAgent{"webview", "", OSIOS, "16.6", DeviceMobile},
+		},
+		{
+			"samsung internet on android phone",
+			"Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/25.0 Chrome/121.0.0.0 Mobile Safari/537.36",
+			UserAgent{"samsung", "25", OSAndroid, "14", DeviceMobile},
+		},
+		{
+			"android tablet",
+			"Mozilla/5.0 (Linux; Android 13; SM-X910) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			UserAgent{"chrome", "120", OSAndroid, "13", DeviceTablet},
+		},
+		{
+			"android webview",
+			"Mozilla/5.0 (Linux; Android 12; Pixel 6; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/119.0.6045.163 Mobile Safari/537.36",
+			UserAgent{"webview", "119", OSAndroid, "12", DeviceMobile},
+		},
+		{
+			"yandex browser",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 YaBrowser/24.4.0.0 Safari/537.36",
+			UserAgent{"yandex", "24.4", OSWindows, "10", DeviceDesktop},
+		},
+		{
+			"internet explorer",
+			"Mozilla/5.0 (Windows NT 6.3; Trident/7.0; rv:11.0) like Gecko",
+			UserAgent{"ie", "11", OSWindows, "8.1", DeviceDesktop},
+		},
+		{
+			"kindle",
+			"Mozilla/5.0 (Linux; Android 9; KFTRWI) AppleWebKit/537.36 (KHTML, like Gecko) Silk/124.2.1 like Chrome/124.0.6367.219 Safari/537.36",
+			UserAgent{"chrome", "124", OSAndroid, "9", DeviceTablet},
+		},
+		{
+			"smart tv",
+			"Mozilla/5.0 (Web0S; Linux/SmartTV) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/94.0.4606.128 Safari/537.36 WebAppManager",
+			UserAgent{"chrome", "94", OSLinux, "", DeviceTV},
+		},
+		{
+			"fire tv",
+			"Mozilla/5.0 (Linux; Android 9; AFTKA Build/PS7633) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/113.0.5672.162 Mobile Safari/537.36",
+			UserAgent{"chrome", "113", OSAndroid, "9", DeviceTV},
+		},
+		{
+			"playstation",
+			"Mozilla/5.0 (PlayStation; PlayStation 5/2.26) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0 Safari/605.1.15",
+			UserAgent{"safari", "13", "", "", DeviceConsole},
+		},
+		{"bot", "Googlebot/2.1 (+http://www.google.com/bot.html)", UserAgent{"", "", "", "", DeviceBot}},
+		{
+			"over-long versions",
+			"Mozilla/5.0 (Linux; Android 11111111111111111111.1; Pixel 8) Chrome/11111111111111111111.1 Mobile Safari/537.36",
+			UserAgent{"chrome", "", OSAndroid, "", DeviceMobile},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseUserAgent(tt.userAgent); got != tt.expected {
+				t.Errorf("ParseUserAgent() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := map[string]string{
+		"":                           "",
+		"de-DE":                      "de-de",
+		"en;q=0.5, pt-BR, pt;q=0.9":  "pt-br",
+		"*, fr;q=0.8":                "fr",
+		"en;q=invalid, es;q=0.3":     "es",
+		"ru-RU,ru;q=0.9,en-US;q=0.8": "ru-ru",
+	}
+
+	for header, expected := range tests {
+		if got := PreferredLanguage(header); got != expected {
+			t.Errorf("PreferredLanguage(%q) = %q, want %q", header, got, expected)
+		}
+	}
+}
+
+func TestURLTarget(t *testing.T) {
+	url := &URL{
+		OriginalURL: "https://example.com",
+		Targeting: []TargetingRule{
+			{Device: DeviceMobile, OS: OSIOS, URL: "https://example.com/ios"},
+			{Device: DeviceMobile, URL: "https://example.com/mobile"},
+			{Language: "de", URL: "https://example.com/de"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		visitor  Visitor
+		expected string
+	}{
+		{"first matching rule wins", Visitor{Device: DeviceMobile, OS: OSIOS, Language: "de"}, "https://example.com/ios"},
+		{"partial match skipped", Visitor{Device: DeviceMobile, OS: OSAndroid}, "https://example.com/mobile"},
+		{"language prefix", Visitor{Device: DeviceDesktop, Language: "de-at"}, "https://example.com/de"},
+		{"language is not a string prefix", Visitor{Device: DeviceDesktop, Language: "dem"}, "https://example.com"},
+		{"fallback", Visitor{Device: DeviceDesktop, Language: "en"}, "https://example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := url.Target(tt.visitor); got != tt.expected {
+				t.Errorf("Target() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestURLPickVariant(t *testing.T) {
+	url := &URL{Variants: []Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 1},
+		{Name: "b", URL: "https://example.com/b", Weight: 3},
+	}}
+
+	if url.VariantWeight() != 4 {
+		t.Fatalf("expected total weight 4, got %d", url.VariantWeight())
+	}
+
+	expected := []string{"a", "b", "b", "b"}
+	for roll, name := range expected {
+		if v := url.PickVariant(roll); v == nil || v.Name != name {
+			t.Errorf("PickVariant(%d) = %v, want %s", roll, v, name)
+		}
+	}
+
+	if v := url.Variant("b"); v == nil || v.URL != "https://example.com/b" {
+		t.Errorf("expected to find variant b, got %v", v)
+	}
+	if v := url.Variant("c"); v != nil {
+		t.Errorf("expected no variant c, got %v", v)
+	}
+}
+
+func TestIsValidAppURL(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected bool
+	}{
+		{"myapp://item/42", true},
+		{"https://app.example.com/item/42", true},
+		{"https://apps.apple.com/app/id123", true},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{"data:text/html,hi", false},
+		{"item/42", false},
+		{"https:///item", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := IsValidAppURL(tt.raw); got != tt.expected {
+				t.Errorf("IsValidAppURL(%q) = %v, want %v", tt.raw, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsBot(t *testing.T) {
+	const (
+		chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36"
+		accept = "text/html,application/xhtml+xml"
+	)
+
+	tests := []struct {
+		name      string
+		method    string
+		userAgent string
+		accept    string
+		expected  bool
+	}{
+		{"browser", "GET", chrome, accept, false},
+		{"head request", "HEAD", chrome, accept, true},
+		{"missing accept", "GET", chrome, "", true},
+		{"missing user agent", "GET", "", accept, true},
+		{"slack unfurler", "GET", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", "*/*", true},
+		{"telegram", "GET", "TelegramBot (like TwitterBot)", "*/*", true},
+		{"facebook", "GET", "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", "*/*", true},
+		{"curl", "GET", "curl/8.4.0", "*/*", true},
+		{"slack image proxy", "GET", "Slack-ImgProxy (+https://api.slack.com/robots)", "*/*", true},
+		{"tumblr crawler", "GET", "Tumblr/14.0.835.186 (crawler)", "*/*", true},
+
+		// In-app browsers carry the app name but are people
+		{"linkedin app", "GET", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 [LinkedInApp]/9.29.1", accept, false},
+		{"pinterest app", "GET", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 [Pinterest/iOS]", accept, false},
+		{"snapchat app", "GET", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 Snapchat/12.80.0.40 (like Safari/8617.2.4.10.8, panda)", accept, false},
+		{"telegram app", "GET", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Mobile Safari/537.36 Telegram-Android/10.14.5", accept, false},
+		{"discord app", "GET", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Mobile Safari/537.36 Discord/230.16", accept, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsBot(tt.method, tt.userAgent, tt.accept); got != tt.expected {
+				t.Errorf("IsBot() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOldestVisitorPeriods(t *testing.T) {
+	now := time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC)
+
+	day, month := OldestVisitorPeriods(now)
+	if day != "2025-04-01" {
+		t.Errorf("expected oldest day 2025-04-01, got %s", day)
+	}
+	if month != "2024-04" {
+		t.Errorf("expected oldest month 2024-04, got %s", month)
+	}
+}
@@ -6,4 +6,10 @@ var (
 	ErrURLNotFound     = errors.New("url not found")
 	ErrShortCodeExists = errors.New("short code already exists")
 	ErrInvalidURL      = errors.New("invalid url")
+	ErrURLExpired      = errors.New("url expired")
+	ErrURLDisabled     = errors.New("url disabled")
+	ErrURLDeleted      = errors.New("url deleted")
+	ErrDomainNotFound  = errors.New("domain not found")
+	ErrDomainExists    = errors.New("domain already exists")
+	ErrDomainInUse     = errors.New("domain has active links")
 )
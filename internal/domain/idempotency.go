@@ -0,0 +1,9 @@
+package domain
+
+// IdempotencyRecord is the stored outcome of a request sent with an
+// Idempotency-Key header. A record with a zero StatusCode is still in flight.
+type IdempotencyRecord struct {
+	RequestHash string `json:"request_hash"`
+	StatusCode  int    `json:"status_code"`
+	Body        []byte `json:"body"`
+}
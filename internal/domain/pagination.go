@@ -0,0 +1,32 @@
+package domain
+
+import "time"
+
+// URLOrder is the sort order of link listings
+type URLOrder string
+
+const (
+	URLOrderCreated URLOrder = "created"
+	URLOrderClicks  URLOrder = "clicks"
+)
+
+// URLCursor is the position of the last link of a page. The next page starts
+// right after it in the requested order.
+type URLCursor struct {
+	CreatedAt time.Time
+	Clicks    int64
+	ID        int64
+}
+
+// ListURLsQuery describes a single page of a link listing
+type ListURLsQuery struct {
+	Limit int
+	Tags  []string
+	Order URLOrder
+	After *URLCursor
+}
+
+type URLPage struct {
+	URLs       []*URL `json:"urls"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
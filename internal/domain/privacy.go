@@ -0,0 +1,23 @@
+package domain
+
+// IPPrivacyMode is how client IP addresses of clicks are stored
+type IPPrivacyMode string
+
+const (
+	// IPPrivacyOff stores addresses as they are
+	IPPrivacyOff IPPrivacyMode = "off"
+	// IPPrivacyTruncate keeps only the network prefix of an address
+	IPPrivacyTruncate IPPrivacyMode = "truncate"
+	// IPPrivacyHash replaces addresses with a keyed hash whose key rotates,
+	// so the same address can only be matched within one rotation period
+	IPPrivacyHash IPPrivacyMode = "hash"
+)
+
+// IsValid reports whether m is a known mode
+func (m IPPrivacyMode) IsValid() bool {
+	switch m {
+	case IPPrivacyOff, IPPrivacyTruncate, IPPrivacyHash:
+		return true
+	}
+	return false
+}
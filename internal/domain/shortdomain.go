@@ -0,0 +1,20 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// ShortDomain is an additional host that links can be created on. Links
+// without a domain belong to the host of the configured base URL.
+type ShortDomain struct {
+	Host      string    `json:"domain"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NormalizeHost lowercases a host name and drops a trailing dot so that
+// hosts taken from requests and from the admin API compare equal. The port,
+// if any, is kept.
+func NormalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
@@ -0,0 +1,137 @@
+package domain
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Device classes and operating systems targeting rules can match on
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+
+	OSIOS     = "ios"
+	OSAndroid = "android"
+	OSWindows = "windows"
+	OSMacOS   = "macos"
+	OSLinux   = "linux"
+)
+
+var languageTagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// TargetingRule sends visitors matching all of its conditions to URL.
+// Empty conditions match everything; a rule has at least one condition.
+type TargetingRule struct {
+	Device   string `json:"device,omitempty"`
+	OS       string `json:"os,omitempty"`
+	Language string `json:"language,omitempty"`
+	URL      string `json:"url"`
+}
+
+// Visitor is what targeting rules are evaluated against
+type Visitor struct {
+	Device   string
+	OS       string
+	Language string
+}
+
+// NewVisitor classifies a request by its User-Agent and Accept-Language
+// headers.
+func NewVisitor(userAgent, acceptLanguage string) Visitor {
+	return Visitor{
+		Device:   DeviceClass(userAgent),
+		OS:       OSFamily(userAgent),
+		Language: PreferredLanguage(acceptLanguage),
+	}
+}
+
+// Matches reports whether the visitor satisfies every condition of the rule.
+// The language condition is a prefix: "pt" matches both "pt" and "pt-br".
+func (r TargetingRule) Matches(v Visitor) bool {
+	if r.Device != "" && r.Device != v.Device {
+		return false
+	}
+	if r.OS != "" && r.OS != v.OS {
+		return false
+	}
+	if r.Language != "" && v.Language != r.Language && !strings.HasPrefix(v.Language, r.Language+"-") {
+		return false
+	}
+	return true
+}
+
+// IsValid reports whether the rule has at least one known condition
+func (r TargetingRule) IsValid() bool {
+	if r.Device == "" && r.OS == "" && r.Language == "" {
+		return false
+	}
+	switch r.Device {
+	case "", DeviceDesktop, DeviceMobile, DeviceTablet:
+	default:
+		return false
+	}
+	switch r.OS {
+	case "", OSIOS, OSAndroid, OSWindows, OSMacOS, OSLinux:
+	default:
+		return false
+	}
+	return r.Language == "" || languageTagPattern.MatchString(r.Language)
+}
+
+// Target returns the destination of the first rule matching the visitor.
+// When none does it returns the original URL and false.
+func (u *URL) Target(v Visitor) (string, bool) {
+	for _, rule := range u.Targeting {
+		if rule.Matches(v) {
+			return rule.URL, true
+		}
+	}
+	return u.OriginalURL, false
+}
+
+// DeviceClass guesses the device class from a User-Agent header. Devices
+// targeting rules cannot name, such as TVs, count as desktops.
+func DeviceClass(userAgent string) string {
+	switch device := ParseUserAgent(userAgent).Device; device {
+	case DeviceMobile, DeviceTablet:
+		return device
+	default:
+		return DeviceDesktop
+	}
+}
+
+// OSFamily guesses the operating system from a User-Agent header. It
+// returns an empty string when the system is not recognized.
+func OSFamily(userAgent string) string {
+	os, _ := parseOS(userAgent)
+	return os
+}
+
+// PreferredLanguage returns the lowercased language tag with the highest
+// quality value in an Accept-Language header.
+func PreferredLanguage(acceptLanguage string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
@@ -1,25 +1,151 @@
 package domain
 
 import (
+	"net/http"
 	"time"
 )
 
+// DefaultRedirectType is used for links created without an explicit
+// redirect type.
+const DefaultRedirectType = http.StatusFound
+
+// IsValidRedirectType reports whether status may be used to follow a link
+func IsValidRedirectType(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
 type URL struct {
-	ID          int64     `json:"id" db:"id"`
-	ShortCode   string    `json:"short_code" db:"short_code"`
-	OriginalURL string    `json:"original_url" db:"original_url"`
-	CustomAlias *string   `json:"custom_alias,omitempty" db:"custom_alias"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	Clicks      int64     `json:"clicks" db:"clicks"`
+	ID           int64      `json:"id" db:"id"`
+	Domain       string     `json:"domain,omitempty" db:"domain"`
+	ShortCode    string     `json:"short_code" db:"short_code"`
+	OriginalURL  string     `json:"original_url" db:"original_url"`
+	CustomAlias  *string    `json:"custom_alias,omitempty" db:"custom_alias"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	Clicks       int64      `json:"clicks" db:"clicks"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	MaxClicks    *int64     `json:"max_clicks,omitempty" db:"max_clicks"`
+	DisabledAt   *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	Tags         []string   `json:"tags,omitempty" db:"tags"`
+	RedirectType int        `json:"redirect_type" db:"redirect_type"`
+	ForwardQuery bool       `json:"forward_query,omitempty" db:"forward_query"`
+	UTM          *UTMParams `json:"utm,omitempty"`
+
+	// ForwardPath lets /s/<code>/<path> resolve through the link, appending
+	// the remaining path to the destination.
+	ForwardPath bool `json:"forward_path,omitempty" db:"forward_path"`
+
+	// Targeting rules are evaluated in order, see Target
+	Targeting []TargetingRule `json:"targeting,omitempty"`
+
+	// Variants split the remaining traffic by weight. With StickyVariants
+	// a returning visitor keeps getting the same variant.
+	Variants       []Variant `json:"variants,omitempty"`
+	StickyVariants bool      `json:"sticky_variants,omitempty"`
+
+	// Interstitial makes redirects to external destinations go through a
+	// page showing where the link leads instead of redirecting directly.
+	Interstitial bool `json:"interstitial,omitempty" db:"interstitial"`
+
+	// DeepLinks take visitors on iOS and Android to the app instead
+	DeepLinks
+
+	// PasswordHash never leaves the store; Protected is what gets cached
+	// and returned to clients.
+	PasswordHash *string `json:"-" db:"password_hash"`
+	Protected    bool    `json:"protected,omitempty"`
+}
+
+// IsExpired reports whether the link has passed its expiration date
+// or used up its click budget.
+func (u *URL) IsExpired(now time.Time) bool {
+	if u.ExpiresAt != nil && !now.Before(*u.ExpiresAt) {
+		return true
+	}
+	if u.MaxClicks != nil && u.Clicks >= *u.MaxClicks {
+		return true
+	}
+	return false
+}
+
+// IsDisabled reports whether the link has been temporarily switched off.
+func (u *URL) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+// RedirectStatus returns the status code to redirect with. Links cached
+// before redirect types were introduced fall back to 302.
+func (u *URL) RedirectStatus() int {
+	if u.RedirectType == 0 {
+		return DefaultRedirectType
+	}
+	return u.RedirectType
+}
+
+// IsDeleted reports whether the link has been removed. Deleted links are
+// kept as tombstones so their short code cannot be registered again.
+func (u *URL) IsDeleted() bool {
+	return u.DeletedAt != nil
 }
 
 type CreateURLRequest struct {
-	URL         string  `json:"url"`
-	CustomAlias *string `json:"custom_alias,omitempty"`
+	URL          string     `json:"url"`
+	Domain       string     `json:"domain,omitempty"`
+	CustomAlias  *string    `json:"custom_alias,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxClicks    *int64     `json:"max_clicks,omitempty"`
+	Password     *string    `json:"password,omitempty"`
+	Dedupe       *bool      `json:"dedupe,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	RedirectType *int       `json:"redirect_type,omitempty"`
+	ForwardQuery bool       `json:"forward_query,omitempty"`
+	ForwardPath  bool       `json:"forward_path,omitempty"`
+	UTM          *UTMParams `json:"utm,omitempty"`
+
+	Targeting      []TargetingRule `json:"targeting,omitempty"`
+	Variants       []Variant       `json:"variants,omitempty"`
+	StickyVariants bool            `json:"sticky_variants,omitempty"`
+	Interstitial   bool            `json:"interstitial,omitempty"`
+
+	DeepLinks
 }
 
 type CreateURLResponse struct {
-	ShortCode   string `json:"short_code"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	Domain       string     `json:"domain,omitempty"`
+	ShortCode    string     `json:"short_code"`
+	ShortURL     string     `json:"short_url"`
+	OriginalURL  string     `json:"original_url"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxClicks    *int64     `json:"max_clicks,omitempty"`
+	Protected    bool       `json:"protected,omitempty"`
+	Existing     bool       `json:"existing,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	RedirectType int        `json:"redirect_type"`
+	ForwardQuery bool       `json:"forward_query,omitempty"`
+	ForwardPath  bool       `json:"forward_path,omitempty"`
+	UTM          *UTMParams `json:"utm,omitempty"`
+
+	Targeting      []TargetingRule `json:"targeting,omitempty"`
+	Variants       []Variant       `json:"variants,omitempty"`
+	StickyVariants bool            `json:"sticky_variants,omitempty"`
+	Interstitial   bool            `json:"interstitial,omitempty"`
+
+	DeepLinks
+}
+
+// TagCount is the number of active links carrying a tag
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// BatchCreateResult is the outcome of a single item of a batch create request.
+// Exactly one of Response and Err is set.
+type BatchCreateResult struct {
+	Response *CreateURLResponse
+	Err      error
 }
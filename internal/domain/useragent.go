@@ -0,0 +1,214 @@
+package domain
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Device classes only reported in analytics; targeting rules treat them as
+// desktop
+const (
+	DeviceTV      = "tv"
+	DeviceConsole = "console"
+	DeviceBot     = "bot"
+)
+
+// Operating systems only reported in analytics
+const (
+	OSChromeOS     = "chromeos"
+	OSWindowsPhone = "windows phone"
+)
+
+// UserAgent is what is known about the client of a click from its
+// User-Agent header. Fields are empty when they cannot be told.
+type UserAgent struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	OSVersion      string
+	Device         string
+}
+
+// browserRule recognizes a browser by a product token. Rules are checked in
+// order, so browsers built on Chrome or Safari, which also carry their
+// tokens, come first.
+type browserRule struct {
+	family string
+	tokens []string
+}
+
+var browserRules = []browserRule{
+	// In-app browsers of social networks
+	{"facebook", []string{"FBAV/", "FBAN/"}},
+	{"instagram", []string{"Instagram "}},
+
+	{"edge", []string{"Edg/", "EdgA/", "EdgiOS/", "Edge/"}},
+	{"opera", []string{"OPR/", "OPiOS/", "OPT/", "Opera Mini/"}},
+	{"yandex", []string{"YaBrowser/", "YaSearchBrowser/"}},
+	{"samsung", []string{"SamsungBrowser/"}},
+	{"ucbrowser", []string{"UCBrowser/"}},
+	{"vivaldi", []string{"Vivaldi/"}},
+	{"firefox", []string{"FxiOS/", "Firefox/"}},
+	{"chromium", []string{"Chromium/"}},
+	{"chrome", []string{"CriOS/", "Chrome/"}},
+	{"ie", []string{"MSIE ", "rv:"}},
+}
+
+var (
+	versionPattern = regexp.MustCompile(`^[0-9]+(?:[._][0-9]+)*`)
+
+	iosVersionPattern     = regexp.MustCompile(`(?:iPhone|CPU) OS ([0-9_]+)`)
+	androidVersionPattern = regexp.MustCompile(`Android[ /]?([0-9.]+)`)
+	windowsVersionPattern = regexp.MustCompile(`Windows NT ([0-9.]+)`)
+	macVersionPattern     = regexp.MustCompile(`Mac OS X ([0-9_.]+)`)
+
+	// Android tablets that say "Mobile" anyway, and other tablets that do
+	// not mention Android or iPad
+	tabletPattern = regexp.MustCompile(`(?i)tablet|ipad|kindle|silk/|playbook|nexus (7|9|10)\b|sm-[tpx][0-9]|lenovo tab|mediapad|mi pad|kftt|kfot`)
+	// Fire TV models are only told apart by their AFT prefix, in capitals
+	tvPattern      = regexp.MustCompile(`(?i:smart-?tv|googletv|android tv|appletv|web0s|webos\.tv|netcast|bravia|hbbtv|roku|crkey|tizen.*tv|viera)|\bAFT[A-Z]`)
+	consolePattern = regexp.MustCompile(`(?i)playstation|xbox|nintendo`)
+	mobilePattern  = regexp.MustCompile(`(?i)mobi|iphone|ipod|windows phone|opera mini|blackberry|bb10|kaios|iemobile`)
+)
+
+// maxVersionLength is the width of the version columns of click_events.
+// Longer versions are not real ones and are dropped.
+const maxVersionLength = 16
+
+// windowsReleases maps Windows NT versions to release names
+var windowsReleases = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+	"6.0":  "vista",
+	"5.2":  "xp",
+	"5.1":  "xp",
+}
+
+// ParseUserAgent classifies a User-Agent header. Browser and OS families are
+// lowercase names such as "chrome" or OSIOS; versions keep at most the major
+// and minor number.
+func ParseUserAgent(userAgent string) UserAgent {
+	var ua UserAgent
+	if strings.TrimSpace(userAgent) == "" {
+		return ua
+	}
+
+	ua.OS, ua.OSVersion = parseOS(userAgent)
+	ua.Browser, ua.BrowserVersion = parseBrowser(userAgent, ua.OS)
+	ua.Device = parseDevice(userAgent, ua.OS)
+
+	return ua
+}
+
+func parseOS(userAgent string) (string, string) {
+	switch {
+	case strings.Contains(userAgent, "Windows Phone"):
+		return OSWindowsPhone, ""
+	// iOS and Android agents also mention Mac OS X and Linux
+	case strings.Contains(userAgent, "iPhone") || strings.Contains(userAgent, "iPad") || strings.Contains(userAgent, "iPod"):
+		return OSIOS, submatchVersion(iosVersionPattern, userAgent)
+	case strings.Contains(userAgent, "Android"):
+		return OSAndroid, submatchVersion(androidVersionPattern, userAgent)
+	case strings.Contains(userAgent, "CrOS"):
+		return OSChromeOS, ""
+	case strings.Contains(userAgent, "Windows"):
+		if match := windowsVersionPattern.FindStringSubmatch(userAgent); match != nil {
+			return OSWindows, windowsReleases[match[1]]
+		}
+		return OSWindows, ""
+	case strings.Contains(userAgent, "Mac OS X") || strings.Contains(userAgent, "Macintosh"):
+		return OSMacOS, submatchVersion(macVersionPattern, userAgent)
+	case strings.Contains(userAgent, "Linux") || strings.Contains(userAgent, "X11"):
+		return OSLinux, ""
+	}
+	return "", ""
+}
+
+func parseBrowser(userAgent, os string) (string, string) {
+	for _, rule := range browserRules {
+		for _, token := range rule.tokens {
+			i := strings.Index(userAgent, token)
+			if i < 0 {
+				continue
+			}
+			// "rv:" only marks Internet Explorer together with Trident
+			if token == "rv:" && !strings.Contains(userAgent, "Trident/") {
+				continue
+			}
+
+			family := rule.family
+			// Android WebView reports itself as Chrome with a "wv" marker
+			if family == "chrome" && strings.Contains(userAgent, "; wv)") {
+				family = "webview"
+			}
+			return family, versionAt(userAgent, i+len(token))
+		}
+	}
+
+	if strings.Contains(userAgent, "AppleWebKit/") {
+		if i := strings.Index(userAgent, "Version/"); i >= 0 && strings.Contains(userAgent, "Safari/") {
+			return "safari", versionAt(userAgent, i+len("Version/"))
+		}
+		// Apps on iOS embed WebKit without the Safari token
+		if os == OSIOS {
+			return "webview", ""
+		}
+	}
+
+	return "", ""
+}
+
+func parseDevice(userAgent, os string) string {
+	if isBotAgent(strings.ToLower(userAgent)) {
+		return DeviceBot
+	}
+
+	switch {
+	case tvPattern.MatchString(userAgent):
+		return DeviceTV
+	case consolePattern.MatchString(userAgent):
+		return DeviceConsole
+	case tabletPattern.MatchString(userAgent):
+		return DeviceTablet
+	// Android phones say "Mobile"; Android without it is a tablet
+	case os == OSAndroid && !strings.Contains(userAgent, "Mobile"):
+		return DeviceTablet
+	case mobilePattern.MatchString(userAgent):
+		return DeviceMobile
+	case os != "":
+		return DeviceDesktop
+	}
+	return ""
+}
+
+// versionAt reads the version number starting at offset i of userAgent
+func versionAt(userAgent string, i int) string {
+	return trimVersion(versionPattern.FindString(userAgent[i:]))
+}
+
+func submatchVersion(pattern *regexp.Regexp, userAgent string) string {
+	match := pattern.FindStringSubmatch(userAgent)
+	if match == nil {
+		return ""
+	}
+	return trimVersion(match[1])
+}
+
+// trimVersion normalizes separators to dots and keeps major.minor. A zero
+// minor number is dropped, so "14.0" and "14" group together. Versions longer
+// than maxVersionLength are dropped.
+func trimVersion(version string) string {
+	parts := strings.Split(strings.ReplaceAll(version, "_", "."), ".")
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	if len(parts) == 2 && strings.Trim(parts[1], "0") == "" {
+		parts = parts[:1]
+	}
+	if version = strings.Join(parts, "."); len(version) > maxVersionLength {
+		return ""
+	}
+	return version
+}
@@ -0,0 +1,40 @@
+package domain
+
+// Variant is one of the weighted destinations of a split-test link
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// VariantWeight returns the sum of the weights of the link's variants
+func (u *URL) VariantWeight() int {
+	total := 0
+	for _, v := range u.Variants {
+		total += v.Weight
+	}
+	return total
+}
+
+// PickVariant returns the variant roll falls into, where roll is drawn
+// uniformly from [0, VariantWeight()). It returns nil for links without
+// variants.
+func (u *URL) PickVariant(roll int) *Variant {
+	for i := range u.Variants {
+		if roll < u.Variants[i].Weight {
+			return &u.Variants[i]
+		}
+		roll -= u.Variants[i].Weight
+	}
+	return nil
+}
+
+// Variant returns the variant with the given name, or nil
+func (u *URL) Variant(name string) *Variant {
+	for i := range u.Variants {
+		if u.Variants[i].Name == name {
+			return &u.Variants[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package domain
+
+import "time"
+
+const (
+	// VisitorDayLayout and VisitorMonthLayout format the periods unique
+	// visitors are counted in, always in UTC
+	VisitorDayLayout   = "2006-01-02"
+	VisitorMonthLayout = "2006-01"
+
+	// MaxVisitorDays and MaxVisitorMonths are the longest ranges unique
+	// visitors are reported for
+	MaxVisitorDays   = 365
+	MaxVisitorMonths = 24
+)
+
+// UniqueVisitors is the estimated number of distinct visitors of a link.
+// Visitors are told apart by a salted fingerprint of their IP address and
+// User-Agent, so the counts are approximate.
+type UniqueVisitors struct {
+	Total   int64            `json:"total"`
+	Daily   map[string]int64 `json:"daily"`
+	Monthly map[string]int64 `json:"monthly"`
+}
+
+// VisitorPeriods returns the day and month a click made at t is counted in
+func VisitorPeriods(t time.Time) (day, month string) {
+	t = t.UTC()
+	return t.Format(VisitorDayLayout), t.Format(VisitorMonthLayout)
+}
+
+// OldestVisitorPeriods returns the oldest day and month that unique visitors
+// can still be reported for at now. Sketches of earlier periods are no
+// longer needed.
+func OldestVisitorPeriods(now time.Time) (day, month string) {
+	now = now.UTC()
+	// Step back from the middle of the month so AddDate never skips one
+	mid := time.Date(now.Year(), now.Month(), 15, 0, 0, 0, 0, time.UTC)
+	return now.AddDate(0, 0, -(MaxVisitorDays - 1)).Format(VisitorDayLayout),
+		mid.AddDate(0, -(MaxVisitorMonths - 1), 0).Format(VisitorMonthLayout)
+}
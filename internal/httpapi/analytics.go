@@ -19,7 +19,7 @@ func (h *Handler) GetAnalytics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	analytics, err := h.analyticsService.GetAnalytics(r.Context(), shortCode)
+	analytics, err := h.analyticsService.GetAnalytics(r.Context(), linkDomain(r), shortCode)
 	if err != nil {
 		if service.IsNotFound(err) {
 			h.respondError(w, "URL not found", http.StatusNotFound)
@@ -45,7 +45,7 @@ func (h *Handler) GetDailyStats(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	stats, err := h.analyticsService.GetDailyStats(r.Context(), shortCode, days)
+	stats, err := h.analyticsService.GetDailyStats(r.Context(), linkDomain(r), shortCode, days)
 	if err != nil {
 		if service.IsNotFound(err) {
 			h.respondError(w, "URL not found", http.StatusNotFound)
@@ -71,7 +71,7 @@ func (h *Handler) GetMonthlyStats(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	stats, err := h.analyticsService.GetMonthlyStats(r.Context(), shortCode, days)
+	stats, err := h.analyticsService.GetMonthlyStats(r.Context(), linkDomain(r), shortCode, days)
 	if err != nil {
 		if service.IsNotFound(err) {
 			h.respondError(w, "URL not found", http.StatusNotFound)
@@ -90,7 +90,112 @@ func (h *Handler) GetDeviceStats(w http.ResponseWriter, r *http.Request) {
 
 	logger.Info("GetDeviceStats called", "short_code", shortCode)
 
-	stats, err := h.analyticsService.GetDeviceStats(r.Context(), shortCode)
+	stats, err := h.analyticsService.GetDeviceStats(r.Context(), linkDomain(r), shortCode)
+	if err != nil {
+		if service.IsNotFound(err) {
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		} else {
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, stats, http.StatusOK)
+}
+
+// GetBrowserStats returns the clicks of a link by browser, and by browser
+// version with ?versions=true
+func (h *Handler) GetBrowserStats(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	versions, _ := strconv.ParseBool(r.URL.Query().Get("versions"))
+
+	stats, err := h.analyticsService.GetBrowserStats(r.Context(), linkDomain(r), shortCode, versions)
+	if err != nil {
+		if service.IsNotFound(err) {
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		} else {
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, stats, http.StatusOK)
+}
+
+// GetOSStats returns the clicks of a link by operating system, and by its
+// version with ?versions=true
+func (h *Handler) GetOSStats(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	versions, _ := strconv.ParseBool(r.URL.Query().Get("versions"))
+
+	stats, err := h.analyticsService.GetOSStats(r.Context(), linkDomain(r), shortCode, versions)
+	if err != nil {
+		if service.IsNotFound(err) {
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		} else {
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, stats, http.StatusOK)
+}
+
+func (h *Handler) GetVariantStats(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	stats, err := h.analyticsService.GetVariantStats(r.Context(), linkDomain(r), shortCode)
+	if err != nil {
+		if service.IsNotFound(err) {
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		} else {
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, stats, http.StatusOK)
+}
+
+// GetUniqueVisitors returns the estimated unique visitors of a link for the
+// last ?days= days and ?months= months
+func (h *Handler) GetUniqueVisitors(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	var days, months int
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 {
+		days = d
+	}
+	if m, err := strconv.Atoi(r.URL.Query().Get("months")); err == nil && m > 0 {
+		months = m
+	}
+
+	visitors, err := h.analyticsService.GetUniqueVisitors(r.Context(), linkDomain(r), shortCode, days, months)
+	if err != nil {
+		if service.IsNotFound(err) {
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		} else {
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, map[string]any{"unique_visitors": visitors}, http.StatusOK)
+}
+
+// GetBotStats returns the traffic classified as bots, which the other
+// analytics endpoints leave out
+func (h *Handler) GetBotStats(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	stats, err := h.analyticsService.GetBotStats(r.Context(), linkDomain(r), shortCode)
 	if err != nil {
 		if service.IsNotFound(err) {
 			h.respondError(w, "URL not found", http.StatusNotFound)
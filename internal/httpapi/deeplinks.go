@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/logger"
+	"github.com/MyNameIsWhaaat/shortener/internal/service"
+	"github.com/MyNameIsWhaaat/shortener/internal/ui"
+	"github.com/gorilla/mux"
+)
+
+// SetDeepLinks replaces the app URLs of a link
+func (h *Handler) SetDeepLinks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	var req domain.DeepLinks
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	url, err := h.shortenerService.SetDeepLinks(r.Context(), linkDomain(r), shortCode, req)
+	if err != nil {
+		switch {
+		case service.IsNotFound(err):
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		case err == service.ErrInvalidShortCode:
+			h.respondError(w, "Short code is required", http.StatusBadRequest)
+		case err == service.ErrInvalidDeepLink:
+			h.respondError(w, "Invalid deep link", http.StatusBadRequest)
+		default:
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, url, http.StatusOK)
+}
+
+// openApp renders the bridge page that tries to open app and sends the
+// visitor to the fallback URL, or the regular destination, when the app is
+// not installed.
+func (h *Handler) openApp(w http.ResponseWriter, r *http.Request, url *domain.URL, app string) {
+	fallback := url.FallbackURL
+	if fallback == "" {
+		fallback = url.Destination(url.OriginalURL, r.URL.Query())
+	}
+
+	logger.Info("Opening app", "short_code", url.ShortCode, "app_url", app, "fallback", fallback)
+	bridge := ui.Bridge{ShortCode: url.ShortCode, AppURL: app, FallbackURL: fallback}
+	if err := ui.RenderBridge(w, bridge, http.StatusOK); err != nil {
+		logger.Error("Failed to render app bridge", "short_code", url.ShortCode, "error", err)
+	}
+}
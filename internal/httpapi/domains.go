@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/service"
+	"github.com/gorilla/mux"
+)
+
+// domainParam selects the short domain of the link a management or
+// analytics request is about. Without it the default domain is used.
+const domainParam = "domain"
+
+type addDomainRequest struct {
+	Domain string `json:"domain"`
+}
+
+func linkDomain(r *http.Request) string {
+	return domain.NormalizeHost(r.URL.Query().Get(domainParam))
+}
+
+func (h *Handler) ListDomains(w http.ResponseWriter, r *http.Request) {
+	domains, err := h.shortenerService.ListDomains(r.Context())
+	if err != nil {
+		h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.respond(w, domains, http.StatusOK)
+}
+
+func (h *Handler) AddDomain(w http.ResponseWriter, r *http.Request) {
+	var req addDomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	d, err := h.shortenerService.AddDomain(r.Context(), req.Domain)
+	if err != nil {
+		switch err {
+		case service.ErrInvalidDomain:
+			h.respondError(w, "Invalid domain", http.StatusBadRequest)
+		case service.ErrDomainExists:
+			h.respondError(w, "Domain already exists", http.StatusConflict)
+		default:
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, d, http.StatusCreated)
+}
+
+func (h *Handler) DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := h.shortenerService.RemoveDomain(r.Context(), vars["domain"]); err != nil {
+		switch err {
+		case service.ErrInvalidDomain:
+			h.respondError(w, "Invalid domain", http.StatusBadRequest)
+		case service.ErrDomainNotFound:
+			h.respondError(w, "Domain not found", http.StatusNotFound)
+		case service.ErrDomainInUse:
+			h.respondError(w, "Domain has active links", http.StatusConflict)
+		default:
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
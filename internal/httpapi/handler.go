@@ -8,20 +8,31 @@ import (
 
 	"github.com/MyNameIsWhaaat/shortener/internal/domain"
 	"github.com/MyNameIsWhaaat/shortener/internal/service"
+	"github.com/MyNameIsWhaaat/shortener/internal/store"
+	"github.com/MyNameIsWhaaat/shortener/internal/ui"
 )
 
 type Handler struct {
 	shortenerService service.ShortenerService
 	analyticsService service.AnalyticsService
+	idempotencyStore store.IdempotencyStore
+	idempotencyTTL   time.Duration
+	branding         ui.Branding
 }
 
 func NewHandler(
 	shortenerService service.ShortenerService,
 	analyticsService service.AnalyticsService,
+	idempotencyStore store.IdempotencyStore,
+	idempotencyTTL time.Duration,
+	branding ui.Branding,
 ) *Handler {
 	return &Handler{
 		shortenerService: shortenerService,
 		analyticsService: analyticsService,
+		idempotencyStore: idempotencyStore,
+		idempotencyTTL:   idempotencyTTL,
+		branding:         branding,
 	}
 }
 
@@ -48,6 +59,12 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ClickStats reports the click ingestion queue: how many clicks were queued,
+// written, dropped because the queue was full, or lost to failed writes.
+func (h *Handler) ClickStats(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, h.shortenerService.ClickIngestStats(), http.StatusOK)
+}
+
 func (h *Handler) GetPopularURLs(w http.ResponseWriter, r *http.Request) {
 	limit := 10
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
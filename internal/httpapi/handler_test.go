@@ -6,17 +6,28 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/MyNameIsWhaaat/shortener/internal/cache"
 	"github.com/MyNameIsWhaaat/shortener/internal/domain"
 	"github.com/MyNameIsWhaaat/shortener/internal/service"
+	"github.com/MyNameIsWhaaat/shortener/internal/ui"
+	"github.com/gorilla/mux"
 )
 
 // Setup for handler tests
 func setupTestHandler(t *testing.T) *Handler {
+	handler, _ := setupTestHandlerWithStore(t)
+	return handler
+}
+
+func setupTestHandlerWithStore(t *testing.T) (*Handler, *testURLStore) {
 	urlStore := &testURLStore{
-		urls: make(map[string]*domain.URL),
+		urls:    make(map[string]*domain.URL),
+		domains: make(map[string]bool),
 	}
 	analyticsStore := &testAnalyticsStore{
 		events: make(map[string][]domain.ClickEvent),
@@ -29,45 +40,171 @@ func setupTestHandler(t *testing.T) *Handler {
 		6,
 		analyticsStore,
 		cacheClient,
+		false,
+		false,
+		nil,
+		nil,
+		nil,
 	)
-	analyticsService := service.NewAnalyticsService(analyticsStore)
+	analyticsService := service.NewAnalyticsService(analyticsStore, nil, nil)
 
-	return NewHandler(shortenerService, analyticsService)
+	return NewHandler(shortenerService, analyticsService, nil, 0, ui.Branding{}), urlStore
 }
 
 type testURLStore struct {
-	urls map[string]*domain.URL
+	urls    map[string]*domain.URL
+	domains map[string]bool
+}
+
+// linkKey keys links on the default domain by their bare short code
+func linkKey(host, shortCode string) string {
+	if host == "" {
+		return shortCode
+	}
+	return host + "/" + shortCode
 }
 
 func (t *testURLStore) CreateURL(ctx context.Context, url *domain.URL) error {
-	t.urls[url.ShortCode] = url
+	t.urls[linkKey(url.Domain, url.ShortCode)] = url
+	return nil
+}
+
+func (t *testURLStore) CreateURLs(ctx context.Context, urls []*domain.URL) ([]error, error) {
+	errs := make([]error, len(urls))
+	for i, url := range urls {
+		if _, exists := t.urls[linkKey(url.Domain, url.ShortCode)]; exists {
+			errs[i] = domain.ErrShortCodeExists
+			continue
+		}
+		t.urls[linkKey(url.Domain, url.ShortCode)] = url
+	}
+	return errs, nil
+}
+
+func (t *testURLStore) UpdateURL(ctx context.Context, url *domain.URL) error {
+	existing, ok := t.urls[linkKey(url.Domain, url.ShortCode)]
+	if !ok {
+		return service.ErrURLNotFound
+	}
+	existing.OriginalURL = url.OriginalURL
+	return nil
+}
+
+func (t *testURLStore) SetDisabled(ctx context.Context, host, shortCode string, disabled bool) error {
+	url, ok := t.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return service.ErrURLNotFound
+	}
+	if disabled {
+		now := time.Now()
+		url.DisabledAt = &now
+	} else {
+		url.DisabledAt = nil
+	}
+	return nil
+}
+
+func (t *testURLStore) DeleteURL(ctx context.Context, host, shortCode string) error {
+	url, ok := t.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return service.ErrURLNotFound
+	}
+	now := time.Now()
+	url.DeletedAt = &now
 	return nil
 }
 
-func (t *testURLStore) GetURLByShortCode(ctx context.Context, shortCode string) (*domain.URL, error) {
-	if url, ok := t.urls[shortCode]; ok {
+func (t *testURLStore) GetURLByShortCode(ctx context.Context, host, shortCode string) (*domain.URL, error) {
+	if url, ok := t.urls[linkKey(host, shortCode)]; ok {
 		return url, nil
 	}
 	return nil, service.ErrURLNotFound
 }
 
-func (t *testURLStore) IncrementClicks(ctx context.Context, shortCode string) error {
-	if url, ok := t.urls[shortCode]; ok {
+func (t *testURLStore) FindByOriginalURL(ctx context.Context, host, originalURL string) (*domain.URL, error) {
+	fingerprint := domain.URLFingerprint(originalURL)
+	var found *domain.URL
+	for _, url := range t.urls {
+		if url.Domain != host || url.DeletedAt != nil || url.DisabledAt != nil || url.ExpiresAt != nil || url.MaxClicks != nil || url.PasswordHash != nil {
+			continue
+		}
+		if url.RedirectStatus() != domain.DefaultRedirectType || url.ForwardQuery || url.ForwardPath || url.UTM != nil || len(url.Targeting) > 0 || len(url.Variants) > 0 || url.Interstitial || !url.DeepLinks.IsEmpty() {
+			continue
+		}
+		if domain.URLFingerprint(url.OriginalURL) != fingerprint {
+			continue
+		}
+		if found == nil || url.CreatedAt.Before(found.CreatedAt) {
+			found = url
+		}
+	}
+	if found == nil {
+		return nil, service.ErrURLNotFound
+	}
+	return found, nil
+}
+
+func (t *testURLStore) FindByOriginalURLs(ctx context.Context, hosts, originalURLs []string) ([]*domain.URL, error) {
+	urls := make([]*domain.URL, len(originalURLs))
+	for i := range originalURLs {
+		if url, err := t.FindByOriginalURL(ctx, hosts[i], originalURLs[i]); err == nil {
+			urls[i] = url
+		}
+	}
+	return urls, nil
+}
+
+func (t *testURLStore) IncrementClicks(ctx context.Context, host, shortCode string) error {
+	if url, ok := t.urls[linkKey(host, shortCode)]; ok {
 		url.Clicks++
 		return nil
 	}
 	return service.ErrURLNotFound
 }
 
-func (t *testURLStore) CheckShortCodeExists(ctx context.Context, shortCode string) (bool, error) {
-	_, exists := t.urls[shortCode]
+func (t *testURLStore) ReserveClick(ctx context.Context, host, shortCode string) (bool, error) {
+	url, ok := t.urls[linkKey(host, shortCode)]
+	if !ok || (url.MaxClicks != nil && url.Clicks >= *url.MaxClicks) {
+		return false, nil
+	}
+	url.Clicks++
+	return true, nil
+}
+
+func (t *testURLStore) CheckShortCodeExists(ctx context.Context, host, shortCode string) (bool, error) {
+	_, exists := t.urls[linkKey(host, shortCode)]
 	return exists, nil
 }
 
-func (t *testURLStore) GetAllURLs(ctx context.Context, limit int) ([]*domain.URL, error) {
+func (t *testURLStore) GetAllURLs(ctx context.Context, q domain.ListURLsQuery) ([]*domain.URL, error) {
 	urls := make([]*domain.URL, 0)
 	for _, url := range t.urls {
+		if !hasTags(url, q.Tags) {
+			continue
+		}
 		urls = append(urls, url)
+		if q.Limit > 0 && len(urls) >= q.Limit {
+			break
+		}
+	}
+	return urls, nil
+}
+
+func (t *testURLStore) SearchURLs(ctx context.Context, query string, limit int) ([]*domain.URL, error) {
+	query = strings.ToLower(query)
+	urls := make([]*domain.URL, 0)
+	for _, url := range t.urls {
+		alias := ""
+		if url.CustomAlias != nil {
+			alias = strings.ToLower(*url.CustomAlias)
+		}
+		if url.DeletedAt != nil {
+			continue
+		}
+		if strings.HasPrefix(strings.ToLower(url.ShortCode), query) || strings.HasPrefix(alias, query) ||
+			strings.Contains(strings.ToLower(url.OriginalURL), query) {
+			urls = append(urls, url)
+		}
 		if limit > 0 && len(urls) >= limit {
 			break
 		}
@@ -75,6 +212,130 @@ func (t *testURLStore) GetAllURLs(ctx context.Context, limit int) ([]*domain.URL
 	return urls, nil
 }
 
+func (t *testURLStore) SetTargetingRules(ctx context.Context, host, shortCode string, rules []domain.TargetingRule) error {
+	url, ok := t.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return service.ErrURLNotFound
+	}
+	url.Targeting = rules
+	return nil
+}
+
+func (t *testURLStore) SetVariants(ctx context.Context, host, shortCode string, variants []domain.Variant, sticky bool) error {
+	url, ok := t.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return service.ErrURLNotFound
+	}
+	url.Variants = variants
+	url.StickyVariants = sticky
+	return nil
+}
+
+func (t *testURLStore) SetInterstitial(ctx context.Context, host, shortCode string, enabled bool) error {
+	url, ok := t.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return service.ErrURLNotFound
+	}
+	url.Interstitial = enabled
+	return nil
+}
+
+func (t *testURLStore) SetDeepLinks(ctx context.Context, host, shortCode string, links domain.DeepLinks) error {
+	url, ok := t.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return service.ErrURLNotFound
+	}
+	url.DeepLinks = links
+	return nil
+}
+
+func (t *testURLStore) AddTags(ctx context.Context, host, shortCode string, tags []string) error {
+	url, ok := t.urls[linkKey(host, shortCode)]
+	if !ok {
+		return service.ErrURLNotFound
+	}
+	for _, tag := range tags {
+		if !hasTags(url, []string{tag}) {
+			url.Tags = append(url.Tags, tag)
+		}
+	}
+	return nil
+}
+
+func (t *testURLStore) RemoveTag(ctx context.Context, host, shortCode, tag string) error {
+	url, ok := t.urls[linkKey(host, shortCode)]
+	if !ok {
+		return nil
+	}
+	tags := make([]string, 0, len(url.Tags))
+	for _, existing := range url.Tags {
+		if existing != tag {
+			tags = append(tags, existing)
+		}
+	}
+	url.Tags = tags
+	return nil
+}
+
+func (t *testURLStore) GetTagCounts(ctx context.Context) ([]domain.TagCount, error) {
+	counts := make(map[string]int64)
+	for _, url := range t.urls {
+		for _, tag := range url.Tags {
+			counts[tag]++
+		}
+	}
+	result := make([]domain.TagCount, 0, len(counts))
+	for tag, count := range counts {
+		result = append(result, domain.TagCount{Tag: tag, Count: count})
+	}
+	return result, nil
+}
+
+func hasTags(url *domain.URL, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, existing := range url.Tags {
+			if existing == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *testURLStore) ListDomains(ctx context.Context) ([]domain.ShortDomain, error) {
+	domains := make([]domain.ShortDomain, 0, len(t.domains))
+	for host := range t.domains {
+		domains = append(domains, domain.ShortDomain{Host: host})
+	}
+	return domains, nil
+}
+
+func (t *testURLStore) AddDomain(ctx context.Context, host string) (*domain.ShortDomain, error) {
+	if t.domains[host] {
+		return nil, service.ErrDomainExists
+	}
+	t.domains[host] = true
+	return &domain.ShortDomain{Host: host, CreatedAt: time.Now()}, nil
+}
+
+func (t *testURLStore) DeleteDomain(ctx context.Context, host string) error {
+	if !t.domains[host] {
+		return service.ErrDomainNotFound
+	}
+	for _, url := range t.urls {
+		if url.Domain == host && url.DeletedAt == nil {
+			return service.ErrDomainInUse
+		}
+	}
+	delete(t.domains, host)
+	return nil
+}
+
 type testAnalyticsStore struct {
 	events map[string][]domain.ClickEvent
 }
@@ -84,19 +345,42 @@ func (t *testAnalyticsStore) SaveClickEvent(ctx context.Context, event *domain.C
 	return nil
 }
 
-func (t *testAnalyticsStore) GetDailyStats(ctx context.Context, shortCode string, days int) (map[string]int64, error) {
+func (t *testAnalyticsStore) SaveClickEvents(ctx context.Context, events []*domain.ClickEvent, counts []domain.ClickCount) error {
+	for _, event := range events {
+		t.events[event.ShortCode] = append(t.events[event.ShortCode], *event)
+	}
+	return nil
+}
+
+func (t *testAnalyticsStore) GetDailyStats(ctx context.Context, host, shortCode string, days int) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+func (t *testAnalyticsStore) GetMonthlyStats(ctx context.Context, host, shortCode string, months int) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+func (t *testAnalyticsStore) GetDeviceStats(ctx context.Context, host, shortCode string) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+func (t *testAnalyticsStore) GetBrowserStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error) {
 	return map[string]int64{}, nil
 }
 
-func (t *testAnalyticsStore) GetMonthlyStats(ctx context.Context, shortCode string, months int) (map[string]int64, error) {
+func (t *testAnalyticsStore) GetOSStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error) {
 	return map[string]int64{}, nil
 }
 
-func (t *testAnalyticsStore) GetDeviceStats(ctx context.Context, shortCode string) (map[string]int64, error) {
+func (t *testAnalyticsStore) GetVariantStats(ctx context.Context, host, shortCode string) (map[string]int64, error) {
 	return map[string]int64{}, nil
 }
 
-func (t *testAnalyticsStore) GetRecentClicks(ctx context.Context, shortCode string, limit int) ([]domain.ClickEvent, error) {
+func (t *testAnalyticsStore) GetBotStats(ctx context.Context, host, shortCode string) (*domain.BotStats, error) {
+	return &domain.BotStats{ShortCode: shortCode}, nil
+}
+
+func (t *testAnalyticsStore) GetRecentClicks(ctx context.Context, host, shortCode string, limit int) ([]domain.ClickEvent, error) {
 	if events, ok := t.events[shortCode]; ok {
 		if len(events) > limit {
 			return events[:limit], nil
@@ -106,7 +390,7 @@ func (t *testAnalyticsStore) GetRecentClicks(ctx context.Context, shortCode stri
 	return []domain.ClickEvent{}, nil
 }
 
-func (t *testAnalyticsStore) GetAnalytics(ctx context.Context, shortCode string) (*domain.AnalyticsResponse, error) {
+func (t *testAnalyticsStore) GetAnalytics(ctx context.Context, host, shortCode string) (*domain.AnalyticsResponse, error) {
 	return &domain.AnalyticsResponse{
 		ShortCode:   shortCode,
 		TotalClicks: 0,
@@ -195,6 +479,39 @@ func TestShortenHandler(t *testing.T) {
 	}
 }
 
+func TestShortenBatchHandler(t *testing.T) {
+	handler := setupTestHandler(t)
+
+	body := `[{"url": "https://example.com/1"}, {"url": ""}, {"url": "https://example.com/2", "custom_alias": "promo"}]`
+	req := httptest.NewRequest("POST", "/api/shorten/batch", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+
+	handler.ShortenBatch(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var response []map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if len(response) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(response))
+	}
+
+	if response[0]["short_code"] == "" || response[0]["error"] != "" {
+		t.Errorf("expected first item to succeed, got %v", response[0])
+	}
+	if response[1]["error"] == "" {
+		t.Errorf("expected second item to fail, got %v", response[1])
+	}
+	if response[2]["short_code"] != "promo" {
+		t.Errorf("expected third item to use alias, got %v", response[2])
+	}
+}
+
 func TestGetAllURLsHandler(t *testing.T) {
 	handler := setupTestHandler(t)
 
@@ -226,6 +543,84 @@ func TestGetAllURLsHandler(t *testing.T) {
 	}
 }
 
+func TestGetAllURLsPagedHandler(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.urls["abc123"] = &domain.URL{ID: 1, ShortCode: "abc123", OriginalURL: "https://example.com"}
+	urlStore.urls["def456"] = &domain.URL{ID: 2, ShortCode: "def456", OriginalURL: "https://example.org"}
+
+	req := httptest.NewRequest("GET", "/api/urls?limit=1&cursor=", nil)
+	w := httptest.NewRecorder()
+	handler.GetAllURLs(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var page domain.URLPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(page.URLs) != 1 || page.NextCursor == "" {
+		t.Errorf("expected one link and a next cursor, got %d links and %q", len(page.URLs), page.NextCursor)
+	}
+
+	req = httptest.NewRequest("GET", "/api/urls?cursor=garbage", nil)
+	w = httptest.NewRecorder()
+	handler.GetAllURLs(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid cursor, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/urls?order=random", nil)
+	w = httptest.NewRecorder()
+	handler.GetAllURLs(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid order, got %d", w.Code)
+	}
+}
+
+func TestSearchURLsHandler(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.urls["abc123"] = &domain.URL{ShortCode: "abc123", OriginalURL: "https://example.com/pricing"}
+	urlStore.urls["def456"] = &domain.URL{ShortCode: "def456", OriginalURL: "https://example.org/docs"}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{"by destination", "pricing", http.StatusOK, 1},
+		{"by code prefix", "def", http.StatusOK, 1},
+		{"no match", "nothing", http.StatusOK, 0},
+		{"empty query", "", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/urls/search?q="+url.QueryEscape(tt.query), nil)
+			w := httptest.NewRecorder()
+
+			handler.SearchURLs(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var urls []*domain.URL
+			if err := json.NewDecoder(w.Body).Decode(&urls); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(urls) != tt.expectedCount {
+				t.Errorf("expected %d results, got %d", tt.expectedCount, len(urls))
+			}
+		})
+	}
+}
+
 func TestRespondError(t *testing.T) {
 	handler := setupTestHandler(t)
 	w := httptest.NewRecorder()
@@ -245,3 +640,644 @@ func TestRespondError(t *testing.T) {
 		t.Errorf("expected error message 'test error', got %s", response["error"])
 	}
 }
+
+func TestRedirectHandler(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+
+	past := time.Now().Add(-time.Hour)
+	urlStore.urls["active"] = &domain.URL{ShortCode: "active", OriginalURL: "https://example.com"}
+	urlStore.urls["expired"] = &domain.URL{ShortCode: "expired", OriginalURL: "https://example.com", ExpiresAt: &past}
+	urlStore.urls["disabled"] = &domain.URL{ShortCode: "disabled", OriginalURL: "https://example.com", DisabledAt: &past}
+	urlStore.urls["deleted"] = &domain.URL{ShortCode: "deleted", OriginalURL: "https://example.com", DeletedAt: &past}
+	urlStore.urls["permanent"] = &domain.URL{ShortCode: "permanent", OriginalURL: "https://example.com", RedirectType: http.StatusMovedPermanently}
+	urlStore.urls["temporary"] = &domain.URL{ShortCode: "temporary", OriginalURL: "https://example.com", RedirectType: http.StatusTemporaryRedirect}
+
+	tests := []struct {
+		name           string
+		shortCode      string
+		expectedStatus int
+	}{
+		{"active link", "active", http.StatusFound},
+		{"permanent link", "permanent", http.StatusMovedPermanently},
+		{"temporary link", "temporary", http.StatusTemporaryRedirect},
+		{"expired link", "expired", http.StatusGone},
+		{"disabled link", "disabled", http.StatusGone},
+		{"deleted link", "deleted", http.StatusGone},
+		{"missing link", "missing", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/s/"+tt.shortCode, nil)
+			req = mux.SetURLVars(req, map[string]string{"short_code": tt.shortCode})
+			w := httptest.NewRecorder()
+
+			handler.Redirect(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerShortDomain(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.domains["go.brand.com"] = true
+	urlStore.urls["docs"] = &domain.URL{ShortCode: "docs", OriginalURL: "https://example.com/plain"}
+	urlStore.urls["go.brand.com/docs"] = &domain.URL{Domain: "go.brand.com", ShortCode: "docs", OriginalURL: "https://example.com/brand"}
+
+	tests := []struct {
+		host     string
+		location string
+	}{
+		{"go.brand.com", "https://example.com/brand"},
+		{"localhost:8080", "https://example.com/plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/s/docs", nil)
+			req.Host = tt.host
+			req = mux.SetURLVars(req, map[string]string{"short_code": "docs"})
+			w := httptest.NewRecorder()
+
+			handler.Redirect(w, req)
+
+			if w.Code != http.StatusFound {
+				t.Fatalf("expected status 302, got %d", w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("expected redirect to %s, got %s", tt.location, location)
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerForwardPath(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.urls["docs"] = &domain.URL{ShortCode: "docs", OriginalURL: "https://docs.example.com?ref=short", ForwardPath: true}
+	urlStore.urls["plain"] = &domain.URL{ShortCode: "plain", OriginalURL: "https://example.com"}
+
+	tests := []struct {
+		name      string
+		shortCode string
+		path      string
+		status    int
+		location  string
+	}{
+		{"forwarded", "docs", "getting-started", http.StatusFound, "https://docs.example.com/getting-started?ref=short"},
+		{"traversal", "docs", "a/../../etc", http.StatusNotFound, ""},
+		{"forwarding off", "plain", "getting-started", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/s/"+tt.shortCode+"/"+tt.path, nil)
+			req = mux.SetURLVars(req, map[string]string{"short_code": tt.shortCode, "path": tt.path})
+			w := httptest.NewRecorder()
+
+			handler.Redirect(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("expected redirect to %q, got %q", tt.location, location)
+			}
+		})
+	}
+}
+
+func TestRedirectErrorPages(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	handler.branding = ui.Branding{Name: "Acme Links", SupportURL: "https://acme.example/support"}
+	past := time.Now().Add(-time.Hour)
+	urlStore.urls["expired"] = &domain.URL{ShortCode: "expired", OriginalURL: "https://example.com", ExpiresAt: &past}
+
+	tests := []struct {
+		name        string
+		shortCode   string
+		accept      string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{"browser missing link", "missing", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8", http.StatusNotFound, "text/html", "https://acme.example/support"},
+		{"browser expired link", "expired", "text/html", http.StatusGone, "text/html", "Срок действия ссылки истёк"},
+		{"api client", "missing", "application/json", http.StatusNotFound, "application/json", `"error":"URL not found"`},
+		{"curl", "expired", "*/*", http.StatusGone, "application/json", `"error":"URL expired"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/s/"+tt.shortCode, nil)
+			req.Header.Set("Accept", tt.accept)
+			req = mux.SetURLVars(req, map[string]string{"short_code": tt.shortCode})
+			w := httptest.NewRecorder()
+
+			handler.Redirect(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+			if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, tt.contentType) {
+				t.Errorf("expected content type %s, got %s", tt.contentType, contentType)
+			}
+			if !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("expected body to contain %q, got %s", tt.contains, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerQueryForwarding(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.urls["abc123"] = &domain.URL{
+		ShortCode:    "abc123",
+		OriginalURL:  "https://example.com/page",
+		ForwardQuery: true,
+		UTM:          &domain.UTMParams{Source: "shortener", Campaign: "spring"},
+	}
+
+	req := httptest.NewRequest("GET", "/s/abc123?ref=newsletter&utm_source=mail", nil)
+	req = mux.SetURLVars(req, map[string]string{"short_code": "abc123"})
+	w := httptest.NewRecorder()
+
+	handler.Redirect(w, req)
+
+	expected := "https://example.com/page?ref=newsletter&utm_campaign=spring&utm_source=mail"
+	if location := w.Header().Get("Location"); location != expected {
+		t.Errorf("expected Location %q, got %q", expected, location)
+	}
+}
+
+func TestRedirectHandlerTargeting(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.urls["abc123"] = &domain.URL{
+		ShortCode:   "abc123",
+		OriginalURL: "https://example.com",
+		Targeting: []domain.TargetingRule{
+			{Device: domain.DeviceMobile, URL: "https://m.example.com"},
+			{Language: "de", URL: "https://example.de"},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		userAgent      string
+		acceptLanguage string
+		expected       string
+	}{
+		{"mobile", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148", "de-DE", "https://m.example.com"},
+		{"german desktop", "Mozilla/5.0 (Windows NT 10.0; Win64; x64)", "de-DE,en;q=0.5", "https://example.de"},
+		{"fallback", "Mozilla/5.0 (Windows NT 10.0; Win64; x64)", "en-US", "https://example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/s/abc123", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			req = mux.SetURLVars(req, map[string]string{"short_code": "abc123"})
+			w := httptest.NewRecorder()
+
+			handler.Redirect(w, req)
+
+			if location := w.Header().Get("Location"); location != tt.expected {
+				t.Errorf("expected Location %q, got %q", tt.expected, location)
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerStickyVariant(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.urls["abc123"] = &domain.URL{
+		ShortCode:   "abc123",
+		OriginalURL: "https://example.com",
+		Variants: []domain.Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+			{Name: "b", URL: "https://example.com/b", Weight: 1},
+		},
+		StickyVariants: true,
+	}
+
+	req := httptest.NewRequest("GET", "/s/abc123", nil)
+	req = mux.SetURLVars(req, map[string]string{"short_code": "abc123"})
+	w := httptest.NewRecorder()
+
+	handler.Redirect(w, req)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected variant cookie, got %v", cookies)
+	}
+	location := w.Header().Get("Location")
+	if location != "https://example.com/"+cookies[0].Value {
+		t.Errorf("expected Location to match variant %q, got %q", cookies[0].Value, location)
+	}
+
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest("GET", "/s/abc123", nil)
+		req.AddCookie(cookies[0])
+		req = mux.SetURLVars(req, map[string]string{"short_code": "abc123"})
+		w := httptest.NewRecorder()
+
+		handler.Redirect(w, req)
+
+		if got := w.Header().Get("Location"); got != location {
+			t.Fatalf("expected sticky Location %q, got %q", location, got)
+		}
+	}
+}
+
+func TestPreviewHandler(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	password := "hash"
+	urlStore.urls["abc123"] = &domain.URL{ShortCode: "abc123", OriginalURL: "https://example.com/landing", Clicks: 7}
+	urlStore.urls["secret"] = &domain.URL{ShortCode: "secret", OriginalURL: "https://example.com/secret", PasswordHash: &password, Protected: true}
+
+	tests := []struct {
+		name        string
+		path        string
+		shortCode   string
+		preview     func(w http.ResponseWriter, r *http.Request)
+		contains    string
+		notContains string
+	}{
+		{"plus suffix", "/s/abc123+", "abc123", handler.Preview, "https://example.com/landing", ""},
+		{"query parameter", "/s/abc123?preview=1&ref=mail", "abc123", handler.Redirect, `href="/s/abc123?ref=mail"`, ""},
+		{"protected", "/s/secret+", "secret", handler.Preview, "защищена паролем", "https://example.com/secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req = mux.SetURLVars(req, map[string]string{"short_code": tt.shortCode})
+			w := httptest.NewRecorder()
+
+			tt.preview(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != "" {
+				t.Errorf("expected no redirect, got Location %q", location)
+			}
+			body := w.Body.String()
+			if !strings.Contains(body, tt.contains) {
+				t.Errorf("expected page to contain %q", tt.contains)
+			}
+			if tt.notContains != "" && strings.Contains(body, tt.notContains) {
+				t.Errorf("expected page not to contain %q", tt.notContains)
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerInterstitial(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.urls["ext"] = &domain.URL{ShortCode: "ext", OriginalURL: "https://example.com", Interstitial: true}
+	urlStore.urls["own"] = &domain.URL{ShortCode: "own", OriginalURL: "http://localhost:8080/ui/", Interstitial: true}
+
+	req := httptest.NewRequest("GET", "http://localhost:8080/s/ext", nil)
+	req = mux.SetURLVars(req, map[string]string{"short_code": "ext"})
+	w := httptest.NewRecorder()
+
+	handler.Redirect(w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `href="https://example.com"`) {
+		t.Errorf("expected interstitial page for external destination, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "http://localhost:8080/s/own", nil)
+	req = mux.SetURLVars(req, map[string]string{"short_code": "own"})
+	w = httptest.NewRecorder()
+
+	handler.Redirect(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Errorf("expected direct redirect for internal destination, got %d", w.Code)
+	}
+}
+
+func TestRedirectHandlerDeepLinks(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.urls["app"] = &domain.URL{
+		ShortCode:   "app",
+		OriginalURL: "https://example.com/item/42",
+		DeepLinks: domain.DeepLinks{
+			IOSURL:      "myapp://item/42",
+			AndroidURL:  "https://app.example.com/item/42",
+			FallbackURL: "https://apps.apple.com/app/id123",
+		},
+	}
+
+	tests := []struct {
+		name      string
+		userAgent string
+		status    int
+		location  string
+		contains  string
+	}{
+		{"ios custom scheme", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148", http.StatusOK, "", `href="myapp://item/42"`},
+		{"android app link", "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile", http.StatusFound, "https://app.example.com/item/42", ""},
+		{"desktop", "Mozilla/5.0 (Windows NT 10.0; Win64; x64)", http.StatusFound, "https://example.com/item/42", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/s/app", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			req = mux.SetURLVars(req, map[string]string{"short_code": "app"})
+			w := httptest.NewRecorder()
+
+			handler.Redirect(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("expected Location %q, got %q", tt.location, location)
+			}
+			if tt.contains != "" {
+				body := w.Body.String()
+				if !strings.Contains(body, tt.contains) || !strings.Contains(body, "https://apps.apple.com/app/id123") {
+					t.Errorf("expected bridge page with app and fallback URLs, got %s", body)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateURLHandler(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.urls["abc123"] = &domain.URL{ShortCode: "abc123", OriginalURL: "https://exmaple.com"}
+
+	tests := []struct {
+		name           string
+		shortCode      string
+		body           string
+		expectedStatus int
+	}{
+		{"valid update", "abc123", `{"url": "https://example.com"}`, http.StatusOK},
+		{"invalid url", "abc123", `{"url": "not a url"}`, http.StatusBadRequest},
+		{"invalid JSON", "abc123", `invalid`, http.StatusBadRequest},
+		{"missing link", "missing", `{"url": "https://example.com"}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/api/urls/"+tt.shortCode, bytes.NewReader([]byte(tt.body)))
+			req = mux.SetURLVars(req, map[string]string{"short_code": tt.shortCode})
+			w := httptest.NewRecorder()
+
+			handler.UpdateURL(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestDeleteURLHandler(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.urls["abc123"] = &domain.URL{ShortCode: "abc123", OriginalURL: "https://example.com"}
+
+	tests := []struct {
+		name           string
+		shortCode      string
+		expectedStatus int
+	}{
+		{"existing link", "abc123", http.StatusNoContent},
+		{"already deleted", "abc123", http.StatusNotFound},
+		{"missing link", "missing", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("DELETE", "/api/urls/"+tt.shortCode, nil)
+			req = mux.SetURLVars(req, map[string]string{"short_code": tt.shortCode})
+			w := httptest.NewRecorder()
+
+			handler.DeleteURL(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestTagHandlers(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	urlStore.urls["abc123"] = &domain.URL{ShortCode: "abc123", OriginalURL: "https://example.com"}
+	urlStore.urls["def456"] = &domain.URL{ShortCode: "def456", OriginalURL: "https://example.org", Tags: []string{"docs"}}
+
+	req := httptest.NewRequest("POST", "/api/urls/abc123/tags", bytes.NewReader([]byte(`{"tags": ["Promo", "docs"]}`)))
+	req = mux.SetURLVars(req, map[string]string{"short_code": "abc123"})
+	w := httptest.NewRecorder()
+	handler.AddTags(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/urls?tag=promo&tag=docs", nil)
+	w = httptest.NewRecorder()
+	handler.GetAllURLs(w, req)
+
+	var urls []*domain.URL
+	if err := json.NewDecoder(w.Body).Decode(&urls); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(urls) != 1 || urls[0].ShortCode != "abc123" {
+		t.Errorf("expected only abc123, got %v", urls)
+	}
+
+	req = httptest.NewRequest("GET", "/api/tags", nil)
+	w = httptest.NewRecorder()
+	handler.GetTags(w, req)
+
+	var counts []domain.TagCount
+	if err := json.NewDecoder(w.Body).Decode(&counts); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	total := make(map[string]int64)
+	for _, c := range counts {
+		total[c.Tag] = c.Count
+	}
+	if total["docs"] != 2 || total["promo"] != 1 {
+		t.Errorf("unexpected tag counts: %v", counts)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/urls/abc123/tags/docs", nil)
+	req = mux.SetURLVars(req, map[string]string{"short_code": "abc123", "tag": "docs"})
+	w = httptest.NewRecorder()
+	handler.RemoveTag(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/api/urls/missing/tags", bytes.NewReader([]byte(`{"tags": ["promo"]}`)))
+	req = mux.SetURLVars(req, map[string]string{"short_code": "missing"})
+	w = httptest.NewRecorder()
+	handler.AddTags(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestProtectedRedirectHandler(t *testing.T) {
+	handler := setupTestHandler(t)
+
+	createReq := httptest.NewRequest("POST", "/api/shorten",
+		bytes.NewReader([]byte(`{"url": "https://example.com", "custom_alias": "secret", "password": "hunter2"}`)),
+	)
+	createW := httptest.NewRecorder()
+	handler.Shorten(createW, createReq)
+	if createW.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", createW.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/s/secret", nil)
+	req = mux.SetURLVars(req, map[string]string{"short_code": "secret"})
+	w := httptest.NewRecorder()
+	handler.Redirect(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected password form with status 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("expected HTML form, got %s", w.Header().Get("Content-Type"))
+	}
+
+	tests := []struct {
+		name           string
+		password       string
+		expectedStatus int
+	}{
+		{"wrong password", "wrong", http.StatusUnauthorized},
+		{"correct password", "hunter2", http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"password": {tt.password}}
+			req := httptest.NewRequest("POST", "/s/secret", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req = mux.SetURLVars(req, map[string]string{"short_code": "secret"})
+			w := httptest.NewRecorder()
+
+			handler.Unlock(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+type testIdempotencyStore struct {
+	records map[string]*domain.IdempotencyRecord
+}
+
+func (t *testIdempotencyStore) ReserveIdempotencyKey(ctx context.Context, key, requestHash string, ttl time.Duration) (bool, error) {
+	if _, exists := t.records[key]; exists {
+		return false, nil
+	}
+	t.records[key] = &domain.IdempotencyRecord{RequestHash: requestHash}
+	return true, nil
+}
+
+func (t *testIdempotencyStore) GetIdempotencyRecord(ctx context.Context, key string) (*domain.IdempotencyRecord, error) {
+	return t.records[key], nil
+}
+
+func (t *testIdempotencyStore) SaveIdempotencyRecord(ctx context.Context, key string, record *domain.IdempotencyRecord, ttl time.Duration) error {
+	t.records[key] = record
+	return nil
+}
+
+func (t *testIdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	delete(t.records, key)
+	return nil
+}
+
+func TestIdempotentShortenHandler(t *testing.T) {
+	handler, urlStore := setupTestHandlerWithStore(t)
+	handler.idempotencyStore = &testIdempotencyStore{records: make(map[string]*domain.IdempotencyRecord)}
+	handler.idempotencyTTL = time.Hour
+	shorten := handler.Idempotent(handler.Shorten)
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/shorten", bytes.NewReader([]byte(body)))
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		shorten(w, req)
+		return w
+	}
+
+	first := send("job-1", `{"url": "https://example.com"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", first.Code)
+	}
+
+	retry := send("job-1", `{"url": "https://example.com"}`)
+	if retry.Code != http.StatusCreated {
+		t.Errorf("expected replayed status 201, got %d", retry.Code)
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("expected replayed body %s, got %s", first.Body.String(), retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected replayed response to be marked")
+	}
+
+	if len(urlStore.urls) != 1 {
+		t.Errorf("expected 1 URL to be created, got %d", len(urlStore.urls))
+	}
+
+	mismatch := send("job-1", `{"url": "https://example.org"}`)
+	if mismatch.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", mismatch.Code)
+	}
+
+	other := send("job-2", `{"url": "https://example.com"}`)
+	if other.Code != http.StatusCreated || len(urlStore.urls) != 2 {
+		t.Errorf("expected a new key to create another URL, got status %d", other.Code)
+	}
+	// A body over the limit is refused rather than cut short
+	padding := strings.Repeat(" ", maxIdempotentBody)
+	large := send("job-3", `{"url": "https://example.net"}`+padding)
+	if large.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413, got %d", large.Code)
+	}
+	if len(urlStore.urls) != 2 {
+		t.Errorf("expected no URL for the oversized request, got %d", len(urlStore.urls))
+	}
+}
+
+func TestIdempotentReleasesKeyOnPanic(t *testing.T) {
+	handler, _ := setupTestHandlerWithStore(t)
+	idempotencyStore := &testIdempotencyStore{records: make(map[string]*domain.IdempotencyRecord)}
+	handler.idempotencyStore = idempotencyStore
+	handler.idempotencyTTL = time.Hour
+
+	panicking := RecoverMiddleware(handler.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	req := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url": "https://example.com"}`))
+	req.Header.Set("Idempotency-Key", "job-1")
+	w := httptest.NewRecorder()
+	panicking.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", w.Code)
+	}
+	if _, reserved := idempotencyStore.records["job-1"]; reserved {
+		t.Error("expected the key to be released after a panic")
+	}
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/logger"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+	maxIdempotentBody    = 10 << 20
+)
+
+// Idempotent wraps a creation handler so that retries carrying the same
+// Idempotency-Key header get the first response replayed instead of
+// creating another link.
+func (h *Handler) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || h.idempotencyStore == nil {
+			next(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLen {
+			h.respondError(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		// One byte past the limit tells an over-long body from one that fits,
+		// which must not be hashed and passed on truncated
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			h.respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			h.respondError(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := requestHash(r, body)
+
+		reserved, err := h.idempotencyStore.ReserveIdempotencyKey(r.Context(), key, hash, h.idempotencyTTL)
+		if err != nil {
+			logger.Error("Failed to reserve idempotency key", "error", err)
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !reserved {
+			h.replay(w, r, key, hash)
+			return
+		}
+
+		// The client may already be gone after a timeout, which is exactly
+		// when the stored response matters
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+		defer cancel()
+
+		// A panicking handler must not leave the key reserved, or every retry
+		// would get 409 until the TTL runs out. The panic goes on to
+		// RecoverMiddleware.
+		defer func() {
+			if p := recover(); p != nil {
+				h.releaseIdempotencyKey(ctx, key)
+				panic(p)
+			}
+		}()
+
+		recorder := &bodyRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next(recorder, r)
+
+		if recorder.statusCode >= http.StatusInternalServerError {
+			h.releaseIdempotencyKey(ctx, key)
+			return
+		}
+
+		record := &domain.IdempotencyRecord{
+			RequestHash: hash,
+			StatusCode:  recorder.statusCode,
+			Body:        recorder.body.Bytes(),
+		}
+		if err := h.idempotencyStore.SaveIdempotencyRecord(ctx, key, record, h.idempotencyTTL); err != nil {
+			logger.Error("Failed to save idempotency record", "error", err)
+		}
+	}
+}
+
+// releaseIdempotencyKey frees a key whose request produced no response worth
+// replaying, so that it can be retried
+func (h *Handler) releaseIdempotencyKey(ctx context.Context, key string) {
+	if err := h.idempotencyStore.ReleaseIdempotencyKey(ctx, key); err != nil {
+		logger.Error("Failed to release idempotency key", "error", err)
+	}
+}
+
+func (h *Handler) replay(w http.ResponseWriter, r *http.Request, key, hash string) {
+	record, err := h.idempotencyStore.GetIdempotencyRecord(r.Context(), key)
+	if err != nil {
+		logger.Error("Failed to get idempotency record", "error", err)
+		h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case record == nil:
+		// Expired or released between reserve and lookup
+		h.respondError(w, "Request with this Idempotency-Key is being processed, retry later", http.StatusConflict)
+	case record.RequestHash != hash:
+		h.respondError(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+	case record.StatusCode == 0:
+		h.respondError(w, "Request with this Idempotency-Key is being processed, retry later", http.StatusConflict)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.StatusCode)
+		if _, err := w.Write(record.Body); err != nil {
+			logger.Error("Failed to write replayed response", "error", err)
+		}
+	}
+}
+
+// requestHash identifies a request by method, path and body
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bodyRecorder passes the response through while keeping a copy of it
+type bodyRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *bodyRecorder) WriteHeader(code int) {
+	w.statusCode = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
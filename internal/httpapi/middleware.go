@@ -16,10 +16,11 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(wrapper, r)
 
+		// Client addresses are left out, they are anonymized before clicks
+		// are stored and must not be kept in the logs either
 		logger.Info("Request",
 			"method", r.Method,
 			"uri", r.RequestURI,
-			"remote_addr", r.RemoteAddr,
 			"status_code", wrapper.statusCode,
 			"duration_ms", time.Since(start).Milliseconds(),
 		)
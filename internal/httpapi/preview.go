@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/logger"
+	"github.com/MyNameIsWhaaat/shortener/internal/service"
+	"github.com/MyNameIsWhaaat/shortener/internal/ui"
+	"github.com/gorilla/mux"
+)
+
+// previewParam is the query parameter that turns a redirect into a preview
+const previewParam = "preview"
+
+type interstitialRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Preview shows where a short link leads without following it. No click is
+// tracked for the preview itself.
+func (h *Handler) Preview(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	url, err := h.shortenerService.GetOriginalURL(r.Context(), r.Host, shortCode)
+	if err == nil {
+		url, err = h.shortenerService.ForwardPath(url, vars[pathVar])
+	}
+	if err != nil {
+		h.redirectError(w, r, shortCode, err)
+		return
+	}
+
+	query := r.URL.Query()
+	query.Del(previewParam)
+
+	continueURL := "/s/" + shortCode
+	if path := vars[pathVar]; path != "" {
+		continueURL = (&neturl.URL{Path: continueURL + "/" + path}).EscapedPath()
+	}
+	if len(query) > 0 {
+		continueURL += "?" + query.Encode()
+	}
+
+	page := ui.Preview{
+		ShortCode:   shortCode,
+		CreatedAt:   url.CreatedAt,
+		Clicks:      url.Clicks,
+		Protected:   url.Protected,
+		ContinueURL: continueURL,
+	}
+	if !url.Protected {
+		page.Destination = url.OriginalURL
+	}
+
+	if err := ui.RenderPreview(w, page, http.StatusOK); err != nil {
+		logger.Error("Failed to render preview", "short_code", shortCode, "error", err)
+	}
+}
+
+// SetInterstitial switches the interstitial page of a link on or off
+func (h *Handler) SetInterstitial(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	var req interstitialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	url, err := h.shortenerService.SetInterstitial(r.Context(), linkDomain(r), shortCode, req.Enabled)
+	if err != nil {
+		switch {
+		case service.IsNotFound(err):
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		case err == service.ErrInvalidShortCode:
+			h.respondError(w, "Short code is required", http.StatusBadRequest)
+		default:
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, url, http.StatusOK)
+}
+
+// wantsPreview reports whether the redirect request asked for the preview
+// page with ?preview=1
+func wantsPreview(r *http.Request) bool {
+	switch r.URL.Query().Get(previewParam) {
+	case "1", "true":
+		return true
+	}
+	return false
+}
+
+// showsInterstitial reports whether the visitor has to confirm leaving for
+// destination. Only destinations on another host than the one serving the
+// short link count as external.
+func showsInterstitial(r *http.Request, url *domain.URL, destination string) bool {
+	if !url.Interstitial {
+		return false
+	}
+
+	parsed, err := neturl.Parse(destination)
+	if err != nil {
+		return true
+	}
+
+	return !strings.EqualFold(parsed.Host, r.Host)
+}
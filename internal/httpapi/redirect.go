@@ -4,44 +4,219 @@ import (
 	"context"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
 	"github.com/MyNameIsWhaaat/shortener/internal/logger"
+	"github.com/MyNameIsWhaaat/shortener/internal/service"
+	"github.com/MyNameIsWhaaat/shortener/internal/ui"
 	"github.com/gorilla/mux"
 )
 
+// pathVar is the route variable holding the path after the short code, see
+// service.ShortenerService.ForwardPath
+const pathVar = "path"
+
 func (h *Handler) Redirect(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	shortCode := vars["short_code"]
 
 	logger.Info("Redirect request", "short_code", shortCode)
 
-	url, err := h.shortenerService.GetOriginalURL(r.Context(), shortCode)
+	if wantsPreview(r) {
+		h.Preview(w, r)
+		return
+	}
+
+	url, err := h.shortenerService.GetOriginalURL(r.Context(), r.Host, shortCode)
+	if err == nil {
+		url, err = h.shortenerService.ForwardPath(url, vars[pathVar])
+	}
+	if err != nil {
+		h.redirectError(w, r, shortCode, err)
+		return
+	}
+
+	if url.Protected {
+		if err := ui.RenderPasswordForm(w, shortCode, "", http.StatusOK); err != nil {
+			logger.Error("Failed to render password form", "short_code", shortCode, "error", err)
+		}
+		return
+	}
+
+	h.follow(w, r, url, url.RedirectStatus())
+}
+
+// Unlock handles the password form submitted for a protected link
+func (h *Handler) Unlock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	url, err := h.shortenerService.UnlockURL(r.Context(), r.Host, shortCode, r.FormValue("password"))
+	if err != nil {
+		var renderErr error
+		switch err {
+		case service.ErrInvalidPassword:
+			renderErr = ui.RenderPasswordForm(w, shortCode, "Неверный пароль", http.StatusUnauthorized)
+		case service.ErrTooManyAttempts:
+			renderErr = ui.RenderPasswordForm(w, shortCode, "Слишком много попыток, попробуйте позже", http.StatusTooManyRequests)
+		default:
+			h.redirectError(w, r, shortCode, err)
+		}
+		if renderErr != nil {
+			logger.Error("Failed to render password form", "short_code", shortCode, "error", renderErr)
+		}
+		return
+	}
+
+	url, err = h.shortenerService.ForwardPath(url, vars[pathVar])
 	if err != nil {
+		h.redirectError(w, r, shortCode, err)
+		return
+	}
+
+	h.follow(w, r, url, http.StatusSeeOther)
+}
+
+// follow sends the visitor on to the link destination with the given status,
+// or to the interstitial page when the link asks for one. Either way the
+// visit counts as a click.
+func (h *Handler) follow(w http.ResponseWriter, r *http.Request, url *domain.URL, status int) {
+	visitor := domain.NewVisitor(r.UserAgent(), r.Header.Get("Accept-Language"))
+
+	// Browsers cannot be redirected to a custom scheme reliably, so those
+	// apps are opened from a bridge page that falls back to the web
+	if app := url.AppURL(visitor); app != "" && !domain.IsWebURL(app) {
+		if err := h.trackClick(r, url, ""); err != nil {
+			h.redirectError(w, r, url.ShortCode, err)
+			return
+		}
+		h.openApp(w, r, url, app)
+		return
+	}
+
+	destination, variant := resolveDestination(w, r, url, visitor)
+	if err := h.trackClick(r, url, variant); err != nil {
+		h.redirectError(w, r, url.ShortCode, err)
+		return
+	}
+
+	if showsInterstitial(r, url, destination) {
+		logger.Info("Showing interstitial", "short_code", url.ShortCode, "url", destination)
+		page := ui.Preview{
+			ShortCode:    url.ShortCode,
+			Destination:  destination,
+			CreatedAt:    url.CreatedAt,
+			Clicks:       url.Clicks,
+			Interstitial: true,
+			ContinueURL:  destination,
+		}
+		if err := ui.RenderPreview(w, page, http.StatusOK); err != nil {
+			logger.Error("Failed to render interstitial", "short_code", url.ShortCode, "error", err)
+		}
+		return
+	}
+
+	logger.Info("Redirecting", "short_code", url.ShortCode, "url", destination, "status", status)
+	http.Redirect(w, r, destination, status)
+}
+
+// resolveDestination picks the link destination for the visitor making the
+// request and applies the link's query options to it. App links for the
+// visitor's platform come first, then targeting rules, then split-test
+// variants; the chosen variant name is returned so the click can be
+// attributed to it.
+func resolveDestination(w http.ResponseWriter, r *http.Request, url *domain.URL, visitor domain.Visitor) (string, string) {
+	if app := url.AppURL(visitor); app != "" {
+		return app, ""
+	}
+
+	target, matched := url.Target(visitor)
+	if matched {
+		return url.Destination(target, r.URL.Query()), ""
+	}
+
+	variant := chooseVariant(w, r, url)
+	if variant == nil {
+		return url.Destination(target, r.URL.Query()), ""
+	}
+
+	return url.Destination(variant.URL, r.URL.Query()), variant.Name
+}
+
+// redirectError reports a link that cannot be followed. Browsers get the
+// branded HTML page, API clients the usual JSON error.
+func (h *Handler) redirectError(w http.ResponseWriter, r *http.Request, shortCode string, err error) {
+	var (
+		state   ui.ErrorState
+		message string
+		status  = http.StatusGone
+	)
+	switch {
+	case service.IsExpired(err):
+		logger.Info("URL expired", "short_code", shortCode)
+		state, message = ui.ErrorExpired, "URL expired"
+	case service.IsDisabled(err):
+		logger.Info("URL disabled", "short_code", shortCode)
+		state, message = ui.ErrorDisabled, "URL has been disabled"
+	case service.IsDeleted(err):
+		logger.Info("URL deleted", "short_code", shortCode)
+		state, message = ui.ErrorDeleted, "URL has been removed"
+	default:
 		logger.Error("URL not found", "short_code", shortCode, "error", err)
-		http.Error(w, "URL not found", http.StatusNotFound)
+		state, message, status = ui.ErrorNotFound, "URL not found", http.StatusNotFound
+	}
+
+	if !acceptsHTML(r) {
+		h.respondError(w, message, status)
 		return
 	}
 
-	remoteAddr := r.RemoteAddr
-	ip := remoteAddr
-	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+	if err := ui.RenderError(w, state, shortCode, h.branding, status); err != nil {
+		logger.Error("Failed to render error page", "short_code", shortCode, "error", err)
+	}
+}
+
+// acceptsHTML reports whether the client asked for an HTML page. Browsers
+// always list text/html in Accept; API clients and tools like curl don't.
+func acceptsHTML(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+			return true
+		}
+	}
+	return false
+}
+
+// trackClick records the click of a visitor about to be sent on. It only
+// returns an error when the visitor must not be: when the click budget of
+// the link ran out since it was loaded.
+func (h *Handler) trackClick(r *http.Request, url *domain.URL, variant string) error {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
 		ip = host
 	}
 
-	logger.Info("Request IP", "remote_addr", remoteAddr, "ip", ip)
+	userAgent, referer := r.UserAgent(), r.Referer()
+	isBot := domain.IsBot(r.Method, userAgent, r.Header.Get("Accept"))
 
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := h.shortenerService.TrackClick(ctx, shortCode,
-			r.UserAgent(), ip, r.Referer()); err != nil {
-			logger.Error("Failed to track click", "short_code", shortCode, "error", err)
-		} else {
-			logger.Info("Click tracked successfully", "short_code", shortCode)
-		}
-	}()
+	// With a click ingester the service queues clicks for batch writes and
+	// this does not wait for the database; without one the click is written
+	// here, on the request path. The request context is not used since the
+	// click should be recorded even if the visitor goes away.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
 
-	logger.Info("Redirecting", "short_code", shortCode, "url", url.OriginalURL)
-	http.Redirect(w, r, url.OriginalURL, http.StatusFound)
+	err := h.shortenerService.TrackClick(ctx, url, userAgent, ip, referer, variant, isBot)
+	switch {
+	case err == service.ErrClickDropped:
+		logger.Warn("Click dropped, queue is full", "short_code", url.ShortCode)
+	case service.IsExpired(err):
+		return err
+	case err != nil:
+		logger.Error("Failed to track click", "short_code", url.ShortCode, "error", err)
+	}
+	return nil
 }
@@ -4,33 +4,118 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/MyNameIsWhaaat/shortener/internal/domain"
 	"github.com/MyNameIsWhaaat/shortener/internal/service"
 )
 
 type shortenRequest struct {
-	URL         string  `json:"url"`
-	CustomAlias *string `json:"custom_alias,omitempty"`
+	URL          string            `json:"url"`
+	Domain       string            `json:"domain,omitempty"`
+	CustomAlias  *string           `json:"custom_alias,omitempty"`
+	ExpiresAt    *time.Time        `json:"expires_at,omitempty"`
+	MaxClicks    *int64            `json:"max_clicks,omitempty"`
+	Password     *string           `json:"password,omitempty"`
+	Dedupe       *bool             `json:"dedupe,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	RedirectType *int              `json:"redirect_type,omitempty"`
+	ForwardQuery bool              `json:"forward_query,omitempty"`
+	ForwardPath  bool              `json:"forward_path,omitempty"`
+	UTM          *domain.UTMParams `json:"utm,omitempty"`
+
+	Targeting      []domain.TargetingRule `json:"targeting,omitempty"`
+	Variants       []domain.Variant       `json:"variants,omitempty"`
+	StickyVariants bool                   `json:"sticky_variants,omitempty"`
+	Interstitial   bool                   `json:"interstitial,omitempty"`
+
+	domain.DeepLinks
 }
 
 type shortenResponse struct {
-	ShortCode   string `json:"short_code"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	Domain       string            `json:"domain,omitempty"`
+	ShortCode    string            `json:"short_code"`
+	ShortURL     string            `json:"short_url"`
+	OriginalURL  string            `json:"original_url"`
+	ExpiresAt    *time.Time        `json:"expires_at,omitempty"`
+	MaxClicks    *int64            `json:"max_clicks,omitempty"`
+	Protected    bool              `json:"protected,omitempty"`
+	Existing     bool              `json:"existing,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	RedirectType int               `json:"redirect_type,omitempty"`
+	ForwardQuery bool              `json:"forward_query,omitempty"`
+	ForwardPath  bool              `json:"forward_path,omitempty"`
+	UTM          *domain.UTMParams `json:"utm,omitempty"`
+
+	Targeting      []domain.TargetingRule `json:"targeting,omitempty"`
+	Variants       []domain.Variant       `json:"variants,omitempty"`
+	StickyVariants bool                   `json:"sticky_variants,omitempty"`
+	Interstitial   bool                   `json:"interstitial,omitempty"`
+
+	domain.DeepLinks
 }
 
+// batchShortenResult holds either the created link or the item's error
+type batchShortenResult struct {
+	*shortenResponse
+	Error string `json:"error,omitempty"`
+}
+
+// GetAllURLs lists links. Passing a cursor parameter (empty for the first
+// page) switches the response to a page envelope with next_cursor; without
+// it the bare array used by the UI is returned.
 func (h *Handler) GetAllURLs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
 	limit := 50
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	order := domain.URLOrder(query.Get("order"))
+	page, err := h.shortenerService.ListURLs(r.Context(), limit, query["tag"], order, query.Get("cursor"))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidTag, service.ErrTooManyTags:
+			h.respondError(w, "Invalid tag filter", http.StatusBadRequest)
+		case service.ErrInvalidOrder:
+			h.respondError(w, "Order must be one of: created, clicks", http.StatusBadRequest)
+		case service.ErrInvalidCursor:
+			h.respondError(w, "Invalid cursor", http.StatusBadRequest)
+		default:
+			h.respondError(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if _, paged := query["cursor"]; paged {
+		h.respond(w, page, http.StatusOK)
+		return
+	}
+
+	h.respond(w, page.URLs, http.StatusOK)
+}
+
+func (h *Handler) SearchURLs(w http.ResponseWriter, r *http.Request) {
+	limit := 20
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
 		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
 			limit = l
 		}
 	}
 
-	urls, err := h.shortenerService.GetAllURLs(r.Context(), limit)
+	urls, err := h.shortenerService.SearchURLs(r.Context(), r.URL.Query().Get("q"), limit)
 	if err != nil {
-		h.respondError(w, err.Error(), http.StatusInternalServerError)
+		switch err {
+		case service.ErrEmptyQuery:
+			h.respondError(w, "Search query cannot be empty", http.StatusBadRequest)
+		case service.ErrQueryTooLong:
+			h.respondError(w, "Search query too long", http.StatusBadRequest)
+		default:
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -44,12 +129,7 @@ func (h *Handler) Shorten(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createReq := &domain.CreateURLRequest{
-		URL:         req.URL,
-		CustomAlias: req.CustomAlias,
-	}
-
-	resp, err := h.shortenerService.CreateShortURL(r.Context(), createReq)
+	resp, err := h.shortenerService.CreateShortURL(r.Context(), req.toCreateURLRequest())
 	if err != nil {
 		switch {
 		case service.IsAlreadyExists(err):
@@ -60,15 +140,133 @@ func (h *Handler) Shorten(w http.ResponseWriter, r *http.Request) {
 			h.respondError(w, "URL cannot be empty", http.StatusBadRequest)
 		case err == service.ErrInvalidShortCode:
 			h.respondError(w, "Invalid custom short code", http.StatusBadRequest)
+		case err == service.ErrInvalidExpiresAt:
+			h.respondError(w, "Expiration date must be in the future", http.StatusBadRequest)
+		case err == service.ErrInvalidMaxClicks:
+			h.respondError(w, "Max clicks must be positive", http.StatusBadRequest)
+		case err == service.ErrEmptyPassword:
+			h.respondError(w, "Password cannot be empty", http.StatusBadRequest)
+		case err == service.ErrInvalidTag:
+			h.respondError(w, "Tags must be non-empty and at most 50 characters", http.StatusBadRequest)
+		case err == service.ErrTooManyTags:
+			h.respondError(w, "Too many tags", http.StatusBadRequest)
+		case err == service.ErrInvalidRedirectType:
+			h.respondError(w, "Redirect type must be one of 301, 302, 307, 308", http.StatusBadRequest)
+		case err == service.ErrInvalidUTM:
+			h.respondError(w, "UTM parameters must be at most 255 characters", http.StatusBadRequest)
+		case err == service.ErrInvalidTargetingRule:
+			h.respondError(w, "Invalid targeting rule", http.StatusBadRequest)
+		case err == service.ErrTooManyTargetingRules:
+			h.respondError(w, "Too many targeting rules", http.StatusBadRequest)
+		case err == service.ErrInvalidVariant:
+			h.respondError(w, "Invalid variant", http.StatusBadRequest)
+		case err == service.ErrTooManyVariants:
+			h.respondError(w, "Too many variants", http.StatusBadRequest)
+		case err == service.ErrInvalidDeepLink:
+			h.respondError(w, "Invalid deep link", http.StatusBadRequest)
+		case err == service.ErrUnknownDomain:
+			h.respondError(w, "Domain is not registered", http.StatusBadRequest)
 		default:
 			h.respondError(w, "Internal server error", http.StatusInternalServerError)
 		}
 		return
 	}
 
-	h.respond(w, shortenResponse{
-		ShortCode:   resp.ShortCode,
-		ShortURL:    resp.ShortURL,
-		OriginalURL: resp.OriginalURL,
-	}, http.StatusCreated)
+	status := http.StatusCreated
+	if resp.Existing {
+		status = http.StatusOK
+	}
+
+	h.respond(w, newShortenResponse(resp), status)
+}
+
+func (h *Handler) ShortenBatch(w http.ResponseWriter, r *http.Request) {
+	var reqs []shortenRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		h.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	createReqs := make([]*domain.CreateURLRequest, len(reqs))
+	for i, req := range reqs {
+		createReqs[i] = req.toCreateURLRequest()
+	}
+
+	results, err := h.shortenerService.CreateShortURLs(r.Context(), createReqs)
+	if err != nil {
+		switch err {
+		case service.ErrEmptyBatch:
+			h.respondError(w, "Batch cannot be empty", http.StatusBadRequest)
+		case service.ErrBatchTooLarge:
+			h.respondError(w, "Batch too large", http.StatusRequestEntityTooLarge)
+		default:
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := make([]batchShortenResult, len(results))
+	for i, result := range results {
+		if result.Err != nil {
+			resp[i].Error = result.Err.Error()
+			continue
+		}
+		resp[i].shortenResponse = newShortenResponse(result.Response)
+	}
+
+	h.respond(w, resp, http.StatusOK)
+}
+
+func (req shortenRequest) toCreateURLRequest() *domain.CreateURLRequest {
+	return &domain.CreateURLRequest{
+		URL:          req.URL,
+		Domain:       req.Domain,
+		CustomAlias:  req.CustomAlias,
+		ExpiresAt:    req.ExpiresAt,
+		MaxClicks:    req.MaxClicks,
+		Password:     req.Password,
+		Dedupe:       req.Dedupe,
+		Tags:         req.Tags,
+		RedirectType: req.RedirectType,
+		ForwardQuery: req.ForwardQuery,
+		ForwardPath:  req.ForwardPath,
+		UTM:          req.UTM,
+		Targeting:    req.Targeting,
+
+		Variants:       req.Variants,
+		StickyVariants: req.StickyVariants,
+		Interstitial:   req.Interstitial,
+		DeepLinks:      req.DeepLinks,
+	}
+}
+
+func newShortenResponse(resp *domain.CreateURLResponse) *shortenResponse {
+	shortenResp := &shortenResponse{
+		Domain:       resp.Domain,
+		ShortCode:    resp.ShortCode,
+		ShortURL:     resp.ShortURL,
+		OriginalURL:  resp.OriginalURL,
+		ExpiresAt:    resp.ExpiresAt,
+		MaxClicks:    resp.MaxClicks,
+		Protected:    resp.Protected,
+		Existing:     resp.Existing,
+		Tags:         resp.Tags,
+		ForwardQuery: resp.ForwardQuery,
+		ForwardPath:  resp.ForwardPath,
+		UTM:          resp.UTM,
+		Targeting:    resp.Targeting,
+
+		Variants:       resp.Variants,
+		StickyVariants: resp.StickyVariants,
+		Interstitial:   resp.Interstitial,
+		DeepLinks:      resp.DeepLinks,
+	}
+
+	// Like the other optional settings, the redirect type is only reported
+	// when it differs from the default
+	if resp.RedirectType != domain.DefaultRedirectType {
+		shortenResp.RedirectType = resp.RedirectType
+	}
+
+	return shortenResp
 }
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/service"
+	"github.com/gorilla/mux"
+)
+
+type addTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+func (h *Handler) AddTags(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	var req addTagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	url, err := h.shortenerService.AddTags(r.Context(), linkDomain(r), shortCode, req.Tags)
+	h.respondTagged(w, url, err)
+}
+
+func (h *Handler) RemoveTag(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	url, err := h.shortenerService.RemoveTag(r.Context(), linkDomain(r), vars["short_code"], vars["tag"])
+	h.respondTagged(w, url, err)
+}
+
+func (h *Handler) GetTags(w http.ResponseWriter, r *http.Request) {
+	counts, err := h.shortenerService.GetTagCounts(r.Context())
+	if err != nil {
+		h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.respond(w, counts, http.StatusOK)
+}
+
+func (h *Handler) respondTagged(w http.ResponseWriter, url *domain.URL, err error) {
+	if err != nil {
+		switch {
+		case service.IsNotFound(err):
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		case err == service.ErrInvalidShortCode:
+			h.respondError(w, "Short code is required", http.StatusBadRequest)
+		case err == service.ErrInvalidTag:
+			h.respondError(w, "Tags must be non-empty and at most 50 characters", http.StatusBadRequest)
+		case err == service.ErrTooManyTags:
+			h.respondError(w, "Too many tags", http.StatusBadRequest)
+		default:
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, url, http.StatusOK)
+}
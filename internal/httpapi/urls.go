@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/service"
+	"github.com/gorilla/mux"
+)
+
+type updateURLRequest struct {
+	URL string `json:"url"`
+}
+
+type targetingRequest struct {
+	Rules []domain.TargetingRule `json:"rules"`
+}
+
+func (h *Handler) UpdateURL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	var req updateURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	url, err := h.shortenerService.UpdateDestination(r.Context(), linkDomain(r), shortCode, req.URL)
+	if err != nil {
+		switch {
+		case service.IsNotFound(err):
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		case service.IsInvalidURL(err):
+			h.respondError(w, "Invalid URL", http.StatusBadRequest)
+		case err == service.ErrInvalidShortCode:
+			h.respondError(w, "Short code is required", http.StatusBadRequest)
+		default:
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, url, http.StatusOK)
+}
+
+// SetTargeting replaces the targeting rules of a link
+func (h *Handler) SetTargeting(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	var req targetingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	url, err := h.shortenerService.SetTargeting(r.Context(), linkDomain(r), shortCode, req.Rules)
+	if err != nil {
+		switch {
+		case service.IsNotFound(err):
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		case err == service.ErrInvalidShortCode:
+			h.respondError(w, "Short code is required", http.StatusBadRequest)
+		case err == service.ErrInvalidTargetingRule:
+			h.respondError(w, "Invalid targeting rule", http.StatusBadRequest)
+		case err == service.ErrTooManyTargetingRules:
+			h.respondError(w, "Too many targeting rules", http.StatusBadRequest)
+		default:
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, url, http.StatusOK)
+}
+
+func (h *Handler) DisableURL(w http.ResponseWriter, r *http.Request) {
+	h.changeURLState(w, r, h.shortenerService.DisableURL)
+}
+
+func (h *Handler) EnableURL(w http.ResponseWriter, r *http.Request) {
+	h.changeURLState(w, r, h.shortenerService.EnableURL)
+}
+
+func (h *Handler) DeleteURL(w http.ResponseWriter, r *http.Request) {
+	h.changeURLState(w, r, h.shortenerService.DeleteURL)
+}
+
+func (h *Handler) changeURLState(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, host, shortCode string) error) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	if err := change(r.Context(), linkDomain(r), shortCode); err != nil {
+		switch {
+		case service.IsNotFound(err):
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		case err == service.ErrInvalidShortCode:
+			h.respondError(w, "Short code is required", http.StatusBadRequest)
+		default:
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
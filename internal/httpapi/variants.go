@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/service"
+	"github.com/gorilla/mux"
+)
+
+const (
+	variantCookiePrefix = "variant_"
+	variantCookieMaxAge = 30 * 24 * time.Hour
+)
+
+type variantsRequest struct {
+	Variants []domain.Variant `json:"variants"`
+	Sticky   bool             `json:"sticky"`
+}
+
+// SetVariants replaces the split-test variants of a link
+func (h *Handler) SetVariants(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["short_code"]
+
+	var req variantsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	url, err := h.shortenerService.SetVariants(r.Context(), linkDomain(r), shortCode, req.Variants, req.Sticky)
+	if err != nil {
+		switch {
+		case service.IsNotFound(err):
+			h.respondError(w, "URL not found", http.StatusNotFound)
+		case err == service.ErrInvalidShortCode:
+			h.respondError(w, "Short code is required", http.StatusBadRequest)
+		case err == service.ErrInvalidVariant:
+			h.respondError(w, "Invalid variant", http.StatusBadRequest)
+		case err == service.ErrTooManyVariants:
+			h.respondError(w, "Too many variants", http.StatusBadRequest)
+		default:
+			h.respondError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.respond(w, url, http.StatusOK)
+}
+
+// chooseVariant picks the split-test variant for a visitor. For sticky links
+// the choice is remembered in a cookie scoped to the short link.
+func chooseVariant(w http.ResponseWriter, r *http.Request, url *domain.URL) *domain.Variant {
+	total := url.VariantWeight()
+	if total <= 0 {
+		return nil
+	}
+
+	cookieName := variantCookiePrefix + url.ShortCode
+	if url.StickyVariants {
+		if cookie, err := r.Cookie(cookieName); err == nil {
+			if variant := url.Variant(cookie.Value); variant != nil {
+				return variant
+			}
+		}
+	}
+
+	variant := url.PickVariant(rand.IntN(total))
+	if url.StickyVariants && variant != nil {
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookieName,
+			Value:    variant.Name,
+			Path:     "/s/" + url.ShortCode,
+			MaxAge:   int(variantCookieMaxAge.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	return variant
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/MyNameIsWhaaat/shortener/internal/domain"
 	"github.com/MyNameIsWhaaat/shortener/internal/logger"
@@ -11,28 +12,76 @@ import (
 
 type analyticsService struct {
 	analyticsStore store.AnalyticsStore
+	visitors       *VisitorCounter
+	anonymizer     *IPAnonymizer
 }
 
-func NewAnalyticsService(analyticsStore store.AnalyticsStore) AnalyticsService {
+// NewAnalyticsService creates the analytics service. Unique visitors are
+// only reported when visitors is not nil. Addresses of recent clicks are
+// redacted with anonymizer, or with the default prefixes if it is nil.
+func NewAnalyticsService(analyticsStore store.AnalyticsStore, visitors *VisitorCounter, anonymizer *IPAnonymizer) AnalyticsService {
+	if anonymizer == nil {
+		anonymizer, _ = NewIPAnonymizer(domain.IPPrivacyTruncate, defaultIPv4Prefix, defaultIPv6Prefix, "", 0)
+	}
+
 	return &analyticsService{
 		analyticsStore: analyticsStore,
+		visitors:       visitors,
+		anonymizer:     anonymizer,
 	}
 }
 
-func (s *analyticsService) GetAnalytics(ctx context.Context, shortCode string) (*domain.AnalyticsResponse, error) {
+func (s *analyticsService) GetAnalytics(ctx context.Context, host, shortCode string) (*domain.AnalyticsResponse, error) {
 	if shortCode == "" {
 		return nil, ErrInvalidShortCode
 	}
 
-	analytics, err := s.analyticsStore.GetAnalytics(ctx, shortCode)
+	analytics, err := s.analyticsStore.GetAnalytics(ctx, host, shortCode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get analytics from store: %w", err)
 	}
+	s.redactIPs(analytics.RecentClicks)
+
+	if s.visitors != nil {
+		// Same ranges as the daily and monthly click stats
+		visitors, err := s.visitors.Count(ctx, host, shortCode, 30, 12, time.Now())
+		if err != nil {
+			logger.Error("Failed to count unique visitors", "short_code", shortCode, "error", err)
+		} else {
+			analytics.UniqueVisitors = visitors
+		}
+	}
 
 	return analytics, nil
 }
 
-func (s *analyticsService) GetDailyStats(ctx context.Context, shortCode string, days int) (map[string]int64, error) {
+// GetUniqueVisitors estimates the unique visitors of a link over all time
+// and per day and month
+func (s *analyticsService) GetUniqueVisitors(ctx context.Context, host, shortCode string, days, months int) (*domain.UniqueVisitors, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+
+	if days <= 0 || days > domain.MaxVisitorDays {
+		days = 30
+	}
+	if months <= 0 || months > domain.MaxVisitorMonths {
+		months = 12
+	}
+
+	if s.visitors == nil {
+		return &domain.UniqueVisitors{Daily: map[string]int64{}, Monthly: map[string]int64{}}, nil
+	}
+
+	visitors, err := s.visitors.Count(ctx, host, shortCode, days, months, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to count unique visitors: %w", err)
+	}
+
+	return visitors, nil
+}
+
+func (s *analyticsService) GetDailyStats(ctx context.Context, host, shortCode string, days int) (map[string]int64, error) {
 	logger.Info("GetDailyStats", "short_code", shortCode, "days", days)
 
 	if shortCode == "" {
@@ -43,7 +92,7 @@ func (s *analyticsService) GetDailyStats(ctx context.Context, shortCode string,
 		days = 30
 	}
 
-	stats, err := s.analyticsStore.GetDailyStats(ctx, shortCode, days)
+	stats, err := s.analyticsStore.GetDailyStats(ctx, host, shortCode, days)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get daily stats: %w", err)
 	}
@@ -51,7 +100,7 @@ func (s *analyticsService) GetDailyStats(ctx context.Context, shortCode string,
 	return stats, nil
 }
 
-func (s *analyticsService) GetMonthlyStats(ctx context.Context, shortCode string, months int) (map[string]int64, error) {
+func (s *analyticsService) GetMonthlyStats(ctx context.Context, host, shortCode string, months int) (map[string]int64, error) {
 	if shortCode == "" {
 		return nil, ErrInvalidShortCode
 	}
@@ -60,7 +109,7 @@ func (s *analyticsService) GetMonthlyStats(ctx context.Context, shortCode string
 		months = 12
 	}
 
-	stats, err := s.analyticsStore.GetMonthlyStats(ctx, shortCode, months)
+	stats, err := s.analyticsStore.GetMonthlyStats(ctx, host, shortCode, months)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get monthly stats: %w", err)
 	}
@@ -68,14 +117,14 @@ func (s *analyticsService) GetMonthlyStats(ctx context.Context, shortCode string
 	return stats, nil
 }
 
-func (s *analyticsService) GetDeviceStats(ctx context.Context, shortCode string) (map[string]int64, error) {
+func (s *analyticsService) GetDeviceStats(ctx context.Context, host, shortCode string) (map[string]int64, error) {
 	if shortCode == "" {
 		return nil, ErrInvalidShortCode
 	}
 
 	logger.Info("GetDeviceStats", "short_code", shortCode)
 
-	stats, err := s.analyticsStore.GetDeviceStats(ctx, shortCode)
+	stats, err := s.analyticsStore.GetDeviceStats(ctx, host, shortCode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get device stats: %w", err)
 	}
@@ -83,7 +132,63 @@ func (s *analyticsService) GetDeviceStats(ctx context.Context, shortCode string)
 	return stats, nil
 }
 
-func (s *analyticsService) GetRecentClicks(ctx context.Context, shortCode string, limit int) ([]domain.ClickEvent, error) {
+// GetBrowserStats counts clicks by browser family, and by major version when
+// versions is set
+func (s *analyticsService) GetBrowserStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+
+	stats, err := s.analyticsStore.GetBrowserStats(ctx, host, shortCode, versions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get browser stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// GetOSStats counts clicks by operating system, and by its version when
+// versions is set
+func (s *analyticsService) GetOSStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+
+	stats, err := s.analyticsStore.GetOSStats(ctx, host, shortCode, versions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get os stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+func (s *analyticsService) GetVariantStats(ctx context.Context, host, shortCode string) (map[string]int64, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+
+	stats, err := s.analyticsStore.GetVariantStats(ctx, host, shortCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+func (s *analyticsService) GetBotStats(ctx context.Context, host, shortCode string) (*domain.BotStats, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+
+	stats, err := s.analyticsStore.GetBotStats(ctx, host, shortCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bot stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+func (s *analyticsService) GetRecentClicks(ctx context.Context, host, shortCode string, limit int) ([]domain.ClickEvent, error) {
 	if shortCode == "" {
 		return nil, ErrInvalidShortCode
 	}
@@ -92,10 +197,18 @@ func (s *analyticsService) GetRecentClicks(ctx context.Context, shortCode string
 		limit = 10
 	}
 
-	clicks, err := s.analyticsStore.GetRecentClicks(ctx, shortCode, limit)
+	clicks, err := s.analyticsStore.GetRecentClicks(ctx, host, shortCode, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get recent clicks: %w", err)
 	}
+	s.redactIPs(clicks)
 
 	return clicks, nil
 }
+
+// redactIPs makes sure no full client address leaves the service
+func (s *analyticsService) redactIPs(clicks []domain.ClickEvent) {
+	for i := range clicks {
+		s.anonymizer.Redact(&clicks[i])
+	}
+}
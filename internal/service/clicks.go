@@ -0,0 +1,439 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/logger"
+	"github.com/MyNameIsWhaaat/shortener/internal/spool"
+	"github.com/MyNameIsWhaaat/shortener/internal/store"
+)
+
+const (
+	// flushTimeout bounds a single batch write to the store
+	flushTimeout = 10 * time.Second
+
+	// replayInterval is how often the spool is replayed while it holds clicks
+	replayInterval = 5 * time.Second
+)
+
+// clickJob is a click waiting to be written. counted tells whether it adds
+// to the link's click counter.
+type clickJob struct {
+	event   *domain.ClickEvent
+	counted bool
+}
+
+// spooledBatch is a batch of clicks as written to the spool
+type spooledBatch struct {
+	Events []*domain.ClickEvent `json:"events"`
+	Counts []domain.ClickCount  `json:"counts"`
+}
+
+// ClickIngester buffers click events in a bounded queue and writes them to
+// the store in batches from a fixed pool of workers. When the queue is full
+// new clicks are dropped instead of piling up behind a slow database.
+//
+// Batches that fail to write are appended to the spool, if there is one,
+// and replayed in order once the database answers pings again. While the
+// spool holds clicks new batches go to the spool as well, so that they
+// reach the database after the older ones.
+type ClickIngester struct {
+	store         store.AnalyticsStore
+	visitors      *VisitorCounter
+	spool         *spool.Spool
+	pinger        store.Pinger
+	queue         chan clickJob
+	batchSize     int
+	flushInterval time.Duration
+
+	// mu guards sends on queue against Close
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	stopReplay chan struct{}
+	replayDone chan struct{}
+
+	enqueued atomic.Int64
+	dropped  atomic.Int64
+	written  atomic.Int64
+	failed   atomic.Int64
+	batches  atomic.Int64
+	spooled  atomic.Int64
+	replayed atomic.Int64
+}
+
+// NewClickIngester starts workers goroutines that write batches of up to
+// batchSize clicks, or whatever is queued every flushInterval. Unique
+// visitors are counted with visitors unless it is nil. clickSpool may
+// be nil, in which case failed batches are lost; otherwise pinger tells when
+// the spool can be replayed. Clicks recovered from a previous run are
+// replayed right away.
+func NewClickIngester(analyticsStore store.AnalyticsStore, visitors *VisitorCounter, clickSpool *spool.Spool, pinger store.Pinger, queueSize, batchSize int, flushInterval time.Duration, workers int) *ClickIngester {
+	if queueSize <= 0 {
+		queueSize = 10000
+	}
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+
+	ingester := &ClickIngester{
+		store:         analyticsStore,
+		visitors:      visitors,
+		spool:         clickSpool,
+		pinger:        pinger,
+		queue:         make(chan clickJob, queueSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+
+	ingester.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go ingester.run()
+	}
+
+	if clickSpool != nil {
+		ingester.stopReplay = make(chan struct{})
+		ingester.replayDone = make(chan struct{})
+		go ingester.replayLoop()
+	}
+
+	return ingester
+}
+
+// Enqueue adds a click to the queue without blocking. It reports false if
+// the click was dropped because the queue is full or the ingester is closed.
+func (c *ClickIngester) Enqueue(event *domain.ClickEvent, counted bool) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		c.dropped.Add(1)
+		return false
+	}
+
+	select {
+	case c.queue <- clickJob{event: event, counted: counted}:
+		c.enqueued.Add(1)
+		return true
+	default:
+		c.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting clicks and waits until the workers have flushed the
+// rest of the queue, or until ctx is done. Clicks still in the spool are
+// left there for the next start.
+func (c *ClickIngester) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+		if c.stopReplay != nil {
+			close(c.stopReplay)
+		}
+	}
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		if c.replayDone != nil {
+			<-c.replayDone
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the queue counters
+func (c *ClickIngester) Stats() domain.IngestStats {
+	var spoolBytes int64
+	if c.spool != nil {
+		spoolBytes = c.spool.Size()
+	}
+
+	return domain.IngestStats{
+		QueueLength:   len(c.queue),
+		QueueCapacity: cap(c.queue),
+		Enqueued:      c.enqueued.Load(),
+		Dropped:       c.dropped.Load(),
+		Written:       c.written.Load(),
+		Failed:        c.failed.Load(),
+		Batches:       c.batches.Load(),
+		Spooled:       c.spooled.Load(),
+		Replayed:      c.replayed.Load(),
+		SpoolBytes:    spoolBytes,
+	}
+}
+
+func (c *ClickIngester) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]clickJob, 0, c.batchSize)
+	for {
+		select {
+		case job, ok := <-c.queue:
+			if !ok {
+				c.flush(batch)
+				return
+			}
+			batch = append(batch, job)
+			if len(batch) >= c.batchSize {
+				c.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			c.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush writes batch with one aggregated counter update per link
+func (c *ClickIngester) flush(batch []clickJob) {
+	if len(batch) == 0 {
+		return
+	}
+
+	events, counts := aggregateClicks(batch)
+	c.batches.Add(1)
+
+	if c.spool != nil && c.spool.Pending() {
+		c.spoolBatch(events, counts)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	if err := c.store.SaveClickEvents(ctx, events, counts); err != nil {
+		logger.Error("Failed to write click batch", "events", len(events), "error", err)
+		if store.IsPermanentError(err) {
+			c.saveEach(events, counts)
+		} else if c.spool != nil {
+			c.spoolBatch(events, counts)
+		} else {
+			c.failed.Add(int64(len(events)))
+		}
+		return
+	}
+	c.written.Add(int64(len(events)))
+	c.addVisitors(events)
+}
+
+// saveEach writes the clicks of a batch that was rejected by the database
+// one at a time, so that the clicks it rejects for good do not take the rest
+// of the batch with them. The rejected clicks are discarded. Should the
+// database become unavailable part way through, the remaining clicks are
+// spooled, or lost without a spool.
+func (c *ClickIngester) saveEach(events []*domain.ClickEvent, counts []domain.ClickCount) {
+	remaining := make(map[string]int64, len(counts))
+	for _, count := range counts {
+		remaining[cacheKey(count.Domain, count.ShortCode)] += count.Clicks
+	}
+
+	for i, event := range events {
+		// The counts of a link go to its first clicks; which of its clicks
+		// were counted is not known any more
+		var count []domain.ClickCount
+		key := cacheKey(event.Domain, event.ShortCode)
+		if remaining[key] > 0 {
+			count = []domain.ClickCount{{Domain: event.Domain, ShortCode: event.ShortCode, Clicks: 1}}
+			remaining[key]--
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		err := c.store.SaveClickEvents(ctx, []*domain.ClickEvent{event}, count)
+		cancel()
+
+		switch {
+		case err == nil:
+			c.written.Add(1)
+			c.addVisitors([]*domain.ClickEvent{event})
+		case store.IsPermanentError(err):
+			c.failed.Add(1)
+			logger.Error("Discarding click rejected by the database", "short_code", event.ShortCode, "error", err)
+		default:
+			rest := events[i:]
+			logger.Error("Failed to write clicks one by one", "events", len(rest), "error", err)
+			if c.spool != nil {
+				c.spoolBatch(rest, restCounts(rest, remaining, count))
+			} else {
+				c.failed.Add(int64(len(rest)))
+			}
+			return
+		}
+	}
+}
+
+// restCounts rebuilds the counts of the clicks left over by saveEach: those
+// not handed out yet, plus count, the one of the click that failed
+func restCounts(rest []*domain.ClickEvent, remaining map[string]int64, count []domain.ClickCount) []domain.ClickCount {
+	var counts []domain.ClickCount
+	seen := make(map[string]bool)
+	for _, event := range rest {
+		key := cacheKey(event.Domain, event.ShortCode)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		clicks := remaining[key]
+		if len(count) > 0 && cacheKey(count[0].Domain, count[0].ShortCode) == key {
+			clicks += count[0].Clicks
+		}
+		if clicks > 0 {
+			counts = append(counts, domain.ClickCount{Domain: event.Domain, ShortCode: event.ShortCode, Clicks: clicks})
+		}
+	}
+	return counts
+}
+
+// addVisitors counts the unique visitors of a written batch. Adding a
+// visitor twice does not change the count, but the batch is not retried
+// either, so a failure only makes the counts a little low.
+func (c *ClickIngester) addVisitors(events []*domain.ClickEvent) {
+	if c.visitors == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	if err := c.visitors.Add(ctx, events); err != nil {
+		logger.Error("Failed to count unique visitors", "events", len(events), "error", err)
+	}
+}
+
+// spoolBatch appends a batch to the spool. The batch is lost if the spool
+// is full or cannot be written.
+func (c *ClickIngester) spoolBatch(events []*domain.ClickEvent, counts []domain.ClickCount) {
+	record, err := json.Marshal(spooledBatch{Events: events, Counts: counts})
+	if err == nil {
+		err = c.spool.Append(record)
+	}
+	if err != nil {
+		c.failed.Add(int64(len(events)))
+		logger.Error("Failed to spool click batch", "events", len(events), "error", err)
+		return
+	}
+	c.spooled.Add(int64(len(events)))
+}
+
+// replayLoop replays the spool whenever it holds clicks and the database
+// answers pings, until Close
+func (c *ClickIngester) replayLoop() {
+	defer close(c.replayDone)
+
+	ticker := time.NewTicker(replayInterval)
+	defer ticker.Stop()
+
+	for {
+		c.replay()
+
+		select {
+		case <-ticker.C:
+		case <-c.stopReplay:
+			return
+		}
+	}
+}
+
+// replay writes the spooled batches to the store, oldest first
+func (c *ClickIngester) replay() {
+	if !c.spool.Pending() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	err := c.pinger.Ping(ctx)
+	cancel()
+	if err != nil {
+		logger.Warn("Database unavailable, keeping spooled clicks", "bytes", c.spool.Size(), "error", err)
+		return
+	}
+
+	n, err := c.spool.Replay(func(record []byte) error {
+		var batch spooledBatch
+		if err := json.Unmarshal(record, &batch); err != nil {
+			// The checksum matched, so retrying will not help
+			logger.Error("Skipping unreadable spooled click batch", "error", err)
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+
+		if err := c.store.SaveClickEvents(ctx, batch.Events, batch.Counts); err != nil {
+			if !store.IsPermanentError(err) {
+				return err
+			}
+			// Retrying the batch would fail the same way and hold up the
+			// spool for good
+			logger.Error("Failed to replay spooled click batch", "events", len(batch.Events), "error", err)
+			c.saveEach(batch.Events, batch.Counts)
+			return nil
+		}
+		c.replayed.Add(int64(len(batch.Events)))
+		c.addVisitors(batch.Events)
+		return nil
+	})
+	if err != nil {
+		logger.Error("Failed to replay spooled clicks", "batches", n, "error", err)
+		return
+	}
+	if n > 0 {
+		logger.Info("Replayed spooled clicks", "batches", n)
+	}
+}
+
+// aggregateClicks splits a batch into the events to store and the number of
+// counted clicks per link, in the order links first appear in the batch.
+func aggregateClicks(batch []clickJob) ([]*domain.ClickEvent, []domain.ClickCount) {
+	events := make([]*domain.ClickEvent, len(batch))
+	index := make(map[string]int)
+	var counts []domain.ClickCount
+
+	for i, job := range batch {
+		events[i] = job.event
+		if !job.counted {
+			continue
+		}
+
+		key := cacheKey(job.event.Domain, job.event.ShortCode)
+		if n, ok := index[key]; ok {
+			counts[n].Clicks++
+			continue
+		}
+		index[key] = len(counts)
+		counts = append(counts, domain.ClickCount{
+			Domain:    job.event.Domain,
+			ShortCode: job.event.ShortCode,
+			Clicks:    1,
+		})
+	}
+
+	return events, counts
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+)
+
+// cursorPayload is what an opaque page cursor carries. The order is kept so
+// that a cursor cannot be replayed against a listing sorted differently.
+type cursorPayload struct {
+	Order     domain.URLOrder `json:"o"`
+	CreatedAt time.Time       `json:"t"`
+	Clicks    int64           `json:"c"`
+	ID        int64           `json:"i"`
+}
+
+func encodeCursor(order domain.URLOrder, url *domain.URL) string {
+	data, _ := json.Marshal(cursorPayload{
+		Order:     order,
+		CreatedAt: url.CreatedAt,
+		Clicks:    url.Clicks,
+		ID:        url.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string, order domain.URLOrder) (*domain.URLCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var payload cursorPayload
+	if err := json.Unmarshal(data, &payload); err != nil || payload.Order != order {
+		return nil, ErrInvalidCursor
+	}
+
+	return &domain.URLCursor{
+		CreatedAt: payload.CreatedAt,
+		Clicks:    payload.Clicks,
+		ID:        payload.ID,
+	}, nil
+}
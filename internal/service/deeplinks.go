@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+)
+
+const maxDeepLinkLength = 2048
+
+// validateDeepLinks checks the app URLs of a link. The fallback is a regular
+// web destination; app URLs may use a custom scheme.
+func (s *shortenerService) validateDeepLinks(links domain.DeepLinks) (domain.DeepLinks, error) {
+	links.IOSURL = strings.TrimSpace(links.IOSURL)
+	links.AndroidURL = strings.TrimSpace(links.AndroidURL)
+	links.FallbackURL = strings.TrimSpace(links.FallbackURL)
+
+	for _, app := range []string{links.IOSURL, links.AndroidURL} {
+		if app == "" {
+			continue
+		}
+		if len(app) > maxDeepLinkLength || !domain.IsValidAppURL(app) {
+			return domain.DeepLinks{}, ErrInvalidDeepLink
+		}
+	}
+
+	if links.FallbackURL != "" {
+		if links.IOSURL == "" && links.AndroidURL == "" {
+			return domain.DeepLinks{}, ErrInvalidDeepLink
+		}
+		if len(links.FallbackURL) > maxDeepLinkLength || s.validateURL(links.FallbackURL) != nil {
+			return domain.DeepLinks{}, ErrInvalidDeepLink
+		}
+	}
+
+	return links, nil
+}
+
+// SetDeepLinks replaces the app URLs of a link. Empty links send mobile
+// visitors to the regular destination again.
+func (s *shortenerService) SetDeepLinks(ctx context.Context, host, shortCode string, links domain.DeepLinks) (*domain.URL, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+	host = s.linkHost(host)
+
+	links, err := s.validateDeepLinks(links)
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := s.getActiveURL(ctx, host, shortCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.urlStore.SetDeepLinks(ctx, host, shortCode, links); err != nil {
+		return nil, fmt.Errorf("failed to set deep links in store: %w", err)
+	}
+
+	url.DeepLinks = links
+	s.invalidateCache(ctx, host, shortCode)
+	return url, nil
+}
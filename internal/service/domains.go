@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/logger"
+)
+
+// domainRegistryTTL bounds how long a domain added or removed through
+// another instance can go unnoticed.
+const domainRegistryTTL = 30 * time.Second
+
+var hostPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*(:[0-9]{1,5})?$`)
+
+// domainRegistry caches the set of registered short domains so that
+// redirects do not hit the store to resolve the request host.
+type domainRegistry struct {
+	mu       sync.Mutex
+	hosts    map[string]bool
+	loadedAt time.Time
+	// current is false until the first load and after Reset, when hosts may
+	// be missing a change made through this instance
+	current bool
+	// loading is closed when the load in progress, if any, finishes
+	loading chan struct{}
+}
+
+func newDomainRegistry() *domainRegistry {
+	return &domainRegistry{}
+}
+
+// Reset forces the next lookup to reload the registry
+func (r *domainRegistry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.current = false
+}
+
+// isRegisteredDomain reports whether host is a registered short domain. The
+// registry is reloaded outside the lock by one request at a time; the
+// others keep using the previous set meanwhile, and so does everyone when
+// the store cannot be reached. Only a registry that may miss a change made
+// through this instance is waited for.
+func (s *shortenerService) isRegisteredDomain(ctx context.Context, host string) (bool, error) {
+	r := s.domains
+	r.mu.Lock()
+
+	if r.current && time.Since(r.loadedAt) < domainRegistryTTL {
+		defer r.mu.Unlock()
+		return r.hosts[host], nil
+	}
+
+	if loading := r.loading; loading != nil {
+		if r.current {
+			defer r.mu.Unlock()
+			return r.hosts[host], nil
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		return s.isRegisteredDomain(ctx, host)
+	}
+
+	loading := make(chan struct{})
+	r.loading = loading
+	r.mu.Unlock()
+
+	domains, err := s.urlStore.ListDomains(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loading = nil
+	close(loading)
+
+	if err != nil {
+		if r.hosts == nil {
+			return false, fmt.Errorf("failed to load domains: %w", err)
+		}
+		// Retry after another TTL rather than on every request, also when
+		// the registry was reset
+		logger.Error("Failed to reload domains, using the previous set", "error", err)
+		r.loadedAt = time.Now()
+		r.current = true
+		return r.hosts[host], nil
+	}
+
+	r.hosts = make(map[string]bool, len(domains))
+	for _, d := range domains {
+		r.hosts[d.Host] = true
+	}
+	r.loadedAt = time.Now()
+	r.current = true
+
+	return r.hosts[host], nil
+}
+
+// linkHost normalizes the domain a link is addressed by. The host of the
+// base URL is the default domain and is stored as the empty string.
+func (s *shortenerService) linkHost(host string) string {
+	host = domain.NormalizeHost(host)
+	if host == s.baseHost {
+		return ""
+	}
+	return host
+}
+
+// resolveHost maps the host a request came in on to the domain its links
+// are stored under. Hosts that are not registered serve the default domain.
+func (s *shortenerService) resolveHost(ctx context.Context, host string) (string, error) {
+	host = s.linkHost(host)
+	if host == "" {
+		return "", nil
+	}
+
+	ok, err := s.isRegisteredDomain(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", nil
+	}
+	return host, nil
+}
+
+// createHost checks the domain requested for a new link
+func (s *shortenerService) createHost(ctx context.Context, host string) (string, error) {
+	host = s.linkHost(host)
+	if host == "" {
+		return "", nil
+	}
+
+	ok, err := s.isRegisteredDomain(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrUnknownDomain
+	}
+	return host, nil
+}
+
+// shortURL returns the public address of a link on its domain
+func (s *shortenerService) shortURL(link *domain.URL) string {
+	if link.Domain == "" {
+		return fmt.Sprintf("%s/s/%s", s.baseURL, link.ShortCode)
+	}
+
+	scheme := "https"
+	if base, err := url.Parse(s.baseURL); err == nil && base.Scheme != "" {
+		scheme = base.Scheme
+	}
+	return fmt.Sprintf("%s://%s/s/%s", scheme, link.Domain, link.ShortCode)
+}
+
+// cacheKey identifies a link in the cache and the password limiter. Links on
+// the default domain keep their bare short code as the key.
+func cacheKey(host, shortCode string) string {
+	if host == "" {
+		return shortCode
+	}
+	return host + "/" + shortCode
+}
+
+func (s *shortenerService) ListDomains(ctx context.Context) ([]domain.ShortDomain, error) {
+	domains, err := s.urlStore.ListDomains(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains: %w", err)
+	}
+
+	return domains, nil
+}
+
+// AddDomain registers an additional host to create links on. The host must
+// already point at this service.
+func (s *shortenerService) AddDomain(ctx context.Context, host string) (*domain.ShortDomain, error) {
+	host = domain.NormalizeHost(host)
+	if len(host) > 255 || !hostPattern.MatchString(host) {
+		return nil, ErrInvalidDomain
+	}
+	if host == s.baseHost {
+		return nil, ErrDomainExists
+	}
+
+	d, err := s.urlStore.AddDomain(ctx, host)
+	if err != nil {
+		if err == ErrDomainExists {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to add domain: %w", err)
+	}
+
+	s.domains.Reset()
+	return d, nil
+}
+
+// RemoveDomain unregisters a host. Domains with active links cannot be
+// removed.
+func (s *shortenerService) RemoveDomain(ctx context.Context, host string) error {
+	host = domain.NormalizeHost(host)
+	if host == "" || host == s.baseHost {
+		return ErrInvalidDomain
+	}
+
+	if err := s.urlStore.DeleteDomain(ctx, host); err != nil {
+		if err == ErrDomainNotFound || err == ErrDomainInUse {
+			return err
+		}
+		return fmt.Errorf("failed to delete domain: %w", err)
+	}
+
+	s.domains.Reset()
+	return nil
+}
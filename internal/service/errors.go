@@ -10,10 +10,47 @@ var (
 	ErrInvalidURL      = domain.ErrInvalidURL
 	ErrURLNotFound     = domain.ErrURLNotFound
 	ErrShortCodeExists = domain.ErrShortCodeExists
+	ErrURLExpired      = domain.ErrURLExpired
+	ErrURLDisabled     = domain.ErrURLDisabled
+	ErrURLDeleted      = domain.ErrURLDeleted
+	ErrDomainNotFound  = domain.ErrDomainNotFound
+	ErrDomainExists    = domain.ErrDomainExists
+	ErrDomainInUse     = domain.ErrDomainInUse
 
 	ErrEmptyURL         = errors.New("url cannot be empty")
 	ErrInvalidShortCode = errors.New("invalid short code format")
 	ErrShortCodeTooLong = errors.New("short code too long")
+	ErrInvalidExpiresAt = errors.New("expiration date must be in the future")
+	ErrInvalidMaxClicks = errors.New("max clicks must be positive")
+	ErrEmptyPassword    = errors.New("password cannot be empty")
+	ErrInvalidPassword  = errors.New("invalid password")
+	ErrTooManyAttempts  = errors.New("too many failed attempts")
+	ErrEmptyBatch       = errors.New("batch cannot be empty")
+	ErrBatchTooLarge    = errors.New("batch too large")
+	ErrInvalidTag       = errors.New("invalid tag")
+	ErrTooManyTags      = errors.New("too many tags")
+	ErrInvalidCursor    = errors.New("invalid cursor")
+	ErrInvalidOrder     = errors.New("invalid order")
+	ErrEmptyQuery       = errors.New("search query cannot be empty")
+	ErrQueryTooLong     = errors.New("search query too long")
+
+	ErrInvalidRedirectType = errors.New("redirect type must be one of 301, 302, 307, 308")
+	ErrInvalidUTM          = errors.New("utm parameters must be at most 255 characters")
+
+	ErrInvalidTargetingRule  = errors.New("invalid targeting rule")
+	ErrTooManyTargetingRules = errors.New("too many targeting rules")
+	ErrInvalidVariant        = errors.New("invalid variant")
+	ErrTooManyVariants       = errors.New("too many variants")
+	ErrInvalidDeepLink       = errors.New("invalid deep link")
+
+	ErrInvalidDomain = errors.New("invalid domain")
+	ErrUnknownDomain = errors.New("domain is not registered")
+
+	ErrInvalidForwardPath = errors.New("invalid forwarded path")
+
+	ErrClickDropped = errors.New("click queue is full")
+
+	ErrInvalidPrivacyMode = errors.New("invalid IP privacy mode")
 )
 
 func IsNotFound(err error) bool {
@@ -23,3 +60,19 @@ func IsNotFound(err error) bool {
 func IsAlreadyExists(err error) bool {
 	return errors.Is(err, ErrShortCodeExists)
 }
+
+func IsExpired(err error) bool {
+	return errors.Is(err, ErrURLExpired)
+}
+
+func IsDisabled(err error) bool {
+	return errors.Is(err, ErrURLDisabled)
+}
+
+func IsDeleted(err error) bool {
+	return errors.Is(err, ErrURLDeleted)
+}
+
+func IsInvalidURL(err error) bool {
+	return errors.Is(err, ErrInvalidURL) || errors.Is(err, ErrEmptyURL)
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+)
+
+const maxForwardPathLength = 1024
+
+// ForwardPath returns link with the path that followed its short code
+// appended to every web destination. An empty path returns link unchanged;
+// links without ForwardPath do not match longer paths at all.
+func (s *shortenerService) ForwardPath(link *domain.URL, path string) (*domain.URL, error) {
+	if path == "" {
+		return link, nil
+	}
+	if !link.ForwardPath {
+		return nil, ErrURLNotFound
+	}
+	if !isSafeForwardPath(path) {
+		return nil, ErrInvalidForwardPath
+	}
+
+	forwarded := *link
+	forwarded.OriginalURL = joinPath(link.OriginalURL, path)
+
+	if len(link.Targeting) > 0 {
+		forwarded.Targeting = make([]domain.TargetingRule, len(link.Targeting))
+		for i, rule := range link.Targeting {
+			rule.URL = joinPath(rule.URL, path)
+			forwarded.Targeting[i] = rule
+		}
+	}
+
+	if len(link.Variants) > 0 {
+		forwarded.Variants = make([]domain.Variant, len(link.Variants))
+		for i, variant := range link.Variants {
+			variant.URL = joinPath(variant.URL, path)
+			forwarded.Variants[i] = variant
+		}
+	}
+
+	return &forwarded, nil
+}
+
+// isSafeForwardPath rejects paths that could climb out of the destination's
+// path or smuggle in another host.
+func isSafeForwardPath(path string) bool {
+	if len(path) > maxForwardPathLength || strings.HasPrefix(path, "/") {
+		return false
+	}
+
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	for _, r := range path {
+		if r == '\\' || r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
+
+// joinPath appends path to the path of destination, keeping the
+// destination's own query string and fragment.
+func joinPath(destination, path string) string {
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/") + "/" + path
+	parsed.RawPath = ""
+	return parsed.String()
+}
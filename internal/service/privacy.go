@@ -0,0 +1,123 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+)
+
+const (
+	defaultIPv4Prefix   = 24
+	defaultIPv6Prefix   = 48
+	defaultHashRotation = 24 * time.Hour
+)
+
+// IPAnonymizer anonymizes client IP addresses before clicks are stored,
+// according to the privacy mode
+type IPAnonymizer struct {
+	mode       domain.IPPrivacyMode
+	ipv4Prefix int
+	ipv6Prefix int
+	hashKey    []byte
+	rotation   time.Duration
+}
+
+// NewIPAnonymizer creates an anonymizer for mode. ipv4Prefix and ipv6Prefix
+// are the number of leading bits kept by IPPrivacyTruncate; hashKey and
+// rotation configure IPPrivacyHash, where the key used for an address
+// changes every rotation.
+func NewIPAnonymizer(mode domain.IPPrivacyMode, ipv4Prefix, ipv6Prefix int, hashKey string, rotation time.Duration) (*IPAnonymizer, error) {
+	if !mode.IsValid() {
+		return nil, ErrInvalidPrivacyMode
+	}
+	if ipv4Prefix < 0 || ipv4Prefix > 32 {
+		ipv4Prefix = defaultIPv4Prefix
+	}
+	if ipv6Prefix < 0 || ipv6Prefix > 128 {
+		ipv6Prefix = defaultIPv6Prefix
+	}
+	if rotation <= 0 {
+		rotation = defaultHashRotation
+	}
+
+	return &IPAnonymizer{
+		mode:       mode,
+		ipv4Prefix: ipv4Prefix,
+		ipv6Prefix: ipv6Prefix,
+		hashKey:    []byte(hashKey),
+		rotation:   rotation,
+	}, nil
+}
+
+// Mode returns the privacy mode
+func (a *IPAnonymizer) Mode() domain.IPPrivacyMode {
+	return a.mode
+}
+
+// Anonymize returns what is stored for a click from ip at time at: the
+// address, possibly truncated, or its hash. Unparsable addresses are
+// dropped.
+func (a *IPAnonymizer) Anonymize(ip string, at time.Time) (addr, hash string) {
+	parsed, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", ""
+	}
+	parsed = parsed.Unmap()
+
+	switch a.mode {
+	case domain.IPPrivacyHash:
+		return "", a.hash(parsed, at)
+	case domain.IPPrivacyTruncate:
+		return truncateIP(parsed, a.ipv4Prefix, a.ipv6Prefix), ""
+	default:
+		return parsed.String(), ""
+	}
+}
+
+// Redact hides the address of a click read back from the store. Whatever
+// the mode the clicks were stored with, the API never shows more than the
+// network prefix, and never a longer one than the default.
+func (a *IPAnonymizer) Redact(event *domain.ClickEvent) {
+	if event.IP == "" {
+		return
+	}
+
+	parsed, err := netip.ParseAddr(event.IP)
+	if err != nil {
+		event.IP = ""
+		return
+	}
+	event.IP = truncateIP(parsed.Unmap(), min(a.ipv4Prefix, defaultIPv4Prefix), min(a.ipv6Prefix, defaultIPv6Prefix))
+}
+
+// truncateIP zeroes all but the first ipv4Prefix or ipv6Prefix bits of addr
+func truncateIP(addr netip.Addr, ipv4Prefix, ipv6Prefix int) string {
+	bits := ipv6Prefix
+	if addr.Is4() {
+		bits = ipv4Prefix
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.Addr().String()
+}
+
+// hash is an HMAC of the address under a key derived from hashKey and the
+// rotation period at falls into
+func (a *IPAnonymizer) hash(addr netip.Addr, at time.Time) string {
+	period := at.UTC().UnixNano() / int64(a.rotation)
+
+	keyMAC := hmac.New(sha256.New, a.hashKey)
+	keyMAC.Write([]byte(strconv.FormatInt(period, 10)))
+
+	mac := hmac.New(sha256.New, keyMAC.Sum(nil))
+	mac.Write(addr.AsSlice())
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
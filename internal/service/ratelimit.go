@@ -0,0 +1,76 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// attemptLimiter counts attempts per key within a fixed window. An attempt
+// is counted as soon as it is allowed, so concurrent attempts cannot all slip
+// through before the first of them fails.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	attempts map[string]*attemptWindow
+}
+
+type attemptWindow struct {
+	count   int
+	startAt time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		max:      max,
+		window:   window,
+		attempts: make(map[string]*attemptWindow),
+	}
+}
+
+// Allow reports whether another attempt is permitted for key and, if so,
+// counts it. A successful attempt should be followed by Reset, and one that
+// turned out not to be an attempt at all by Release.
+func (l *attemptLimiter) Allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[key]
+	if !ok || now.Sub(a.startAt) >= l.window {
+		l.prune(now)
+		l.attempts[key] = &attemptWindow{count: 1, startAt: now}
+		return true
+	}
+	if a.count >= l.max {
+		return false
+	}
+	a.count++
+	return true
+}
+
+// Release takes back an attempt counted by Allow
+func (l *attemptLimiter) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if a, ok := l.attempts[key]; ok && a.count > 0 {
+		a.count--
+	}
+}
+
+// prune drops expired windows so the map doesn't grow without bound
+func (l *attemptLimiter) prune(now time.Time) {
+	for key, a := range l.attempts {
+		if now.Sub(a.startAt) >= l.window {
+			delete(l.attempts, key)
+		}
+	}
+}
+
+// Reset forgets all attempts for key
+func (l *attemptLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, key)
+}
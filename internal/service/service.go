@@ -8,16 +8,41 @@ import (
 
 type ShortenerService interface {
 	CreateShortURL(ctx context.Context, req *domain.CreateURLRequest) (*domain.CreateURLResponse, error)
-	GetOriginalURL(ctx context.Context, shortCode string) (*domain.URL, error)
-	TrackClick(ctx context.Context, shortCode, userAgent, ip, referer string) error
-	GetAllURLs(ctx context.Context, limit int) ([]*domain.URL, error)
+	CreateShortURLs(ctx context.Context, reqs []*domain.CreateURLRequest) ([]domain.BatchCreateResult, error)
+	GetOriginalURL(ctx context.Context, host, shortCode string) (*domain.URL, error)
+	UnlockURL(ctx context.Context, host, shortCode, password string) (*domain.URL, error)
+	ForwardPath(url *domain.URL, path string) (*domain.URL, error)
+	UpdateDestination(ctx context.Context, host, shortCode, originalURL string) (*domain.URL, error)
+	DisableURL(ctx context.Context, host, shortCode string) error
+	EnableURL(ctx context.Context, host, shortCode string) error
+	DeleteURL(ctx context.Context, host, shortCode string) error
+	TrackClick(ctx context.Context, url *domain.URL, userAgent, ip, referer, variant string, isBot bool) error
+	ClickIngestStats() domain.IngestStats
+	AddTags(ctx context.Context, host, shortCode string, tags []string) (*domain.URL, error)
+	RemoveTag(ctx context.Context, host, shortCode, tag string) (*domain.URL, error)
+	GetTagCounts(ctx context.Context) ([]domain.TagCount, error)
+	SetTargeting(ctx context.Context, host, shortCode string, rules []domain.TargetingRule) (*domain.URL, error)
+	SetVariants(ctx context.Context, host, shortCode string, variants []domain.Variant, sticky bool) (*domain.URL, error)
+	SetInterstitial(ctx context.Context, host, shortCode string, enabled bool) (*domain.URL, error)
+	SetDeepLinks(ctx context.Context, host, shortCode string, links domain.DeepLinks) (*domain.URL, error)
+	GetAllURLs(ctx context.Context, limit int, tags []string) ([]*domain.URL, error)
+	ListURLs(ctx context.Context, limit int, tags []string, order domain.URLOrder, cursor string) (*domain.URLPage, error)
+	SearchURLs(ctx context.Context, query string, limit int) ([]*domain.URL, error)
 	GetPopularURLs(ctx context.Context, limit int) ([]*domain.URL, error)
+	ListDomains(ctx context.Context) ([]domain.ShortDomain, error)
+	AddDomain(ctx context.Context, host string) (*domain.ShortDomain, error)
+	RemoveDomain(ctx context.Context, host string) error
 }
 
 type AnalyticsService interface {
-	GetAnalytics(ctx context.Context, shortCode string) (*domain.AnalyticsResponse, error)
-	GetDailyStats(ctx context.Context, shortCode string, days int) (map[string]int64, error)
-	GetMonthlyStats(ctx context.Context, shortCode string, months int) (map[string]int64, error)
-	GetDeviceStats(ctx context.Context, shortCode string) (map[string]int64, error)
-	GetRecentClicks(ctx context.Context, shortCode string, limit int) ([]domain.ClickEvent, error)
+	GetAnalytics(ctx context.Context, host, shortCode string) (*domain.AnalyticsResponse, error)
+	GetDailyStats(ctx context.Context, host, shortCode string, days int) (map[string]int64, error)
+	GetMonthlyStats(ctx context.Context, host, shortCode string, months int) (map[string]int64, error)
+	GetDeviceStats(ctx context.Context, host, shortCode string) (map[string]int64, error)
+	GetBrowserStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error)
+	GetOSStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error)
+	GetVariantStats(ctx context.Context, host, shortCode string) (map[string]int64, error)
+	GetBotStats(ctx context.Context, host, shortCode string) (*domain.BotStats, error)
+	GetUniqueVisitors(ctx context.Context, host, shortCode string, days, months int) (*domain.UniqueVisitors, error)
+	GetRecentClicks(ctx context.Context, host, shortCode string, limit int) ([]domain.ClickEvent, error)
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"strings"
 
 	"time"
 
@@ -16,34 +17,138 @@ import (
 	"github.com/MyNameIsWhaaat/shortener/internal/store"
 )
 
+const (
+	maxPasswordAttempts   = 5
+	passwordAttemptWindow = 15 * time.Minute
+
+	maxBatchSize    = 1000
+	maxCodeAttempts = 3
+
+	maxSearchQueryLength = 200
+	maxUTMLength         = 255
+)
+
 type shortenerService struct {
-	urlStore       store.URLStore
-	baseURL        string
-	codeLen        int
-	analyticsStore store.AnalyticsStore
-	cache          cache.Cache
+	urlStore        store.URLStore
+	baseURL         string
+	codeLen         int
+	analyticsStore  store.AnalyticsStore
+	cache           cache.Cache
+	passwordLimiter *attemptLimiter
+	dedupe          bool
+	countBots       bool
+
+	// baseHost is the host of baseURL, the default domain
+	baseHost string
+	domains  *domainRegistry
+
+	// clicks batches click writes; without it clicks are written one by one
+	clicks *ClickIngester
+	// visitors fingerprints counted clicks for unique visitor counts
+	visitors *VisitorCounter
+	// anonymizer strips client addresses before clicks are stored
+	anonymizer *IPAnonymizer
 }
 
-func NewShortenerService(urlStore store.URLStore, baseURL string, codeLen int, analyticsStore store.AnalyticsStore, cacheClient cache.Cache) ShortenerService {
+func NewShortenerService(urlStore store.URLStore, baseURL string, codeLen int, analyticsStore store.AnalyticsStore, cacheClient cache.Cache, dedupe, countBots bool, clicks *ClickIngester, visitors *VisitorCounter, anonymizer *IPAnonymizer) ShortenerService {
+	var baseHost string
+	if base, err := url.Parse(baseURL); err == nil {
+		baseHost = domain.NormalizeHost(base.Host)
+	}
+
 	return &shortenerService{
-		urlStore:       urlStore,
-		baseURL:        baseURL,
-		codeLen:        codeLen,
-		analyticsStore: analyticsStore,
-		cache:          cacheClient,
+		urlStore:        urlStore,
+		baseURL:         baseURL,
+		codeLen:         codeLen,
+		analyticsStore:  analyticsStore,
+		cache:           cacheClient,
+		passwordLimiter: newAttemptLimiter(maxPasswordAttempts, passwordAttemptWindow),
+		dedupe:          dedupe,
+		countBots:       countBots,
+		baseHost:        baseHost,
+		domains:         newDomainRegistry(),
+		clicks:          clicks,
+		visitors:        visitors,
+		anonymizer:      anonymizer,
 	}
 }
 
-func (s *shortenerService) GetAllURLs(ctx context.Context, limit int) ([]*domain.URL, error) {
+func (s *shortenerService) GetAllURLs(ctx context.Context, limit int, tags []string) ([]*domain.URL, error) {
+	page, err := s.ListURLs(ctx, limit, tags, domain.URLOrderCreated, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return page.URLs, nil
+}
+
+// ListURLs returns one page of active links. The cursor is the NextCursor of
+// the previous page, or empty for the first one.
+func (s *shortenerService) ListURLs(ctx context.Context, limit int, tags []string, order domain.URLOrder, cursor string) (*domain.URLPage, error) {
 	if limit <= 0 || limit > 100 {
 		limit = 50
 	}
 
-	urls, err := s.urlStore.GetAllURLs(ctx, limit)
+	if order == "" {
+		order = domain.URLOrderCreated
+	}
+	if order != domain.URLOrderCreated && order != domain.URLOrderClicks {
+		return nil, ErrInvalidOrder
+	}
+
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	q := domain.ListURLsQuery{
+		// One extra row tells whether there is a next page
+		Limit: limit + 1,
+		Tags:  tags,
+		Order: order,
+	}
+	if cursor != "" {
+		if q.After, err = decodeCursor(cursor, order); err != nil {
+			return nil, err
+		}
+	}
+
+	urls, err := s.urlStore.GetAllURLs(ctx, q)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all urls: %w", err)
 	}
 
+	if urls == nil {
+		urls = []*domain.URL{}
+	}
+
+	page := &domain.URLPage{URLs: urls}
+	if len(urls) > limit {
+		page.URLs = urls[:limit]
+		page.NextCursor = encodeCursor(order, page.URLs[limit-1])
+	}
+
+	return page, nil
+}
+
+func (s *shortenerService) SearchURLs(ctx context.Context, query string, limit int) ([]*domain.URL, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, ErrEmptyQuery
+	}
+	if len(query) > maxSearchQueryLength {
+		return nil, ErrQueryTooLong
+	}
+
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	urls, err := s.urlStore.SearchURLs(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search urls: %w", err)
+	}
+
 	return urls, nil
 }
 
@@ -65,10 +170,248 @@ func (s *shortenerService) GetPopularURLs(ctx context.Context, limit int) ([]*do
 }
 
 func (s *shortenerService) CreateShortURL(ctx context.Context, req *domain.CreateURLRequest) (*domain.CreateURLResponse, error) {
+	host, err := s.createHost(ctx, req.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := s.newURL(req)
+	if err != nil {
+		return nil, err
+	}
+	url.Domain = host
+
+	if s.shouldDedupe(req) {
+		existing, err := s.urlStore.FindByOriginalURL(ctx, host, req.URL)
+		if err == nil {
+			resp := s.newCreateResponse(existing)
+			resp.Existing = true
+			return resp, nil
+		}
+		if !IsNotFound(err) {
+			return nil, fmt.Errorf("failed to look up existing url: %w", err)
+		}
+	}
+
+	exists, err := s.urlStore.CheckShortCodeExists(ctx, host, url.ShortCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check code existence: %w", err)
+	}
+	if exists {
+		return nil, ErrShortCodeExists
+	}
+
+	if err := s.urlStore.CreateURL(ctx, url); err != nil {
+		return nil, fmt.Errorf("failed to create url in store: %w", err)
+	}
+
+	// Cache the newly created URL
+	if s.cache != nil && isCacheable(url) {
+		if err := s.cache.Set(ctx, cacheKey(host, url.ShortCode), url); err != nil {
+			logger.Error("Failed to cache newly created URL", "error", err)
+		}
+	}
+
+	return s.newCreateResponse(url), nil
+}
+
+// CreateShortURLs creates links for a batch of requests. Results are returned
+// in request order; an item that fails validation or collides with an
+// existing code carries its own error without failing the whole batch.
+// Short codes must be distinct within a batch on each domain. Items that
+// may be deduplicated reuse an existing link to their destination, looked
+// up for the whole batch at once, or the link created for an earlier item
+// of the batch.
+func (s *shortenerService) CreateShortURLs(ctx context.Context, reqs []*domain.CreateURLRequest) ([]domain.BatchCreateResult, error) {
+	if len(reqs) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(reqs) > maxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]domain.BatchCreateResult, len(reqs))
+	urls := make([]*domain.URL, len(reqs))
+	seen := make(map[string]bool, len(reqs))
+	var (
+		dedupe []int
+		hosts  []string
+		dests  []string
+	)
+
+	for i, req := range reqs {
+		host, err := s.createHost(ctx, req.Domain)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		url, err := s.newURL(req)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		url.Domain = host
+		urls[i] = url
+		if s.shouldDedupe(req) {
+			dedupe = append(dedupe, i)
+			hosts = append(hosts, host)
+			dests = append(dests, req.URL)
+		}
+	}
+
+	// Items whose link already exists are done; repeated destinations wait
+	// for the link of their first item
+	sameAs := make(map[int]int)
+	if len(dedupe) > 0 {
+		existing, err := s.urlStore.FindByOriginalURLs(ctx, hosts, dests)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up existing urls: %w", err)
+		}
+
+		first := make(map[string]int, len(dedupe))
+		for j, i := range dedupe {
+			if existing[j] != nil {
+				results[i].Response = s.newCreateResponse(existing[j])
+				results[i].Response.Existing = true
+				urls[i] = nil
+				continue
+			}
+			key := cacheKey(hosts[j], domain.URLFingerprint(dests[j]))
+			if k, ok := first[key]; ok {
+				sameAs[i] = k
+				urls[i] = nil
+				continue
+			}
+			first[key] = i
+		}
+	}
+
+	pending := make([]int, 0, len(reqs))
+	for i, url := range urls {
+		if url == nil {
+			continue
+		}
+		key := cacheKey(url.Domain, url.ShortCode)
+		if seen[key] {
+			results[i].Err = ErrShortCodeExists
+			continue
+		}
+		seen[key] = true
+		pending = append(pending, i)
+	}
+
+	for attempt := 1; len(pending) > 0; attempt++ {
+		batch := make([]*domain.URL, len(pending))
+		for j, i := range pending {
+			batch[j] = urls[i]
+		}
+
+		errs, err := s.urlStore.CreateURLs(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create urls in store: %w", err)
+		}
+
+		var retry []int
+		for j, i := range pending {
+			switch {
+			case errs[j] == nil:
+				results[i].Response = s.newCreateResponse(urls[i])
+			case errs[j] == ErrShortCodeExists && reqs[i].CustomAlias == nil && attempt < maxCodeAttempts:
+				// Generated code collided, try again with a fresh one
+				code, err := s.generateUniqueCode(seen, urls[i].Domain)
+				if err != nil {
+					results[i].Err = fmt.Errorf("failed to generate short code: %w", err)
+					continue
+				}
+				urls[i].ShortCode = code
+				retry = append(retry, i)
+			default:
+				results[i].Err = errs[j]
+			}
+		}
+		pending = retry
+	}
+
+	for i, k := range sameAs {
+		if results[k].Err != nil {
+			results[i].Err = results[k].Err
+			continue
+		}
+		resp := *results[k].Response
+		resp.Existing = true
+		results[i].Response = &resp
+	}
+
+	return results, nil
+}
+
+// shouldDedupe reports whether an existing link to the same destination may
+// be returned instead of creating a new one. Only plain links qualify: a
+// custom alias, lifetime limits, a password, tags or redirect options always
+// create a new link.
+func (s *shortenerService) shouldDedupe(req *domain.CreateURLRequest) bool {
+	if req.CustomAlias != nil || req.ExpiresAt != nil || req.MaxClicks != nil || req.Password != nil ||
+		len(req.Tags) > 0 || req.RedirectType != nil || req.ForwardQuery || req.ForwardPath || !req.UTM.IsEmpty() || len(req.Targeting) > 0 ||
+		len(req.Variants) > 0 || req.Interstitial || !req.DeepLinks.IsEmpty() {
+		return false
+	}
+	if req.Dedupe != nil {
+		return *req.Dedupe
+	}
+	return s.dedupe
+}
+
+// newURL validates a create request and builds the URL to be stored
+func (s *shortenerService) newURL(req *domain.CreateURLRequest) (*domain.URL, error) {
 	if err := s.validateURL(req.URL); err != nil {
 		return nil, fmt.Errorf("invalid URL: %w", err)
 	}
 
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidExpiresAt
+	}
+
+	if req.MaxClicks != nil && *req.MaxClicks <= 0 {
+		return nil, ErrInvalidMaxClicks
+	}
+
+	if req.Password != nil && *req.Password == "" {
+		return nil, ErrEmptyPassword
+	}
+
+	redirectType := domain.DefaultRedirectType
+	if req.RedirectType != nil {
+		if !domain.IsValidRedirectType(*req.RedirectType) {
+			return nil, ErrInvalidRedirectType
+		}
+		redirectType = *req.RedirectType
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	utm, err := validateUTM(req.UTM)
+	if err != nil {
+		return nil, err
+	}
+
+	targeting, err := s.validateTargeting(req.Targeting)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := s.validateVariants(req.Variants)
+	if err != nil {
+		return nil, err
+	}
+
+	deepLinks, err := s.validateDeepLinks(req.DeepLinks)
+	if err != nil {
+		return nil, err
+	}
+
 	shortCode := req.CustomAlias
 	if shortCode == nil {
 		code, err := s.generateShortCode()
@@ -82,61 +425,95 @@ func (s *shortenerService) CreateShortURL(ctx context.Context, req *domain.Creat
 		}
 	}
 
-	exists, err := s.urlStore.CheckShortCodeExists(ctx, *shortCode)
-	if err != nil {
-		return nil, fmt.Errorf("failed to check code existence: %w", err)
-	}
-	if exists {
-		return nil, ErrShortCodeExists
-	}
-
 	url := &domain.URL{
-		ShortCode:   *shortCode,
-		OriginalURL: req.URL,
-		CustomAlias: req.CustomAlias,
-		CreatedAt:   time.Now(),
-		Clicks:      0,
-	}
-
-	if err := s.urlStore.CreateURL(ctx, url); err != nil {
-		return nil, fmt.Errorf("failed to create url in store: %w", err)
+		ShortCode:      *shortCode,
+		OriginalURL:    req.URL,
+		CustomAlias:    req.CustomAlias,
+		CreatedAt:      time.Now(),
+		Clicks:         0,
+		ExpiresAt:      req.ExpiresAt,
+		MaxClicks:      req.MaxClicks,
+		Tags:           tags,
+		RedirectType:   redirectType,
+		ForwardQuery:   req.ForwardQuery,
+		ForwardPath:    req.ForwardPath,
+		UTM:            utm,
+		Targeting:      targeting,
+		Variants:       variants,
+		StickyVariants: req.StickyVariants && len(variants) > 0,
+		Interstitial:   req.Interstitial,
+		DeepLinks:      deepLinks,
 	}
 
-	// Cache the newly created URL
-	if s.cache != nil {
-		if err := s.cache.Set(ctx, url.ShortCode, url); err != nil {
-			logger.Error("Failed to cache newly created URL", "error", err)
+	if req.Password != nil {
+		hash, err := hashPassword(*req.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
 		}
+		url.PasswordHash = &hash
+		url.Protected = true
 	}
 
+	return url, nil
+}
+
+func (s *shortenerService) newCreateResponse(url *domain.URL) *domain.CreateURLResponse {
 	return &domain.CreateURLResponse{
-		ShortCode:   url.ShortCode,
-		ShortURL:    fmt.Sprintf("%s/s/%s", s.baseURL, url.ShortCode),
-		OriginalURL: url.OriginalURL,
-	}, nil
+		Domain:         url.Domain,
+		ShortCode:      url.ShortCode,
+		ShortURL:       s.shortURL(url),
+		OriginalURL:    url.OriginalURL,
+		ExpiresAt:      url.ExpiresAt,
+		MaxClicks:      url.MaxClicks,
+		Protected:      url.Protected,
+		Tags:           url.Tags,
+		RedirectType:   url.RedirectStatus(),
+		ForwardQuery:   url.ForwardQuery,
+		ForwardPath:    url.ForwardPath,
+		UTM:            url.UTM,
+		Targeting:      url.Targeting,
+		Variants:       url.Variants,
+		StickyVariants: url.StickyVariants,
+		Interstitial:   url.Interstitial,
+		DeepLinks:      url.DeepLinks,
+	}
 }
 
-func (s *shortenerService) GetOriginalURL(ctx context.Context, shortCode string) (*domain.URL, error) {
+// GetOriginalURL returns the link a request to host is addressed to
+func (s *shortenerService) GetOriginalURL(ctx context.Context, host, shortCode string) (*domain.URL, error) {
 	if shortCode == "" {
 		return nil, ErrInvalidShortCode
 	}
 
+	host, err := s.resolveHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	key := cacheKey(host, shortCode)
+
 	// Try cache first
 	if s.cache != nil {
-		if cachedURL, err := s.cache.Get(ctx, shortCode); err == nil && cachedURL != nil {
+		if cachedURL, err := s.cache.Get(ctx, key); err == nil && cachedURL != nil {
+			if err := checkAvailable(cachedURL); err != nil {
+				return nil, err
+			}
 			return cachedURL, nil
 		}
 	}
 
 	// Fallback to database
-	url, err := s.urlStore.GetURLByShortCode(ctx, shortCode)
+	url, err := s.urlStore.GetURLByShortCode(ctx, host, shortCode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get url from store: %w", err)
 	}
 
+	if err := checkAvailable(url); err != nil {
+		return nil, err
+	}
+
 	// Cache the result
-	if s.cache != nil {
-		if err := s.cache.Set(ctx, shortCode, url); err != nil {
+	if s.cache != nil && isCacheable(url) {
+		if err := s.cache.Set(ctx, key, url); err != nil {
 			logger.Error("Failed to cache URL", "error", err)
 		}
 	}
@@ -144,27 +521,250 @@ func (s *shortenerService) GetOriginalURL(ctx context.Context, shortCode string)
 	return url, nil
 }
 
-func (s *shortenerService) TrackClick(ctx context.Context, shortCode, userAgent, ip, referer string) error {
-	logger.Info("TrackClick", "short_code", shortCode)
+// UnlockURL checks the password of a protected link. Failed attempts are
+// limited per link.
+func (s *shortenerService) UnlockURL(ctx context.Context, host, shortCode, password string) (*domain.URL, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+
+	host, err := s.resolveHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	key := cacheKey(host, shortCode)
 
-	if err := s.urlStore.IncrementClicks(ctx, shortCode); err != nil {
-		return err
+	// The attempt is counted before the password is checked and kept if
+	// it is wrong
+	if !s.passwordLimiter.Allow(key, time.Now()) {
+		return nil, ErrTooManyAttempts
+	}
+
+	// The password hash is never cached, so always go to the store
+	url, err := s.urlStore.GetURLByShortCode(ctx, host, shortCode)
+	if err != nil {
+		s.passwordLimiter.Release(key)
+		return nil, fmt.Errorf("failed to get url from store: %w", err)
+	}
+
+	if err := checkAvailable(url); err != nil {
+		s.passwordLimiter.Release(key)
+		return nil, err
+	}
+
+	if url.PasswordHash == nil {
+		s.passwordLimiter.Release(key)
+		return url, nil
+	}
+
+	if !checkPassword(*url.PasswordHash, password) {
+		return nil, ErrInvalidPassword
+	}
+
+	s.passwordLimiter.Reset(key)
+	return url, nil
+}
+
+func (s *shortenerService) UpdateDestination(ctx context.Context, host, shortCode, originalURL string) (*domain.URL, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+	host = s.linkHost(host)
+
+	if err := s.validateURL(originalURL); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+
+	url, err := s.urlStore.GetURLByShortCode(ctx, host, shortCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get url from store: %w", err)
+	}
+	if url.IsDeleted() {
+		return nil, ErrURLNotFound
+	}
+
+	url.OriginalURL = originalURL
+	if err := s.urlStore.UpdateURL(ctx, url); err != nil {
+		return nil, fmt.Errorf("failed to update url in store: %w", err)
+	}
+
+	// Drop the stale cache entry so redirects pick up the new destination
+	s.invalidateCache(ctx, host, shortCode)
+
+	return url, nil
+}
+
+// SetInterstitial switches the preview page shown before redirecting to an
+// external destination on or off.
+func (s *shortenerService) SetInterstitial(ctx context.Context, host, shortCode string, enabled bool) (*domain.URL, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+	host = s.linkHost(host)
+
+	url, err := s.getActiveURL(ctx, host, shortCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.urlStore.SetInterstitial(ctx, host, shortCode, enabled); err != nil {
+		return nil, fmt.Errorf("failed to set interstitial in store: %w", err)
+	}
+
+	url.Interstitial = enabled
+	s.invalidateCache(ctx, host, shortCode)
+	return url, nil
+}
+
+func (s *shortenerService) DisableURL(ctx context.Context, host, shortCode string) error {
+	return s.setDisabled(ctx, host, shortCode, true)
+}
+
+func (s *shortenerService) EnableURL(ctx context.Context, host, shortCode string) error {
+	return s.setDisabled(ctx, host, shortCode, false)
+}
+
+func (s *shortenerService) setDisabled(ctx context.Context, host, shortCode string, disabled bool) error {
+	if shortCode == "" {
+		return ErrInvalidShortCode
+	}
+	host = s.linkHost(host)
+
+	if err := s.urlStore.SetDisabled(ctx, host, shortCode, disabled); err != nil {
+		return fmt.Errorf("failed to set disabled state in store: %w", err)
+	}
+
+	s.invalidateCache(ctx, host, shortCode)
+	return nil
+}
+
+func (s *shortenerService) DeleteURL(ctx context.Context, host, shortCode string) error {
+	if shortCode == "" {
+		return ErrInvalidShortCode
+	}
+	host = s.linkHost(host)
+
+	if err := s.urlStore.DeleteURL(ctx, host, shortCode); err != nil {
+		return fmt.Errorf("failed to delete url in store: %w", err)
+	}
+
+	// Also drops the code from the popularity set
+	s.invalidateCache(ctx, host, shortCode)
+	return nil
+}
+
+func (s *shortenerService) invalidateCache(ctx context.Context, host, shortCode string) {
+	if s.cache == nil {
+		return
+	}
+	if err := s.cache.Invalidate(ctx, cacheKey(host, shortCode)); err != nil {
+		logger.Error("Failed to invalidate cached URL", "short_code", shortCode, "error", err)
+	}
+}
+
+// TrackClick records a visit of url. variant is the name of the split-test
+// variant the visitor was sent to, if any. Bot visits are stored for
+// analytics but only count towards the link's clicks (and its click budget)
+// when the service is configured to count bots. With a click ingester the
+// visit is only queued and ErrClickDropped is returned when the queue is
+// full.
+//
+// Queued clicks reach the counter late, so a click is taken from the budget
+// of a link with max_clicks right away instead; ErrURLExpired is returned,
+// and nothing recorded, once the budget is spent.
+func (s *shortenerService) TrackClick(ctx context.Context, url *domain.URL, userAgent, ip, referer, variant string, isBot bool) error {
+	host, shortCode := url.Domain, url.ShortCode
+	logger.Info("TrackClick", "short_code", shortCode, "bot", isBot)
+
+	counted := !isBot || s.countBots
+	budgeted := counted && url.MaxClicks != nil
+	if budgeted {
+		reserved, err := s.urlStore.ReserveClick(ctx, host, shortCode)
+		if err != nil {
+			return err
+		}
+		if !reserved {
+			return ErrURLExpired
+		}
 	}
 
 	event := &domain.ClickEvent{
+		Domain:    host,
 		ShortCode: shortCode,
 		UserAgent: userAgent,
 		IP:        ip,
 		Referer:   referer,
+		Variant:   variant,
+		IsBot:     isBot,
 		CreatedAt: time.Now(),
 	}
+	client := domain.ParseUserAgent(userAgent)
+	event.Browser, event.BrowserVersion = client.Browser, client.BrowserVersion
+	event.OS, event.OSVersion = client.OS, client.OSVersion
+	event.Device = client.Device
+
+	if counted && s.visitors != nil {
+		event.Visitor = s.visitors.Fingerprint(ip, userAgent)
+	}
+	if s.anonymizer != nil {
+		event.IP, event.IPHash = s.anonymizer.Anonymize(ip, event.CreatedAt)
+	}
+
+	if s.clicks != nil {
+		if !s.clicks.Enqueue(event, counted && !budgeted) {
+			return ErrClickDropped
+		}
+		return nil
+	}
+
+	if counted && !budgeted {
+		if err := s.urlStore.IncrementClicks(ctx, host, shortCode); err != nil {
+			return err
+		}
+	}
 
 	if s.analyticsStore == nil {
 		logger.Error("analyticsStore is nil")
 		return nil
 	}
 
-	return s.analyticsStore.SaveClickEvent(ctx, event)
+	if err := s.analyticsStore.SaveClickEvent(ctx, event); err != nil {
+		return err
+	}
+
+	if event.Visitor != "" {
+		return s.visitors.Add(ctx, []*domain.ClickEvent{event})
+	}
+	return nil
+}
+
+// ClickIngestStats returns the state of the click queue, or zero stats when
+// clicks are written synchronously.
+func (s *shortenerService) ClickIngestStats() domain.IngestStats {
+	if s.clicks == nil {
+		return domain.IngestStats{}
+	}
+	return s.clicks.Stats()
+}
+
+// checkAvailable returns an error if the link can no longer be followed
+func checkAvailable(url *domain.URL) error {
+	switch {
+	case url.IsDeleted():
+		return ErrURLDeleted
+	case url.IsDisabled():
+		return ErrURLDisabled
+	case url.IsExpired(time.Now()):
+		return ErrURLExpired
+	}
+	return nil
+}
+
+// isCacheable reports whether a URL can be served from cache. Links with a
+// click budget are always read from the store, since the cached click
+// counter is never updated.
+func isCacheable(url *domain.URL) bool {
+	return url.MaxClicks == nil
 }
 
 func (s *shortenerService) generateShortCode() (string, error) {
@@ -176,6 +776,21 @@ func (s *shortenerService) generateShortCode() (string, error) {
 	return code[:s.codeLen], nil
 }
 
+// generateUniqueCode generates a short code not already taken on host in
+// seen, which is keyed by cacheKey
+func (s *shortenerService) generateUniqueCode(seen map[string]bool, host string) (string, error) {
+	for {
+		code, err := s.generateShortCode()
+		if err != nil {
+			return "", err
+		}
+		if key := cacheKey(host, code); !seen[key] {
+			seen[key] = true
+			return code, nil
+		}
+	}
+}
+
 func (s *shortenerService) validateURL(rawURL string) error {
 	if rawURL == "" {
 		return ErrEmptyURL
@@ -197,6 +812,22 @@ func (s *shortenerService) validateURL(rawURL string) error {
 	return nil
 }
 
+// validateUTM checks the default UTM parameters of a link. An empty set is
+// returned as nil so that it is not stored.
+func validateUTM(utm *domain.UTMParams) (*domain.UTMParams, error) {
+	if utm.IsEmpty() {
+		return nil, nil
+	}
+
+	for _, value := range []string{utm.Source, utm.Medium, utm.Campaign} {
+		if len(value) > maxUTMLength {
+			return nil, ErrInvalidUTM
+		}
+	}
+
+	return utm, nil
+}
+
 func (s *shortenerService) validateShortCode(code string) error {
 	if code == "" {
 		return ErrInvalidShortCode
@@ -2,59 +2,341 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/MyNameIsWhaaat/shortener/internal/cache"
 	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/spool"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
-// MockURLStore for testing
+// MockURLStore for testing. Links are keyed by cacheKey.
 type MockURLStore struct {
-	urls map[string]*domain.URL
+	urls    map[string]*domain.URL
+	domains map[string]bool
 }
 
 func NewMockURLStore() *MockURLStore {
 	return &MockURLStore{
-		urls: make(map[string]*domain.URL),
+		urls:    make(map[string]*domain.URL),
+		domains: make(map[string]bool),
 	}
 }
 
 func (m *MockURLStore) CreateURL(ctx context.Context, url *domain.URL) error {
-	m.urls[url.ShortCode] = url
+	m.urls[cacheKey(url.Domain, url.ShortCode)] = url
 	return nil
 }
 
-func (m *MockURLStore) GetURLByShortCode(ctx context.Context, shortCode string) (*domain.URL, error) {
-	if url, ok := m.urls[shortCode]; ok {
+func (m *MockURLStore) CreateURLs(ctx context.Context, urls []*domain.URL) ([]error, error) {
+	errs := make([]error, len(urls))
+	for i, url := range urls {
+		if _, exists := m.urls[cacheKey(url.Domain, url.ShortCode)]; exists {
+			errs[i] = domain.ErrShortCodeExists
+			continue
+		}
+		m.urls[cacheKey(url.Domain, url.ShortCode)] = url
+	}
+	return errs, nil
+}
+
+func (m *MockURLStore) UpdateURL(ctx context.Context, url *domain.URL) error {
+	existing, ok := m.urls[cacheKey(url.Domain, url.ShortCode)]
+	if !ok {
+		return ErrURLNotFound
+	}
+	existing.OriginalURL = url.OriginalURL
+	return nil
+}
+
+func (m *MockURLStore) SetDisabled(ctx context.Context, host, shortCode string, disabled bool) error {
+	url, ok := m.urls[cacheKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return ErrURLNotFound
+	}
+	if disabled {
+		now := time.Now()
+		url.DisabledAt = &now
+	} else {
+		url.DisabledAt = nil
+	}
+	return nil
+}
+
+func (m *MockURLStore) DeleteURL(ctx context.Context, host, shortCode string) error {
+	url, ok := m.urls[cacheKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return ErrURLNotFound
+	}
+	now := time.Now()
+	url.DeletedAt = &now
+	return nil
+}
+
+func (m *MockURLStore) GetURLByShortCode(ctx context.Context, host, shortCode string) (*domain.URL, error) {
+	if url, ok := m.urls[cacheKey(host, shortCode)]; ok {
 		return url, nil
 	}
 	return nil, ErrURLNotFound
 }
 
-func (m *MockURLStore) IncrementClicks(ctx context.Context, shortCode string) error {
-	if url, ok := m.urls[shortCode]; ok {
+func (m *MockURLStore) FindByOriginalURL(ctx context.Context, host, originalURL string) (*domain.URL, error) {
+	fingerprint := domain.URLFingerprint(originalURL)
+	var found *domain.URL
+	for _, url := range m.urls {
+		if url.Domain != host || url.DeletedAt != nil || url.DisabledAt != nil || url.ExpiresAt != nil || url.MaxClicks != nil || url.PasswordHash != nil {
+			continue
+		}
+		if url.RedirectStatus() != domain.DefaultRedirectType || url.ForwardQuery || url.ForwardPath || url.UTM != nil || len(url.Targeting) > 0 || len(url.Variants) > 0 || url.Interstitial || !url.DeepLinks.IsEmpty() {
+			continue
+		}
+		if domain.URLFingerprint(url.OriginalURL) != fingerprint {
+			continue
+		}
+		if found == nil || url.CreatedAt.Before(found.CreatedAt) {
+			found = url
+		}
+	}
+	if found == nil {
+		return nil, ErrURLNotFound
+	}
+	return found, nil
+}
+
+func (m *MockURLStore) FindByOriginalURLs(ctx context.Context, hosts, originalURLs []string) ([]*domain.URL, error) {
+	urls := make([]*domain.URL, len(originalURLs))
+	for i := range originalURLs {
+		if url, err := m.FindByOriginalURL(ctx, hosts[i], originalURLs[i]); err == nil {
+			urls[i] = url
+		}
+	}
+	return urls, nil
+}
+
+func (m *MockURLStore) IncrementClicks(ctx context.Context, host, shortCode string) error {
+	if url, ok := m.urls[cacheKey(host, shortCode)]; ok {
 		url.Clicks++
 		return nil
 	}
 	return ErrURLNotFound
 }
 
-func (m *MockURLStore) CheckShortCodeExists(ctx context.Context, shortCode string) (bool, error) {
-	_, exists := m.urls[shortCode]
+func (m *MockURLStore) ReserveClick(ctx context.Context, host, shortCode string) (bool, error) {
+	url, ok := m.urls[cacheKey(host, shortCode)]
+	if !ok || (url.MaxClicks != nil && url.Clicks >= *url.MaxClicks) {
+		return false, nil
+	}
+	url.Clicks++
+	return true, nil
+}
+
+func (m *MockURLStore) CheckShortCodeExists(ctx context.Context, host, shortCode string) (bool, error) {
+	_, exists := m.urls[cacheKey(host, shortCode)]
 	return exists, nil
 }
 
-func (m *MockURLStore) GetAllURLs(ctx context.Context, limit int) ([]*domain.URL, error) {
+func (m *MockURLStore) GetAllURLs(ctx context.Context, q domain.ListURLsQuery) ([]*domain.URL, error) {
+	less := func(a, b *domain.URL) bool {
+		if q.Order == domain.URLOrderClicks && a.Clicks != b.Clicks {
+			return a.Clicks < b.Clicks
+		}
+		if q.Order != domain.URLOrderClicks && !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	}
+
+	var after *domain.URL
+	if q.After != nil {
+		after = &domain.URL{CreatedAt: q.After.CreatedAt, Clicks: q.After.Clicks, ID: q.After.ID}
+	}
+
 	urls := make([]*domain.URL, 0)
 	for _, url := range m.urls {
+		if url.DeletedAt != nil || !hasTags(url, q.Tags) {
+			continue
+		}
+		if after != nil && !less(url, after) {
+			continue
+		}
 		urls = append(urls, url)
 	}
+	sort.Slice(urls, func(i, j int) bool { return less(urls[j], urls[i]) })
+	if q.Limit > 0 && len(urls) > q.Limit {
+		urls = urls[:q.Limit]
+	}
+	return urls, nil
+}
+
+func (m *MockURLStore) SearchURLs(ctx context.Context, query string, limit int) ([]*domain.URL, error) {
+	query = strings.ToLower(query)
+	urls := make([]*domain.URL, 0)
+	for _, url := range m.urls {
+		alias := ""
+		if url.CustomAlias != nil {
+			alias = strings.ToLower(*url.CustomAlias)
+		}
+		if url.DeletedAt != nil {
+			continue
+		}
+		if strings.HasPrefix(strings.ToLower(url.ShortCode), query) || strings.HasPrefix(alias, query) ||
+			strings.Contains(strings.ToLower(url.OriginalURL), query) {
+			urls = append(urls, url)
+		}
+		if limit > 0 && len(urls) >= limit {
+			break
+		}
+	}
 	return urls, nil
 }
 
+func (m *MockURLStore) SetTargetingRules(ctx context.Context, host, shortCode string, rules []domain.TargetingRule) error {
+	url, ok := m.urls[cacheKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return ErrURLNotFound
+	}
+	url.Targeting = rules
+	return nil
+}
+
+func (m *MockURLStore) SetVariants(ctx context.Context, host, shortCode string, variants []domain.Variant, sticky bool) error {
+	url, ok := m.urls[cacheKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return ErrURLNotFound
+	}
+	url.Variants = variants
+	url.StickyVariants = sticky
+	return nil
+}
+
+func (m *MockURLStore) SetInterstitial(ctx context.Context, host, shortCode string, enabled bool) error {
+	url, ok := m.urls[cacheKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return ErrURLNotFound
+	}
+	url.Interstitial = enabled
+	return nil
+}
+
+func (m *MockURLStore) SetDeepLinks(ctx context.Context, host, shortCode string, links domain.DeepLinks) error {
+	url, ok := m.urls[cacheKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return ErrURLNotFound
+	}
+	url.DeepLinks = links
+	return nil
+}
+
+func (m *MockURLStore) AddTags(ctx context.Context, host, shortCode string, tags []string) error {
+	url, ok := m.urls[cacheKey(host, shortCode)]
+	if !ok {
+		return ErrURLNotFound
+	}
+	for _, tag := range tags {
+		if !hasTags(url, []string{tag}) {
+			url.Tags = append(url.Tags, tag)
+		}
+	}
+	return nil
+}
+
+func (m *MockURLStore) RemoveTag(ctx context.Context, host, shortCode, tag string) error {
+	url, ok := m.urls[cacheKey(host, shortCode)]
+	if !ok {
+		return nil
+	}
+	tags := make([]string, 0, len(url.Tags))
+	for _, existing := range url.Tags {
+		if existing != tag {
+			tags = append(tags, existing)
+		}
+	}
+	url.Tags = tags
+	return nil
+}
+
+func (m *MockURLStore) GetTagCounts(ctx context.Context) ([]domain.TagCount, error) {
+	counts := make(map[string]int64)
+	for _, url := range m.urls {
+		for _, tag := range url.Tags {
+			counts[tag]++
+		}
+	}
+	result := make([]domain.TagCount, 0, len(counts))
+	for tag, count := range counts {
+		result = append(result, domain.TagCount{Tag: tag, Count: count})
+	}
+	return result, nil
+}
+
+func (m *MockURLStore) ListDomains(ctx context.Context) ([]domain.ShortDomain, error) {
+	domains := make([]domain.ShortDomain, 0, len(m.domains))
+	for host := range m.domains {
+		domains = append(domains, domain.ShortDomain{Host: host})
+	}
+	return domains, nil
+}
+
+func (m *MockURLStore) AddDomain(ctx context.Context, host string) (*domain.ShortDomain, error) {
+	if m.domains[host] {
+		return nil, ErrDomainExists
+	}
+	m.domains[host] = true
+	return &domain.ShortDomain{Host: host, CreatedAt: time.Now()}, nil
+}
+
+func (m *MockURLStore) DeleteDomain(ctx context.Context, host string) error {
+	if !m.domains[host] {
+		return ErrDomainNotFound
+	}
+	for _, url := range m.urls {
+		if url.Domain == host && url.DeletedAt == nil {
+			return ErrDomainInUse
+		}
+	}
+	delete(m.domains, host)
+	return nil
+}
+
+func hasTags(url *domain.URL, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, existing := range url.Tags {
+			if existing == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 // MockAnalyticsStore for testing
 type MockAnalyticsStore struct {
 	events map[string][]domain.ClickEvent
+
+	// mu guards events and counts for batch writes from ingester workers
+	mu     sync.Mutex
+	counts []domain.ClickCount
+	// hold, if set, blocks batch writes until it is closed
+	hold chan struct{}
+	// err, if set, fails batch writes
+	err error
+	// reject, if set, fails batch writes holding a click of this short
+	// code like a link deleted from the database would
+	reject string
 }
 
 func NewMockAnalyticsStore() *MockAnalyticsStore {
@@ -68,19 +350,73 @@ func (m *MockAnalyticsStore) SaveClickEvent(ctx context.Context, event *domain.C
 	return nil
 }
 
-func (m *MockAnalyticsStore) GetDailyStats(ctx context.Context, shortCode string, days int) (map[string]int64, error) {
+func (m *MockAnalyticsStore) SaveClickEvents(ctx context.Context, events []*domain.ClickEvent, counts []domain.ClickCount) error {
+	if m.hold != nil {
+		<-m.hold
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	for _, event := range events {
+		if m.reject != "" && event.ShortCode == m.reject {
+			return &pgconn.PgError{Code: "23503", Message: "violates foreign key constraint"}
+		}
+	}
+	for _, event := range events {
+		m.events[event.ShortCode] = append(m.events[event.ShortCode], *event)
+	}
+	m.counts = append(m.counts, counts...)
+	return nil
+}
+
+func (m *MockAnalyticsStore) GetDailyStats(ctx context.Context, host, shortCode string, days int) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+func (m *MockAnalyticsStore) GetMonthlyStats(ctx context.Context, host, shortCode string, months int) (map[string]int64, error) {
 	return map[string]int64{}, nil
 }
 
-func (m *MockAnalyticsStore) GetMonthlyStats(ctx context.Context, shortCode string, months int) (map[string]int64, error) {
+func (m *MockAnalyticsStore) GetDeviceStats(ctx context.Context, host, shortCode string) (map[string]int64, error) {
 	return map[string]int64{}, nil
 }
 
-func (m *MockAnalyticsStore) GetDeviceStats(ctx context.Context, shortCode string) (map[string]int64, error) {
+func (m *MockAnalyticsStore) GetBrowserStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error) {
+	stats := make(map[string]int64)
+	for _, event := range m.events[shortCode] {
+		key := event.Browser
+		if versions && event.BrowserVersion != "" {
+			key += " " + event.BrowserVersion
+		}
+		stats[key]++
+	}
+	return stats, nil
+}
+
+func (m *MockAnalyticsStore) GetOSStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error) {
+	stats := make(map[string]int64)
+	for _, event := range m.events[shortCode] {
+		key := event.OS
+		if versions && event.OSVersion != "" {
+			key += " " + event.OSVersion
+		}
+		stats[key]++
+	}
+	return stats, nil
+}
+
+func (m *MockAnalyticsStore) GetVariantStats(ctx context.Context, host, shortCode string) (map[string]int64, error) {
 	return map[string]int64{}, nil
 }
 
-func (m *MockAnalyticsStore) GetRecentClicks(ctx context.Context, shortCode string, limit int) ([]domain.ClickEvent, error) {
+func (m *MockAnalyticsStore) GetBotStats(ctx context.Context, host, shortCode string) (*domain.BotStats, error) {
+	return &domain.BotStats{ShortCode: shortCode}, nil
+}
+
+func (m *MockAnalyticsStore) GetRecentClicks(ctx context.Context, host, shortCode string, limit int) ([]domain.ClickEvent, error) {
 	if events, ok := m.events[shortCode]; ok {
 		if len(events) > limit {
 			return events[:limit], nil
@@ -90,20 +426,65 @@ func (m *MockAnalyticsStore) GetRecentClicks(ctx context.Context, shortCode stri
 	return []domain.ClickEvent{}, nil
 }
 
-func (m *MockAnalyticsStore) GetAnalytics(ctx context.Context, shortCode string) (*domain.AnalyticsResponse, error) {
+func (m *MockAnalyticsStore) GetAnalytics(ctx context.Context, host, shortCode string) (*domain.AnalyticsResponse, error) {
 	return &domain.AnalyticsResponse{
 		ShortCode:   shortCode,
 		TotalClicks: 0,
 	}, nil
 }
 
+// MockVisitorStore counts visitors exactly, keyed by cacheKey and period
+type MockVisitorStore struct {
+	visitors map[string]map[string]bool
+}
+
+func NewMockVisitorStore() *MockVisitorStore {
+	return &MockVisitorStore{visitors: make(map[string]map[string]bool)}
+}
+
+func (m *MockVisitorStore) AddVisitors(ctx context.Context, events []*domain.ClickEvent) error {
+	for _, event := range events {
+		if event.Visitor == "" {
+			continue
+		}
+		day, month := domain.VisitorPeriods(event.CreatedAt)
+		for _, period := range []string{"", day, month} {
+			key := cacheKey(event.Domain, event.ShortCode) + "@" + period
+			if m.visitors[key] == nil {
+				m.visitors[key] = make(map[string]bool)
+			}
+			m.visitors[key][event.Visitor] = true
+		}
+	}
+	return nil
+}
+
+func (m *MockVisitorStore) CountVisitors(ctx context.Context, host, shortCode string, days, months []string) (*domain.UniqueVisitors, error) {
+	count := func(period string) int64 {
+		return int64(len(m.visitors[cacheKey(host, shortCode)+"@"+period]))
+	}
+
+	visitors := &domain.UniqueVisitors{Total: count(""), Daily: map[string]int64{}, Monthly: map[string]int64{}}
+	for _, day := range days {
+		if n := count(day); n > 0 {
+			visitors.Daily[day] = n
+		}
+	}
+	for _, month := range months {
+		if n := count(month); n > 0 {
+			visitors.Monthly[month] = n
+		}
+	}
+	return visitors, nil
+}
+
 // Tests
 func TestCreateShortURL(t *testing.T) {
 	urlStore := NewMockURLStore()
 	analyticsStore := NewMockAnalyticsStore()
 	cacheClient := &cache.NoOpCache{}
 
-	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient)
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
 
 	tests := []struct {
 		name    string
@@ -153,12 +534,221 @@ func TestCreateShortURL(t *testing.T) {
 	}
 }
 
+func TestShortDomains(t *testing.T) {
+	urlStore := NewMockURLStore()
+	service := NewShortenerService(urlStore, "https://sho.rt", 6, NewMockAnalyticsStore(), &cache.NoOpCache{}, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	if _, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com", Domain: "go.brand.com"}); err != ErrUnknownDomain {
+		t.Fatalf("expected ErrUnknownDomain, got %v", err)
+	}
+
+	if _, err := service.AddDomain(ctx, "Go.Brand.com."); err != nil {
+		t.Fatalf("AddDomain failed: %v", err)
+	}
+	if _, err := service.AddDomain(ctx, "sho.rt"); err != ErrDomainExists {
+		t.Errorf("expected ErrDomainExists for the base host, got %v", err)
+	}
+	if _, err := service.AddDomain(ctx, "bad host/path"); err != ErrInvalidDomain {
+		t.Errorf("expected ErrInvalidDomain, got %v", err)
+	}
+
+	branded, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com/brand", Domain: "go.brand.com", CustomAlias: stringPtr("docs")})
+	if err != nil {
+		t.Fatalf("CreateShortURL on domain failed: %v", err)
+	}
+	if branded.ShortURL != "https://go.brand.com/s/docs" {
+		t.Errorf("expected short URL on the domain, got %s", branded.ShortURL)
+	}
+
+	// The same code is still free on the default domain
+	plain, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com/plain", Domain: "sho.rt", CustomAlias: stringPtr("docs")})
+	if err != nil {
+		t.Fatalf("CreateShortURL on default domain failed: %v", err)
+	}
+	if plain.Domain != "" || plain.ShortURL != "https://sho.rt/s/docs" {
+		t.Errorf("expected link on the default domain, got %+v", plain)
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"go.brand.com", "https://example.com/brand"},
+		{"GO.BRAND.COM", "https://example.com/brand"},
+		{"sho.rt", "https://example.com/plain"},
+		{"unknown.example", "https://example.com/plain"},
+	}
+	for _, tt := range tests {
+		url, err := service.GetOriginalURL(ctx, tt.host, "docs")
+		if err != nil {
+			t.Fatalf("GetOriginalURL(%s) failed: %v", tt.host, err)
+		}
+		if url.OriginalURL != tt.want {
+			t.Errorf("GetOriginalURL(%s) = %s, want %s", tt.host, url.OriginalURL, tt.want)
+		}
+	}
+
+	if err := service.RemoveDomain(ctx, "go.brand.com"); err != ErrDomainInUse {
+		t.Errorf("expected ErrDomainInUse, got %v", err)
+	}
+	if err := service.DeleteURL(ctx, "go.brand.com", "docs"); err != nil {
+		t.Fatalf("DeleteURL failed: %v", err)
+	}
+	if err := service.RemoveDomain(ctx, "go.brand.com"); err != nil {
+		t.Errorf("RemoveDomain failed: %v", err)
+	}
+	if _, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com", Domain: "go.brand.com"}); err != ErrUnknownDomain {
+		t.Errorf("expected ErrUnknownDomain after removal, got %v", err)
+	}
+}
+
+// slowDomainStore is a MockURLStore whose ListDomains can be held or failed
+type slowDomainStore struct {
+	*MockURLStore
+	started chan struct{}
+	hold    chan struct{}
+	err     error
+	calls   int
+}
+
+func (s *slowDomainStore) ListDomains(ctx context.Context) ([]domain.ShortDomain, error) {
+	s.calls++
+	if s.hold != nil {
+		s.started <- struct{}{}
+		<-s.hold
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.MockURLStore.ListDomains(ctx)
+}
+
+func TestDomainRegistryRefresh(t *testing.T) {
+	urlStore := &slowDomainStore{MockURLStore: NewMockURLStore()}
+	urlStore.domains["go.brand.com"] = true
+	service := NewShortenerService(urlStore, "https://sho.rt", 6, NewMockAnalyticsStore(), &cache.NoOpCache{}, false, false, nil, nil, nil).(*shortenerService)
+	ctx := context.Background()
+
+	expire := func() {
+		service.domains.mu.Lock()
+		service.domains.loadedAt = time.Now().Add(-domainRegistryTTL)
+		service.domains.mu.Unlock()
+	}
+
+	if ok, err := service.isRegisteredDomain(ctx, "go.brand.com"); err != nil || !ok {
+		t.Fatalf("expected registered domain, got %v, %v", ok, err)
+	}
+
+	// A failed reload keeps the previous set
+	expire()
+	urlStore.err = errors.New("connection refused")
+	if ok, err := service.isRegisteredDomain(ctx, "go.brand.com"); err != nil || !ok {
+		t.Errorf("expected previous set while the store is down, got %v, %v", ok, err)
+	}
+	urlStore.err = nil
+
+	// Neither does a failed reload after a reset, until the TTL runs out
+	service.domains.Reset()
+	urlStore.err = errors.New("connection refused")
+	calls := urlStore.calls
+	for i := 0; i < 2; i++ {
+		if ok, err := service.isRegisteredDomain(ctx, "go.brand.com"); err != nil || !ok {
+			t.Errorf("expected previous set after a reset, got %v, %v", ok, err)
+		}
+	}
+	if urlStore.calls != calls+1 {
+		t.Errorf("expected one reload after a reset, got %d", urlStore.calls-calls)
+	}
+	urlStore.err = nil
+
+	// Lookups do not wait for a reload in progress
+	expire()
+	urlStore.started, urlStore.hold = make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.isRegisteredDomain(ctx, "go.brand.com")
+	}()
+	<-urlStore.started
+
+	result := make(chan bool, 1)
+	go func() {
+		ok, _ := service.isRegisteredDomain(ctx, "go.brand.com")
+		result <- ok
+	}()
+	select {
+	case ok := <-result:
+		if !ok {
+			t.Error("expected previous set during a reload")
+		}
+	case <-time.After(time.Second):
+		t.Error("lookup waited for the reload")
+	}
+
+	close(urlStore.hold)
+	<-done
+}
+
+func TestForwardPath(t *testing.T) {
+	service := NewShortenerService(NewMockURLStore(), "http://localhost:8080", 6, NewMockAnalyticsStore(), &cache.NoOpCache{}, false, false, nil, nil, nil)
+
+	link := &domain.URL{
+		ShortCode:   "docs",
+		OriginalURL: "https://docs.example.com/?lang=en#top",
+		ForwardPath: true,
+		Variants: []domain.Variant{
+			{Name: "a", URL: "https://a.example.com/base/", Weight: 1},
+			{Name: "b", URL: "https://b.example.com/base", Weight: 1},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		link    *domain.URL
+		path    string
+		want    string
+		wantErr error
+	}{
+		{"no path", link, "", "https://docs.example.com/?lang=en#top", nil},
+		{"nested path", link, "getting-started/install", "https://docs.example.com/getting-started/install?lang=en#top", nil},
+		{"escaped characters", link, "a b", "https://docs.example.com/a%20b?lang=en#top", nil},
+		{"parent segment", link, "guide/../../admin", "", ErrInvalidForwardPath},
+		{"absolute path", link, "/evil.example.com", "", ErrInvalidForwardPath},
+		{"backslash", link, `..\admin`, "", ErrInvalidForwardPath},
+		{"forwarding off", &domain.URL{ShortCode: "plain", OriginalURL: "https://example.com"}, "extra", "", ErrURLNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := service.ForwardPath(tt.link, tt.path)
+			if err != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && got.OriginalURL != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got.OriginalURL)
+			}
+		})
+	}
+
+	forwarded, err := service.ForwardPath(link, "guide")
+	if err != nil {
+		t.Fatalf("ForwardPath failed: %v", err)
+	}
+	if forwarded.Variants[0].URL != "https://a.example.com/base/guide" || forwarded.Variants[1].URL != "https://b.example.com/base/guide" {
+		t.Errorf("expected variant destinations to be forwarded, got %+v", forwarded.Variants)
+	}
+	if link.OriginalURL != "https://docs.example.com/?lang=en#top" || link.Variants[0].URL != "https://a.example.com/base/" {
+		t.Error("ForwardPath must not modify the link")
+	}
+}
+
 func TestGetOriginalURL(t *testing.T) {
 	urlStore := NewMockURLStore()
 	analyticsStore := NewMockAnalyticsStore()
 	cacheClient := &cache.NoOpCache{}
 
-	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient)
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
 
 	// Add a URL
 	testURL := &domain.URL{
@@ -194,7 +784,7 @@ func TestGetOriginalURL(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := service.GetOriginalURL(context.Background(), tt.shortCode)
+			result, err := service.GetOriginalURL(context.Background(), "", tt.shortCode)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("unexpected error: %v", err)
@@ -208,48 +798,1195 @@ func TestGetOriginalURL(t *testing.T) {
 	}
 }
 
-func TestTrackClick(t *testing.T) {
+func TestCreateShortURLExpiration(t *testing.T) {
 	urlStore := NewMockURLStore()
 	analyticsStore := NewMockAnalyticsStore()
 	cacheClient := &cache.NoOpCache{}
 
-	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient)
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
 
-	// Add a URL
-	testURL := &domain.URL{
-		ShortCode:   "abc123",
-		OriginalURL: "https://example.com",
-		Clicks:      0,
-	}
-	if err := urlStore.CreateURL(context.Background(), testURL); err != nil {
-		t.Fatalf("Failed to create URL: %v", err)
-	}
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	var zero int64
 
-	// Track a click
-	err := service.TrackClick(context.Background(), "abc123", "Mozilla/5.0", "192.168.1.1", "https://google.com")
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+	tests := []struct {
+		name    string
+		req     *domain.CreateURLRequest
+		wantErr error
+	}{
+		{
+			name:    "future expiration",
+			req:     &domain.CreateURLRequest{URL: "https://example.com", ExpiresAt: &future},
+			wantErr: nil,
+		},
+		{
+			name:    "past expiration",
+			req:     &domain.CreateURLRequest{URL: "https://example.com", ExpiresAt: &past},
+			wantErr: ErrInvalidExpiresAt,
+		},
+		{
+			name:    "non-positive max clicks",
+			req:     &domain.CreateURLRequest{URL: "https://example.com", MaxClicks: &zero},
+			wantErr: ErrInvalidMaxClicks,
+		},
 	}
 
-	// Verify clicks incremented
-	url, _ := urlStore.GetURLByShortCode(context.Background(), "abc123")
-	if url.Clicks != 1 {
-		t.Errorf("expected 1 click, got %d", url.Clicks)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.CreateShortURL(context.Background(), tt.req)
+			if err != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
 	}
+}
 
-	// Verify event recorded
-	events, _ := analyticsStore.GetRecentClicks(context.Background(), "abc123", 10)
+func TestCreateShortURLRedirectType(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	resp, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("CreateShortURL failed: %v", err)
+	}
+	if resp.RedirectType != http.StatusFound {
+		t.Errorf("expected default redirect type 302, got %d", resp.RedirectType)
+	}
+
+	permanent := http.StatusPermanentRedirect
+	resp, err = service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com", RedirectType: &permanent})
+	if err != nil {
+		t.Fatalf("CreateShortURL failed: %v", err)
+	}
+	url, err := service.GetOriginalURL(ctx, "", resp.ShortCode)
+	if err != nil {
+		t.Fatalf("GetOriginalURL failed: %v", err)
+	}
+	if url.RedirectStatus() != http.StatusPermanentRedirect {
+		t.Errorf("expected redirect type 308, got %d", url.RedirectStatus())
+	}
+
+	for _, status := range []int{http.StatusOK, http.StatusSeeOther, http.StatusNotModified} {
+		status := status
+		if _, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com", RedirectType: &status}); err != ErrInvalidRedirectType {
+			t.Errorf("redirect type %d: expected invalid redirect type error, got %v", status, err)
+		}
+	}
+}
+
+func TestCreateShortURLDedupe(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, true, false, nil, nil, nil)
+	ctx := context.Background()
+
+	first, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com/page"})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if first.Existing {
+		t.Error("expected first link to be new")
+	}
+
+	second, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://EXAMPLE.com/page#top"})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if !second.Existing || second.ShortCode != first.ShortCode {
+		t.Errorf("expected existing code %s, got %s", first.ShortCode, second.ShortCode)
+	}
+
+	disabled := false
+	third, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com/page", Dedupe: &disabled})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if third.Existing || third.ShortCode == first.ShortCode {
+		t.Error("expected per-request flag to override the default")
+	}
+
+	aliased, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com/page", CustomAlias: stringPtr("page")})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if aliased.Existing || aliased.ShortCode != "page" {
+		t.Error("expected custom alias to always create a new link")
+	}
+}
+
+func TestCreateShortURLs(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	if _, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com", CustomAlias: stringPtr("taken")}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	results, err := service.CreateShortURLs(ctx, []*domain.CreateURLRequest{
+		{URL: "https://example.com/1"},
+		{URL: "not a url"},
+		{URL: "https://example.com/2", CustomAlias: stringPtr("taken")},
+		{URL: "https://example.com/3", CustomAlias: stringPtr("fresh")},
+		{URL: "https://example.com/4", CustomAlias: stringPtr("fresh")},
+	})
+	if err != nil {
+		t.Fatalf("CreateShortURLs failed: %v", err)
+	}
+
+	if len(results) != 5 {
+		t.Fatalf("expected 5 results, got %d", len(results))
+	}
+
+	wantOK := []bool{true, false, false, true, false}
+	for i, result := range results {
+		if (result.Err == nil) != wantOK[i] {
+			t.Errorf("item %d: unexpected error %v", i, result.Err)
+		}
+		if wantOK[i] && result.Response == nil {
+			t.Errorf("item %d: expected response", i)
+		}
+	}
+
+	if results[3].Response.OriginalURL != "https://example.com/3" {
+		t.Errorf("expected results in request order, got %s", results[3].Response.OriginalURL)
+	}
+
+	if !IsAlreadyExists(results[2].Err) || !IsAlreadyExists(results[4].Err) {
+		t.Errorf("expected conflicts for taken aliases, got %v and %v", results[2].Err, results[4].Err)
+	}
+
+	if _, err := service.CreateShortURLs(ctx, nil); err != ErrEmptyBatch {
+		t.Errorf("expected empty batch error, got %v", err)
+	}
+}
+
+func TestCreateShortURLsOnDomains(t *testing.T) {
+	urlStore := NewMockURLStore()
+	urlStore.domains["go.brand.com"] = true
+	service := NewShortenerService(urlStore, "https://sho.rt", 6, NewMockAnalyticsStore(), &cache.NoOpCache{}, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	// An alias is only taken on its own domain, also within a batch
+	results, err := service.CreateShortURLs(ctx, []*domain.CreateURLRequest{
+		{URL: "https://example.com/plain", CustomAlias: stringPtr("docs")},
+		{URL: "https://example.com/brand", CustomAlias: stringPtr("docs"), Domain: "go.brand.com"},
+		{URL: "https://example.com/again", CustomAlias: stringPtr("docs"), Domain: "go.brand.com"},
+	})
+	if err != nil {
+		t.Fatalf("CreateShortURLs failed: %v", err)
+	}
+
+	if results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("expected the alias to be created on both domains, got %v and %v", results[0].Err, results[1].Err)
+	}
+	if results[1].Response.ShortURL != "https://go.brand.com/s/docs" {
+		t.Errorf("expected short URL on the domain, got %s", results[1].Response.ShortURL)
+	}
+	if !IsAlreadyExists(results[2].Err) {
+		t.Errorf("expected a conflict for the repeated alias, got %v", results[2].Err)
+	}
+}
+
+func TestCreateShortURLsDedupe(t *testing.T) {
+	urlStore := NewMockURLStore()
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, NewMockAnalyticsStore(), &cache.NoOpCache{}, true, false, nil, nil, nil)
+	ctx := context.Background()
+
+	first, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com/page"})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	disabled := false
+	results, err := service.CreateShortURLs(ctx, []*domain.CreateURLRequest{
+		{URL: "https://EXAMPLE.com/page"},
+		{URL: "https://example.com/page", Dedupe: &disabled},
+		{URL: "https://example.com/new"},
+		{URL: "https://EXAMPLE.com/new"},
+	})
+	if err != nil {
+		t.Fatalf("CreateShortURLs failed: %v", err)
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatalf("item %d: unexpected error %v", i, result.Err)
+		}
+	}
+
+	if !results[0].Response.Existing || results[0].Response.ShortCode != first.ShortCode {
+		t.Errorf("expected the existing link, got %+v", results[0].Response)
+	}
+	if results[1].Response.Existing || results[1].Response.ShortCode == first.ShortCode {
+		t.Error("expected dedupe=false to create a new link")
+	}
+
+	// A destination repeated within the batch gets a single link
+	if results[2].Response.Existing {
+		t.Error("expected the first item with a new destination to create a link")
+	}
+	if !results[3].Response.Existing || results[3].Response.ShortCode != results[2].Response.ShortCode {
+		t.Errorf("expected the repeated destination to reuse %s, got %+v", results[2].Response.ShortCode, results[3].Response)
+	}
+}
+
+func TestGetOriginalURLExpired(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+
+	past := time.Now().Add(-time.Minute)
+	maxClicks := int64(2)
+
+	urls := []*domain.URL{
+		{ShortCode: "expired", OriginalURL: "https://example.com", ExpiresAt: &past},
+		{ShortCode: "budget", OriginalURL: "https://example.com", MaxClicks: &maxClicks, Clicks: 2},
+	}
+	for _, url := range urls {
+		if err := urlStore.CreateURL(context.Background(), url); err != nil {
+			t.Fatalf("Failed to create URL: %v", err)
+		}
+	}
+
+	for _, url := range urls {
+		t.Run(url.ShortCode, func(t *testing.T) {
+			_, err := service.GetOriginalURL(context.Background(), "", url.ShortCode)
+			if !IsExpired(err) {
+				t.Errorf("expected expired error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdateDestination(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+
+	testURL := &domain.URL{
+		ShortCode:   "abc123",
+		OriginalURL: "https://exmaple.com",
+	}
+	if err := urlStore.CreateURL(context.Background(), testURL); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		shortCode string
+		url       string
+		wantErr   bool
+	}{
+		{"valid destination", "abc123", "https://example.com", false},
+		{"invalid destination", "abc123", "ftp://example.com", true},
+		{"non-existing short code", "xyz789", "https://example.com", true},
+		{"empty short code", "", "https://example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := service.UpdateDestination(context.Background(), "", tt.shortCode, tt.url)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			if !tt.wantErr && result.OriginalURL != tt.url {
+				t.Errorf("expected OriginalURL %s, got %s", tt.url, result.OriginalURL)
+			}
+		})
+	}
+
+	url, _ := urlStore.GetURLByShortCode(context.Background(), "", "abc123")
+	if url.OriginalURL != "https://example.com" {
+		t.Errorf("expected stored OriginalURL to be updated, got %s", url.OriginalURL)
+	}
+}
+
+func TestDisableAndDeleteURL(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	if _, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com", CustomAlias: stringPtr("abc123")}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	if err := service.DisableURL(ctx, "", "abc123"); err != nil {
+		t.Fatalf("DisableURL failed: %v", err)
+	}
+	if _, err := service.GetOriginalURL(ctx, "", "abc123"); !IsDisabled(err) {
+		t.Errorf("expected disabled error, got %v", err)
+	}
+
+	if err := service.EnableURL(ctx, "", "abc123"); err != nil {
+		t.Fatalf("EnableURL failed: %v", err)
+	}
+	if _, err := service.GetOriginalURL(ctx, "", "abc123"); err != nil {
+		t.Errorf("expected enabled URL, got %v", err)
+	}
+
+	if err := service.DeleteURL(ctx, "", "abc123"); err != nil {
+		t.Fatalf("DeleteURL failed: %v", err)
+	}
+	if _, err := service.GetOriginalURL(ctx, "", "abc123"); !IsDeleted(err) {
+		t.Errorf("expected deleted error, got %v", err)
+	}
+
+	// The tombstone keeps the code reserved
+	_, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com", CustomAlias: stringPtr("abc123")})
+	if !IsAlreadyExists(err) {
+		t.Errorf("expected short code to stay reserved, got %v", err)
+	}
+
+	if err := service.DeleteURL(ctx, "", "xyz789"); !IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	resp, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{
+		URL:         "https://example.com",
+		CustomAlias: stringPtr("abc123"),
+		Tags:        []string{" Promo ", "promo", "spring"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if len(resp.Tags) != 2 || resp.Tags[0] != "promo" || resp.Tags[1] != "spring" {
+		t.Errorf("expected normalized tags [promo spring], got %v", resp.Tags)
+	}
+
+	if _, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.org", CustomAlias: stringPtr("def456")}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	url, err := service.AddTags(ctx, "", "def456", []string{"Promo"})
+	if err != nil {
+		t.Fatalf("AddTags failed: %v", err)
+	}
+	if len(url.Tags) != 1 || url.Tags[0] != "promo" {
+		t.Errorf("expected tags [promo], got %v", url.Tags)
+	}
+
+	urls, err := service.GetAllURLs(ctx, 10, []string{"promo", "spring"})
+	if err != nil {
+		t.Fatalf("GetAllURLs failed: %v", err)
+	}
+	if len(urls) != 1 || urls[0].ShortCode != "abc123" {
+		t.Errorf("expected only abc123 to match both tags, got %v", urls)
+	}
+
+	if _, err := service.RemoveTag(ctx, "", "abc123", "SPRING"); err != nil {
+		t.Fatalf("RemoveTag failed: %v", err)
+	}
+	urls, _ = service.GetAllURLs(ctx, 10, []string{"spring"})
+	if len(urls) != 0 {
+		t.Errorf("expected no links tagged spring, got %d", len(urls))
+	}
+
+	if _, err := service.AddTags(ctx, "", "abc123", []string{""}); err != ErrInvalidTag {
+		t.Errorf("expected invalid tag error, got %v", err)
+	}
+
+	tooMany := make([]string, maxTagsPerURL+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("tag%d", i)
+	}
+	if _, err := service.AddTags(ctx, "", "abc123", tooMany); err != ErrTooManyTags {
+		t.Errorf("expected too many tags error, got %v", err)
+	}
+
+	if _, err := service.AddTags(ctx, "", "missing", []string{"promo"}); !IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestSetTargeting(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	resp, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{
+		URL:       "https://example.com",
+		Targeting: []domain.TargetingRule{{Device: " Mobile ", URL: "https://m.example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateShortURL failed: %v", err)
+	}
+	if len(resp.Targeting) != 1 || resp.Targeting[0].Device != domain.DeviceMobile {
+		t.Errorf("expected normalized targeting rule, got %v", resp.Targeting)
+	}
+
+	rules := []domain.TargetingRule{
+		{OS: "android", URL: "https://example.com/android"},
+		{Language: "de", URL: "https://example.com/de"},
+	}
+	url, err := service.SetTargeting(ctx, "", resp.ShortCode, rules)
+	if err != nil {
+		t.Fatalf("SetTargeting failed: %v", err)
+	}
+	if len(url.Targeting) != 2 || url.Targeting[1].Language != "de" {
+		t.Errorf("expected rules to be replaced in order, got %v", url.Targeting)
+	}
+
+	invalid := []struct {
+		name  string
+		rules []domain.TargetingRule
+		err   error
+	}{
+		{"no condition", []domain.TargetingRule{{URL: "https://example.com"}}, ErrInvalidTargetingRule},
+		{"unknown device", []domain.TargetingRule{{Device: "watch", URL: "https://example.com"}}, ErrInvalidTargetingRule},
+		{"bad language", []domain.TargetingRule{{Language: "german", URL: "https://example.com"}}, ErrInvalidTargetingRule},
+		{"bad destination", []domain.TargetingRule{{OS: "ios", URL: "ftp://example.com"}}, ErrInvalidTargetingRule},
+		{"too many rules", make([]domain.TargetingRule, maxTargetingRules+1), ErrTooManyTargetingRules},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.SetTargeting(ctx, "", resp.ShortCode, tt.rules); err != tt.err {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	if _, err := service.SetTargeting(ctx, "", "missing", rules); !IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestSetVariants(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	resp, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("CreateShortURL failed: %v", err)
+	}
+
+	variants := []domain.Variant{
+		{URL: "https://example.com/a", Weight: 70},
+		{Name: "green", URL: "https://example.com/b", Weight: 30},
+	}
+	url, err := service.SetVariants(ctx, "", resp.ShortCode, variants, true)
+	if err != nil {
+		t.Fatalf("SetVariants failed: %v", err)
+	}
+	if len(url.Variants) != 2 || url.Variants[0].Name != "v1" || url.Variants[1].Name != "green" {
+		t.Errorf("expected unnamed variant to be named by position, got %v", url.Variants)
+	}
+	if !url.StickyVariants {
+		t.Error("expected sticky variants")
+	}
+
+	invalid := []struct {
+		name     string
+		variants []domain.Variant
+		err      error
+	}{
+		{"single variant", []domain.Variant{{URL: "https://example.com/a", Weight: 1}}, ErrInvalidVariant},
+		{"zero weight", []domain.Variant{{URL: "https://example.com/a", Weight: 1}, {URL: "https://example.com/b"}}, ErrInvalidVariant},
+		{"duplicate name", []domain.Variant{{Name: "a", URL: "https://example.com/a", Weight: 1}, {Name: "a", URL: "https://example.com/b", Weight: 1}}, ErrInvalidVariant},
+		{"bad destination", []domain.Variant{{URL: "https://example.com/a", Weight: 1}, {URL: "ftp://example.com", Weight: 1}}, ErrInvalidVariant},
+		{"too many variants", make([]domain.Variant, maxVariants+1), ErrTooManyVariants},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.SetVariants(ctx, "", resp.ShortCode, tt.variants, false); err != tt.err {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	if _, err := service.SetVariants(ctx, "", "missing", variants, false); !IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestSetDeepLinks(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	resp, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("CreateShortURL failed: %v", err)
+	}
+
+	links := domain.DeepLinks{IOSURL: " myapp://home ", FallbackURL: "https://apps.apple.com/app/id123"}
+	url, err := service.SetDeepLinks(ctx, "", resp.ShortCode, links)
+	if err != nil {
+		t.Fatalf("SetDeepLinks failed: %v", err)
+	}
+	if url.IOSURL != "myapp://home" {
+		t.Errorf("expected trimmed ios url, got %q", url.IOSURL)
+	}
+
+	invalid := []struct {
+		name  string
+		links domain.DeepLinks
+	}{
+		{"unsafe scheme", domain.DeepLinks{AndroidURL: "javascript:alert(1)"}},
+		{"relative app url", domain.DeepLinks{IOSURL: "home"}},
+		{"fallback with custom scheme", domain.DeepLinks{IOSURL: "myapp://home", FallbackURL: "market://details?id=app"}},
+		{"fallback without app", domain.DeepLinks{FallbackURL: "https://example.com"}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.SetDeepLinks(ctx, "", resp.ShortCode, tt.links); err != ErrInvalidDeepLink {
+				t.Errorf("expected %v, got %v", ErrInvalidDeepLink, err)
+			}
+		})
+	}
+}
+
+func TestUnlockURL(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	resp, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{
+		URL:         "https://example.com",
+		CustomAlias: stringPtr("secret"),
+		Password:    stringPtr("hunter2"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if !resp.Protected {
+		t.Error("expected response to be marked as protected")
+	}
+
+	stored, _ := urlStore.GetURLByShortCode(ctx, "", "secret")
+	if stored.PasswordHash == nil || *stored.PasswordHash == "hunter2" {
+		t.Fatal("expected password to be stored hashed")
+	}
+
+	if _, err := service.UnlockURL(ctx, "", "secret", "wrong"); err != ErrInvalidPassword {
+		t.Errorf("expected invalid password error, got %v", err)
+	}
+
+	url, err := service.UnlockURL(ctx, "", "secret", "hunter2")
+	if err != nil {
+		t.Fatalf("expected unlock to succeed, got %v", err)
+	}
+	if url.OriginalURL != "https://example.com" {
+		t.Errorf("expected OriginalURL, got %s", url.OriginalURL)
+	}
+
+	if _, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{URL: "https://example.com", Password: stringPtr("")}); err != ErrEmptyPassword {
+		t.Errorf("expected empty password error, got %v", err)
+	}
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	limiter := newAttemptLimiter(2, time.Minute)
+	now := time.Now()
+
+	if !limiter.Allow("abc", now) || !limiter.Allow("abc", now) {
+		t.Fatal("expected the first 2 attempts to be allowed")
+	}
+	if limiter.Allow("abc", now) {
+		t.Error("expected attempt to be blocked after 2 attempts")
+	}
+
+	if !limiter.Allow("xyz", now) {
+		t.Error("expected other codes to be unaffected")
+	}
+
+	if !limiter.Allow("abc", now.Add(time.Minute)) {
+		t.Error("expected attempt to be allowed after the window")
+	}
+
+	limiter.Reset("abc")
+	limiter.Allow("abc", now)
+	limiter.Release("abc")
+	limiter.Allow("abc", now)
+	if !limiter.Allow("abc", now) {
+		t.Error("expected released attempt not to count")
+	}
+
+	limiter.Reset("abc")
+	if !limiter.Allow("abc", now) {
+		t.Error("expected attempt to be allowed after reset")
+	}
+}
+
+func TestUnlockURLConcurrentAttempts(t *testing.T) {
+	urlStore := NewMockURLStore()
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, NewMockAnalyticsStore(), &cache.NoOpCache{}, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	if _, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{
+		URL:         "https://example.com",
+		CustomAlias: stringPtr("secret"),
+		Password:    stringPtr("hunter2"),
+	}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	// Attempts sent at once must not all pass the limiter before the
+	// first of them fails
+	const attempts = 20
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		checked int
+	)
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := service.UnlockURL(ctx, "", "secret", "wrong"); err == ErrInvalidPassword {
+				mu.Lock()
+				checked++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if checked != maxPasswordAttempts {
+		t.Errorf("expected %d passwords to be checked, got %d", maxPasswordAttempts, checked)
+	}
+	if _, err := service.UnlockURL(ctx, "", "secret", "hunter2"); err != ErrTooManyAttempts {
+		t.Errorf("expected %v, got %v", ErrTooManyAttempts, err)
+	}
+}
+
+func TestTrackClick(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+
+	// Add a URL
+	testURL := &domain.URL{
+		ShortCode:   "abc123",
+		OriginalURL: "https://example.com",
+		Clicks:      0,
+	}
+	if err := urlStore.CreateURL(context.Background(), testURL); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	// Track a click
+	err := service.TrackClick(context.Background(), testURL, "Mozilla/5.0", "192.168.1.1", "https://google.com", "", false)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Verify clicks incremented
+	url, _ := urlStore.GetURLByShortCode(context.Background(), "", "abc123")
+	if url.Clicks != 1 {
+		t.Errorf("expected 1 click, got %d", url.Clicks)
+	}
+
+	// Verify event recorded
+	events, _ := analyticsStore.GetRecentClicks(context.Background(), "", "abc123", 10)
 	if len(events) != 1 {
 		t.Errorf("expected 1 event, got %d", len(events))
 	}
 }
 
+func TestTrackBotClick(t *testing.T) {
+	for _, countBots := range []bool{false, true} {
+		urlStore := NewMockURLStore()
+		analyticsStore := NewMockAnalyticsStore()
+		service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, &cache.NoOpCache{}, false, countBots, nil, nil, nil)
+
+		testURL := &domain.URL{ShortCode: "abc123", OriginalURL: "https://example.com"}
+		if err := urlStore.CreateURL(context.Background(), testURL); err != nil {
+			t.Fatalf("Failed to create URL: %v", err)
+		}
+
+		if err := service.TrackClick(context.Background(), &domain.URL{ShortCode: "abc123"}, "Slackbot-LinkExpanding 1.0", "10.0.0.1", "", "", true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var expected int64
+		if countBots {
+			expected = 1
+		}
+		url, _ := urlStore.GetURLByShortCode(context.Background(), "", "abc123")
+		if url.Clicks != expected {
+			t.Errorf("countBots=%v: expected %d clicks, got %d", countBots, expected, url.Clicks)
+		}
+
+		events := analyticsStore.events["abc123"]
+		if len(events) != 1 || !events[0].IsBot {
+			t.Errorf("countBots=%v: expected one bot event, got %v", countBots, events)
+		}
+	}
+}
+
+func TestClickIngester(t *testing.T) {
+	analyticsStore := NewMockAnalyticsStore()
+	ingester := NewClickIngester(analyticsStore, nil, nil, nil, 100, 100, time.Hour, 1)
+	service := NewShortenerService(NewMockURLStore(), "http://localhost:8080", 6, analyticsStore, &cache.NoOpCache{}, false, false, ingester, nil, nil)
+
+	clicks := []struct {
+		host, code string
+		isBot      bool
+	}{
+		{"", "abc123", false},
+		{"", "abc123", false},
+		{"go.example.com", "abc123", false},
+		{"", "abc123", true},
+		{"", "xyz789", false},
+	}
+	for _, click := range clicks {
+		if err := service.TrackClick(context.Background(), &domain.URL{Domain: click.host, ShortCode: click.code}, "Mozilla/5.0", "10.0.0.1", "", "", click.isBot); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := ingester.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if got := len(analyticsStore.events["abc123"]); got != 4 {
+		t.Errorf("expected 4 abc123 events after flush, got %d", got)
+	}
+
+	expected := []domain.ClickCount{
+		{Domain: "", ShortCode: "abc123", Clicks: 2},
+		{Domain: "go.example.com", ShortCode: "abc123", Clicks: 1},
+		{Domain: "", ShortCode: "xyz789", Clicks: 1},
+	}
+	if !reflect.DeepEqual(analyticsStore.counts, expected) {
+		t.Errorf("expected counts %v, got %v", expected, analyticsStore.counts)
+	}
+
+	stats := service.ClickIngestStats()
+	if stats.Enqueued != 5 || stats.Written != 5 || stats.Batches != 1 || stats.Dropped != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	if err := service.TrackClick(context.Background(), &domain.URL{ShortCode: "abc123"}, "Mozilla/5.0", "10.0.0.1", "", "", false); err != ErrClickDropped {
+		t.Errorf("expected ErrClickDropped after Close, got %v", err)
+	}
+}
+
+func TestClickIngesterDropsWhenFull(t *testing.T) {
+	analyticsStore := NewMockAnalyticsStore()
+	analyticsStore.hold = make(chan struct{})
+	ingester := NewClickIngester(analyticsStore, nil, nil, nil, 1, 1, time.Hour, 1)
+
+	event := &domain.ClickEvent{ShortCode: "abc123"}
+	ingester.Enqueue(event, true)
+
+	// Wait until the worker has taken the first click and is stuck writing it
+	for deadline := time.Now().Add(time.Second); ingester.Stats().QueueLength > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("worker did not pick up the first click")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if !ingester.Enqueue(event, true) {
+		t.Error("expected second click to fit into the queue")
+	}
+	if ingester.Enqueue(event, true) {
+		t.Error("expected third click to be dropped")
+	}
+
+	close(analyticsStore.hold)
+	if err := ingester.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	stats := ingester.Stats()
+	if stats.Dropped != 1 || stats.Written != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestTrackClickBudget(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	// Queued clicks are not written before Close
+	ingester := NewClickIngester(analyticsStore, nil, nil, nil, 100, 100, time.Hour, 1)
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, &cache.NoOpCache{}, false, false, ingester, nil, nil)
+
+	maxClicks := int64(2)
+	url := &domain.URL{ShortCode: "abc123", OriginalURL: "https://example.com", MaxClicks: &maxClicks}
+	if err := urlStore.CreateURL(context.Background(), url); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	loaded := *url
+
+	// The budget is taken right away, not when the queue is flushed
+	for i := 0; i < 2; i++ {
+		if err := service.TrackClick(context.Background(), &loaded, "Mozilla/5.0", "10.0.0.1", "", "", false); err != nil {
+			t.Fatalf("click %d: unexpected error: %v", i+1, err)
+		}
+	}
+	if err := service.TrackClick(context.Background(), &loaded, "Mozilla/5.0", "10.0.0.1", "", "", false); err != ErrURLExpired {
+		t.Errorf("expected %v once the budget is spent, got %v", ErrURLExpired, err)
+	}
+	if url.Clicks != 2 {
+		t.Errorf("expected 2 clicks, got %d", url.Clicks)
+	}
+
+	if err := ingester.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if got := len(analyticsStore.events["abc123"]); got != 2 {
+		t.Errorf("expected 2 stored clicks, got %d", got)
+	}
+	for _, count := range analyticsStore.counts {
+		if count.Clicks != 0 {
+			t.Errorf("expected budgeted clicks not to be counted again, got %+v", count)
+		}
+	}
+}
+
+// pingerFunc adapts a function to store.Pinger
+type pingerFunc func(ctx context.Context) error
+
+func (f pingerFunc) Ping(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestClickIngesterSpool(t *testing.T) {
+	dir := t.TempDir()
+	clickSpool, err := spool.Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+
+	analyticsStore := NewMockAnalyticsStore()
+	analyticsStore.err = errors.New("connection refused")
+	dbDown := true
+	ping := pingerFunc(func(ctx context.Context) error {
+		analyticsStore.mu.Lock()
+		defer analyticsStore.mu.Unlock()
+		if dbDown {
+			return analyticsStore.err
+		}
+		return nil
+	})
+
+	ingester := NewClickIngester(analyticsStore, nil, clickSpool, ping, 100, 1, time.Hour, 1)
+	for _, code := range []string{"first", "second", "third"} {
+		ingester.Enqueue(&domain.ClickEvent{ShortCode: code}, true)
+	}
+	if err := ingester.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	stats := ingester.Stats()
+	if stats.Spooled != 3 || stats.Written != 0 || stats.Failed != 0 || stats.SpoolBytes == 0 {
+		t.Fatalf("expected 3 spooled clicks, got %+v", stats)
+	}
+	if err := clickSpool.Close(); err != nil {
+		t.Fatalf("Failed to close spool: %v", err)
+	}
+
+	// The spool survives a restart and is replayed once the database is back
+	clickSpool, err = spool.Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Failed to reopen spool: %v", err)
+	}
+	ingester = &ClickIngester{store: analyticsStore, spool: clickSpool, pinger: ping}
+
+	ingester.replay()
+	if !clickSpool.Pending() {
+		t.Fatal("expected spool to be kept while the database is down")
+	}
+
+	analyticsStore.mu.Lock()
+	dbDown, analyticsStore.err = false, nil
+	analyticsStore.mu.Unlock()
+
+	ingester.replay()
+	if clickSpool.Pending() {
+		t.Error("expected spool to be empty after replay")
+	}
+	if got := ingester.Stats().Replayed; got != 3 {
+		t.Errorf("expected 3 replayed clicks, got %d", got)
+	}
+
+	var order []string
+	for _, count := range analyticsStore.counts {
+		order = append(order, count.ShortCode)
+	}
+	if want := []string{"first", "second", "third"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("expected clicks replayed in order %v, got %v", want, order)
+	}
+}
+
+func TestClickIngesterDiscardsRejectedClicks(t *testing.T) {
+	clickSpool, err := spool.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+	defer clickSpool.Close()
+
+	analyticsStore := NewMockAnalyticsStore()
+	analyticsStore.reject = "deleted"
+	ping := pingerFunc(func(ctx context.Context) error { return nil })
+
+	// A rejected batch is written click by click instead of being spooled
+	ingester := &ClickIngester{store: analyticsStore, spool: clickSpool, pinger: ping}
+	ingester.flush([]clickJob{
+		{event: &domain.ClickEvent{ShortCode: "first"}, counted: true},
+		{event: &domain.ClickEvent{ShortCode: "deleted"}, counted: true},
+		{event: &domain.ClickEvent{ShortCode: "second"}, counted: true},
+	})
+
+	stats := ingester.Stats()
+	if stats.Written != 2 || stats.Failed != 1 || stats.Spooled != 0 {
+		t.Fatalf("expected 2 written and 1 discarded click, got %+v", stats)
+	}
+	if len(analyticsStore.events["first"]) != 1 || len(analyticsStore.events["second"]) != 1 {
+		t.Errorf("expected the other clicks to be written, got %v", analyticsStore.events)
+	}
+
+	// A spooled batch the database rejects for good does not hold up the
+	// spool
+	record, _ := json.Marshal(spooledBatch{
+		Events: []*domain.ClickEvent{{ShortCode: "deleted"}, {ShortCode: "third"}},
+		Counts: []domain.ClickCount{{ShortCode: "deleted", Clicks: 1}, {ShortCode: "third", Clicks: 1}},
+	})
+	if err := clickSpool.Append(record); err != nil {
+		t.Fatalf("Failed to spool batch: %v", err)
+	}
+
+	ingester.replay()
+	if clickSpool.Pending() {
+		t.Error("expected rejected batch to be removed from the spool")
+	}
+	if len(analyticsStore.events["third"]) != 1 {
+		t.Error("expected the other click of the spooled batch to be written")
+	}
+
+	var clicks int64
+	for _, count := range analyticsStore.counts {
+		if count.ShortCode == "deleted" {
+			t.Errorf("unexpected count for rejected link: %+v", count)
+		}
+		clicks += count.Clicks
+	}
+	if clicks != 3 {
+		t.Errorf("expected 3 counted clicks, got %d", clicks)
+	}
+}
+
+func TestUniqueVisitors(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	visitors := NewVisitorCounter(NewMockVisitorStore(), "secret")
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, &cache.NoOpCache{}, false, false, nil, visitors, nil)
+	analytics := NewAnalyticsService(analyticsStore, visitors, nil)
+
+	if err := urlStore.CreateURL(context.Background(), &domain.URL{ShortCode: "abc123", OriginalURL: "https://example.com"}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	clicks := []struct {
+		ip, userAgent string
+		isBot         bool
+	}{
+		{"10.0.0.1", "Mozilla/5.0 (Windows)", false},
+		{"10.0.0.1", "Mozilla/5.0 (Windows)", false},
+		{"10.0.0.1", "Mozilla/5.0 (iPhone)", false},
+		{"10.0.0.2", "Mozilla/5.0 (Windows)", false},
+		{"10.0.0.3", "Googlebot/2.1", true},
+	}
+	for _, click := range clicks {
+		if err := service.TrackClick(context.Background(), &domain.URL{ShortCode: "abc123"}, click.userAgent, click.ip, "", "", click.isBot); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for _, event := range analyticsStore.events["abc123"] {
+		if event.IsBot != (event.Visitor == "") {
+			t.Errorf("expected fingerprints on counted clicks only, got %+v", event)
+		}
+		if strings.Contains(event.Visitor, "10.0.0") {
+			t.Errorf("fingerprint %q contains the raw IP", event.Visitor)
+		}
+	}
+
+	result, err := analytics.GetAnalytics(context.Background(), "", "abc123")
+	if err != nil {
+		t.Fatalf("GetAnalytics failed: %v", err)
+	}
+	day, month := domain.VisitorPeriods(time.Now())
+	if result.UniqueVisitors == nil || result.UniqueVisitors.Total != 3 ||
+		result.UniqueVisitors.Daily[day] != 3 || result.UniqueVisitors.Monthly[month] != 3 {
+		t.Errorf("expected 3 unique visitors total, today and this month, got %+v", result.UniqueVisitors)
+	}
+
+	if NewVisitorCounter(nil, "other").Fingerprint("10.0.0.1", "Mozilla/5.0 (Windows)") == visitors.Fingerprint("10.0.0.1", "Mozilla/5.0 (Windows)") {
+		t.Error("expected fingerprints to depend on the salt")
+	}
+}
+
+func TestIPAnonymizer(t *testing.T) {
+	if _, err := NewIPAnonymizer("scramble", 24, 48, "", 0); err != ErrInvalidPrivacyMode {
+		t.Errorf("expected ErrInvalidPrivacyMode, got %v", err)
+	}
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	truncate, _ := NewIPAnonymizer(domain.IPPrivacyTruncate, 24, 48, "", 0)
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.77", "203.0.113.0"},
+		{"::ffff:203.0.113.77", "203.0.113.0"},
+		{"2001:db8:abcd:12:34::1", "2001:db8:abcd::"},
+		{"not-an-ip", ""},
+	}
+	for _, tt := range tests {
+		if addr, hash := truncate.Anonymize(tt.ip, now); addr != tt.want || hash != "" {
+			t.Errorf("truncate %q: expected %q, got %q, %q", tt.ip, tt.want, addr, hash)
+		}
+	}
+
+	hasher, _ := NewIPAnonymizer(domain.IPPrivacyHash, 24, 48, "secret", 24*time.Hour)
+	addr, hash := hasher.Anonymize("203.0.113.77", now)
+	if addr != "" || len(hash) != 32 {
+		t.Fatalf("expected a hash and no address, got %q, %q", addr, hash)
+	}
+	if _, same := hasher.Anonymize("203.0.113.77", now.Add(time.Hour)); same != hash {
+		t.Error("expected the same hash within a rotation period")
+	}
+	if _, next := hasher.Anonymize("203.0.113.77", now.Add(24*time.Hour)); next == hash {
+		t.Error("expected the hash to change with the next rotation period")
+	}
+	if _, other := hasher.Anonymize("203.0.113.78", now); other == hash {
+		t.Error("expected different addresses to hash differently")
+	}
+}
+
+func TestClickIPPrivacy(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	hasher, _ := NewIPAnonymizer(domain.IPPrivacyHash, 24, 48, "secret", 0)
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, &cache.NoOpCache{}, false, false, nil, nil, hasher)
+
+	if err := urlStore.CreateURL(context.Background(), &domain.URL{ShortCode: "abc123", OriginalURL: "https://example.com"}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if err := service.TrackClick(context.Background(), &domain.URL{ShortCode: "abc123"}, "Mozilla/5.0", "203.0.113.77", "", "", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stored := analyticsStore.events["abc123"][0]
+	if stored.IP != "" || stored.IPHash == "" {
+		t.Errorf("expected only a hash to be stored, got ip %q, hash %q", stored.IP, stored.IPHash)
+	}
+
+	// Clicks stored before privacy mode was turned on still hold full
+	// addresses, which the API must not return
+	analyticsStore.events["xyz789"] = []domain.ClickEvent{{ShortCode: "xyz789", IP: "198.51.100.23"}, {ShortCode: "xyz789", IP: "2001:db8::1"}}
+	analytics := NewAnalyticsService(analyticsStore, nil, nil)
+
+	clicks, err := analytics.GetRecentClicks(context.Background(), "", "xyz789", 10)
+	if err != nil {
+		t.Fatalf("GetRecentClicks failed: %v", err)
+	}
+	if clicks[0].IP != "198.51.100.0" || clicks[1].IP != "2001:db8::" {
+		t.Errorf("expected redacted addresses, got %q and %q", clicks[0].IP, clicks[1].IP)
+	}
+}
+
+func TestClickClientStats(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, &cache.NoOpCache{}, false, false, nil, nil, nil)
+
+	if err := urlStore.CreateURL(context.Background(), &domain.URL{ShortCode: "abc123", OriginalURL: "https://example.com"}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	agents := []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.6478.127 Safari/537.36",
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.6422.165 Mobile Safari/537.36",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+	}
+	for _, agent := range agents {
+		if err := service.TrackClick(context.Background(), &domain.URL{ShortCode: "abc123"}, agent, "203.0.113.7", "", "", false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	stored := analyticsStore.events["abc123"][2]
+	if stored.Browser != "safari" || stored.BrowserVersion != "17.5" || stored.OS != domain.OSIOS || stored.OSVersion != "17.5" || stored.Device != domain.DeviceMobile {
+		t.Errorf("unexpected client of stored click: %+v", stored)
+	}
+
+	analytics := NewAnalyticsService(analyticsStore, nil, nil)
+
+	browsers, err := analytics.GetBrowserStats(context.Background(), "", "abc123", false)
+	if err != nil {
+		t.Fatalf("GetBrowserStats failed: %v", err)
+	}
+	if browsers["chrome"] != 2 || browsers["safari"] != 1 {
+		t.Errorf("unexpected browser stats: %v", browsers)
+	}
+
+	systems, err := analytics.GetOSStats(context.Background(), "", "abc123", true)
+	if err != nil {
+		t.Fatalf("GetOSStats failed: %v", err)
+	}
+	if systems["windows 10"] != 1 || systems["android 14"] != 1 || systems["ios 17.5"] != 1 {
+		t.Errorf("unexpected os stats: %v", systems)
+	}
+
+	if _, err := analytics.GetBrowserStats(context.Background(), "", "", false); err != ErrInvalidShortCode {
+		t.Errorf("expected ErrInvalidShortCode, got %v", err)
+	}
+}
+
 func TestGetAllURLs(t *testing.T) {
 	urlStore := NewMockURLStore()
 	analyticsStore := NewMockAnalyticsStore()
 	cacheClient := &cache.NoOpCache{}
 
-	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient)
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
 
 	// Add multiple URLs
 	for i := 1; i <= 3; i++ {
@@ -263,7 +2000,7 @@ func TestGetAllURLs(t *testing.T) {
 		}
 	}
 
-	urls, err := service.GetAllURLs(context.Background(), 10)
+	urls, err := service.GetAllURLs(context.Background(), 10, nil)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -273,12 +2010,106 @@ func TestGetAllURLs(t *testing.T) {
 	}
 }
 
+func TestListURLsPagination(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	// Links share creation times so the id has to break ties
+	base := time.Now().Add(-time.Hour)
+	for i := 1; i <= 5; i++ {
+		url := &domain.URL{
+			ID:          int64(i),
+			ShortCode:   fmt.Sprintf("code%d", i),
+			OriginalURL: fmt.Sprintf("https://example.com/%d", i),
+			CreatedAt:   base.Add(time.Duration(i/2) * time.Minute),
+			Clicks:      int64(i % 3),
+		}
+		if err := urlStore.CreateURL(ctx, url); err != nil {
+			t.Fatalf("Failed to create URL: %v", err)
+		}
+	}
+
+	for _, order := range []domain.URLOrder{domain.URLOrderCreated, domain.URLOrderClicks} {
+		seen := make(map[string]bool)
+		cursor := ""
+		pages := 0
+		for {
+			page, err := service.ListURLs(ctx, 2, nil, order, cursor)
+			if err != nil {
+				t.Fatalf("ListURLs(%s) failed: %v", order, err)
+			}
+			pages++
+			for _, url := range page.URLs {
+				if seen[url.ShortCode] {
+					t.Errorf("order %s: %s returned twice", order, url.ShortCode)
+				}
+				seen[url.ShortCode] = true
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		if len(seen) != 5 || pages != 3 {
+			t.Errorf("order %s: expected 5 links over 3 pages, got %d over %d", order, len(seen), pages)
+		}
+	}
+
+	first, _ := service.ListURLs(ctx, 2, nil, domain.URLOrderCreated, "")
+	if _, err := service.ListURLs(ctx, 2, nil, domain.URLOrderClicks, first.NextCursor); err != ErrInvalidCursor {
+		t.Errorf("expected cursor of another order to be rejected, got %v", err)
+	}
+	if _, err := service.ListURLs(ctx, 2, nil, domain.URLOrderCreated, "not-a-cursor"); err != ErrInvalidCursor {
+		t.Errorf("expected invalid cursor error, got %v", err)
+	}
+	if _, err := service.ListURLs(ctx, 2, nil, "random", ""); err != ErrInvalidOrder {
+		t.Errorf("expected invalid order error, got %v", err)
+	}
+}
+
+func TestSearchURLs(t *testing.T) {
+	urlStore := NewMockURLStore()
+	analyticsStore := NewMockAnalyticsStore()
+	cacheClient := &cache.NoOpCache{}
+
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil)
+	ctx := context.Background()
+
+	for _, req := range []*domain.CreateURLRequest{
+		{URL: "https://example.com/pricing", CustomAlias: stringPtr("pricing")},
+		{URL: "https://example.com/docs/getting-started", CustomAlias: stringPtr("docs")},
+	} {
+		if _, err := service.CreateShortURL(ctx, req); err != nil {
+			t.Fatalf("Failed to create URL: %v", err)
+		}
+	}
+
+	urls, err := service.SearchURLs(ctx, "  getting-started ", 10)
+	if err != nil {
+		t.Fatalf("SearchURLs failed: %v", err)
+	}
+	if len(urls) != 1 || urls[0].ShortCode != "docs" {
+		t.Errorf("expected only docs to match, got %v", urls)
+	}
+
+	if _, err := service.SearchURLs(ctx, "   ", 10); err != ErrEmptyQuery {
+		t.Errorf("expected empty query error, got %v", err)
+	}
+	if _, err := service.SearchURLs(ctx, strings.Repeat("a", maxSearchQueryLength+1), 10); err != ErrQueryTooLong {
+		t.Errorf("expected query too long error, got %v", err)
+	}
+}
+
 func TestValidateURL(t *testing.T) {
 	urlStore := NewMockURLStore()
 	analyticsStore := NewMockAnalyticsStore()
 	cacheClient := &cache.NoOpCache{}
 
-	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient).(*shortenerService)
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil).(*shortenerService)
 
 	tests := []struct {
 		name    string
@@ -308,7 +2139,7 @@ func TestValidateShortCode(t *testing.T) {
 	analyticsStore := NewMockAnalyticsStore()
 	cacheClient := &cache.NoOpCache{}
 
-	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient).(*shortenerService)
+	service := NewShortenerService(urlStore, "http://localhost:8080", 6, analyticsStore, cacheClient, false, false, nil, nil, nil).(*shortenerService)
 
 	tests := []struct {
 		name    string
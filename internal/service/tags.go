@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+)
+
+const (
+	maxTagLength  = 50
+	maxTagsPerURL = 20
+)
+
+// normalizeTags trims and lowercases tags and drops duplicates, keeping the
+// order in which they were given.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > maxTagLength {
+			return nil, ErrInvalidTag
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > maxTagsPerURL {
+		return nil, ErrTooManyTags
+	}
+
+	return normalized, nil
+}
+
+func (s *shortenerService) AddTags(ctx context.Context, host, shortCode string, tags []string) (*domain.URL, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+	host = s.linkHost(host)
+
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, ErrInvalidTag
+	}
+
+	url, err := s.getActiveURL(ctx, host, shortCode)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := normalizeTags(append(url.Tags, tags...))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.urlStore.AddTags(ctx, host, shortCode, tags); err != nil {
+		return nil, fmt.Errorf("failed to add tags in store: %w", err)
+	}
+
+	url.Tags = merged
+	s.invalidateCache(ctx, host, shortCode)
+	return url, nil
+}
+
+func (s *shortenerService) RemoveTag(ctx context.Context, host, shortCode, tag string) (*domain.URL, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+	host = s.linkHost(host)
+
+	tags, err := normalizeTags([]string{tag})
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := s.getActiveURL(ctx, host, shortCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.urlStore.RemoveTag(ctx, host, shortCode, tags[0]); err != nil {
+		return nil, fmt.Errorf("failed to remove tag in store: %w", err)
+	}
+
+	remaining := make([]string, 0, len(url.Tags))
+	for _, t := range url.Tags {
+		if t != tags[0] {
+			remaining = append(remaining, t)
+		}
+	}
+	url.Tags = remaining
+
+	s.invalidateCache(ctx, host, shortCode)
+	return url, nil
+}
+
+func (s *shortenerService) GetTagCounts(ctx context.Context) ([]domain.TagCount, error) {
+	counts, err := s.urlStore.GetTagCounts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tag counts: %w", err)
+	}
+
+	return counts, nil
+}
+
+// getActiveURL loads a link from the store, treating tombstones as missing
+func (s *shortenerService) getActiveURL(ctx context.Context, host, shortCode string) (*domain.URL, error) {
+	url, err := s.urlStore.GetURLByShortCode(ctx, host, shortCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get url from store: %w", err)
+	}
+	if url.IsDeleted() {
+		return nil, ErrURLNotFound
+	}
+
+	return url, nil
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+)
+
+const maxTargetingRules = 20
+
+// validateTargeting normalizes targeting rules and checks their conditions
+// and destinations.
+func (s *shortenerService) validateTargeting(rules []domain.TargetingRule) ([]domain.TargetingRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if len(rules) > maxTargetingRules {
+		return nil, ErrTooManyTargetingRules
+	}
+
+	normalized := make([]domain.TargetingRule, len(rules))
+	for i, rule := range rules {
+		rule.Device = strings.ToLower(strings.TrimSpace(rule.Device))
+		rule.OS = strings.ToLower(strings.TrimSpace(rule.OS))
+		rule.Language = strings.ToLower(strings.TrimSpace(rule.Language))
+		if !rule.IsValid() {
+			return nil, ErrInvalidTargetingRule
+		}
+		if err := s.validateURL(rule.URL); err != nil {
+			return nil, ErrInvalidTargetingRule
+		}
+		normalized[i] = rule
+	}
+
+	return normalized, nil
+}
+
+// SetTargeting replaces the targeting rules of a link. An empty list removes
+// targeting so that every visitor goes to the original URL.
+func (s *shortenerService) SetTargeting(ctx context.Context, host, shortCode string, rules []domain.TargetingRule) (*domain.URL, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+	host = s.linkHost(host)
+
+	rules, err := s.validateTargeting(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := s.getActiveURL(ctx, host, shortCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.urlStore.SetTargetingRules(ctx, host, shortCode, rules); err != nil {
+		return nil, fmt.Errorf("failed to set targeting rules in store: %w", err)
+	}
+
+	url.Targeting = rules
+	s.invalidateCache(ctx, host, shortCode)
+	return url, nil
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+)
+
+const (
+	minVariants      = 2
+	maxVariants      = 10
+	maxVariantWeight = 1000
+)
+
+var variantNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,50}$`)
+
+// validateVariants checks split-test variants. Unnamed variants are named
+// after their position.
+func (s *shortenerService) validateVariants(variants []domain.Variant) ([]domain.Variant, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) < minVariants {
+		return nil, ErrInvalidVariant
+	}
+	if len(variants) > maxVariants {
+		return nil, ErrTooManyVariants
+	}
+
+	seen := make(map[string]bool, len(variants))
+	normalized := make([]domain.Variant, len(variants))
+	for i, variant := range variants {
+		variant.Name = strings.TrimSpace(variant.Name)
+		if variant.Name == "" {
+			variant.Name = fmt.Sprintf("v%d", i+1)
+		}
+		if !variantNamePattern.MatchString(variant.Name) || seen[variant.Name] {
+			return nil, ErrInvalidVariant
+		}
+		if variant.Weight <= 0 || variant.Weight > maxVariantWeight {
+			return nil, ErrInvalidVariant
+		}
+		if err := s.validateURL(variant.URL); err != nil {
+			return nil, ErrInvalidVariant
+		}
+		seen[variant.Name] = true
+		normalized[i] = variant
+	}
+
+	return normalized, nil
+}
+
+// SetVariants replaces the split-test variants of a link. An empty list
+// sends all traffic to the original URL again.
+func (s *shortenerService) SetVariants(ctx context.Context, host, shortCode string, variants []domain.Variant, sticky bool) (*domain.URL, error) {
+	if shortCode == "" {
+		return nil, ErrInvalidShortCode
+	}
+	host = s.linkHost(host)
+
+	variants, err := s.validateVariants(variants)
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := s.getActiveURL(ctx, host, shortCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.urlStore.SetVariants(ctx, host, shortCode, variants, sticky); err != nil {
+		return nil, fmt.Errorf("failed to set variants in store: %w", err)
+	}
+
+	url.Variants = variants
+	url.StickyVariants = sticky
+	s.invalidateCache(ctx, host, shortCode)
+	return url, nil
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/store"
+)
+
+// VisitorCounter counts unique visitors of links. A visitor is identified
+// by a keyed hash of their IP address and User-Agent; the salt keeps the
+// fingerprints from being matched against known addresses.
+type VisitorCounter struct {
+	store store.VisitorStore
+	salt  []byte
+}
+
+func NewVisitorCounter(visitorStore store.VisitorStore, salt string) *VisitorCounter {
+	return &VisitorCounter{
+		store: visitorStore,
+		salt:  []byte(salt),
+	}
+}
+
+// Fingerprint returns the visitor fingerprint for ip and userAgent
+func (v *VisitorCounter) Fingerprint(ip, userAgent string) string {
+	mac := hmac.New(sha256.New, v.salt)
+	mac.Write([]byte(ip))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userAgent))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Add counts the visitors of events that carry a fingerprint
+func (v *VisitorCounter) Add(ctx context.Context, events []*domain.ClickEvent) error {
+	return v.store.AddVisitors(ctx, events)
+}
+
+// Count estimates the unique visitors of a link over all time, in each of
+// the last days days and in each of the last months months, up to now.
+func (v *VisitorCounter) Count(ctx context.Context, host, shortCode string, days, months int, now time.Time) (*domain.UniqueVisitors, error) {
+	now = now.UTC()
+
+	dayKeys := make([]string, days)
+	for i := range dayKeys {
+		dayKeys[i] = now.AddDate(0, 0, -i).Format(domain.VisitorDayLayout)
+	}
+
+	// Step back from the middle of the month so AddDate never skips one
+	month := time.Date(now.Year(), now.Month(), 15, 0, 0, 0, 0, time.UTC)
+	monthKeys := make([]string, months)
+	for i := range monthKeys {
+		monthKeys[i] = month.AddDate(0, -i, 0).Format(domain.VisitorMonthLayout)
+	}
+
+	return v.store.CountVisitors(ctx, host, shortCode, dayKeys, monthKeys)
+}
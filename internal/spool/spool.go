@@ -0,0 +1,440 @@
+// Package spool implements a small write-ahead log on local disk. Records
+// are appended to numbered segment files, each framed with its length and a
+// CRC32 checksum, and are replayed oldest first.
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/logger"
+)
+
+const (
+	segmentExt = ".seg"
+	tmpExt     = ".tmp"
+
+	// headerSize is the length and checksum in front of every record
+	headerSize = 8
+
+	// defaultSegmentSize is the size after which a new segment is started
+	defaultSegmentSize = 4 << 20
+)
+
+var (
+	// ErrFull is returned by Append when the record would not fit into the
+	// size limit of the spool
+	ErrFull = errors.New("spool is full")
+
+	// ErrClosed is returned by Append after Close
+	ErrClosed = errors.New("spool is closed")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type segment struct {
+	seq  uint64
+	size int64
+}
+
+// Spool is a segmented on-disk log of opaque records. It is safe for
+// concurrent use.
+type Spool struct {
+	dir         string
+	maxSize     int64
+	segmentSize int64
+
+	mu       sync.Mutex
+	segments []segment
+	// active is the segment records are appended to. It is always the last
+	// of segments, or nil if all segments are sealed.
+	active  *os.File
+	nextSeq uint64
+	size    int64
+	closed  bool
+
+	// replayMu makes sure only one Replay runs at a time
+	replayMu sync.Mutex
+}
+
+// Open opens the spool in dir, creating the directory if needed. maxSize
+// caps the total size of all segments in bytes; zero means no limit.
+//
+// Segments left by a previous run are recovered: a torn or corrupted tail,
+// as left by a crash in the middle of a write, is cut off so the records
+// before it can still be replayed.
+func Open(dir string, maxSize int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &Spool{
+		dir:         dir,
+		maxSize:     maxSize,
+		segmentSize: defaultSegmentSize,
+		nextSeq:     1,
+	}
+
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// recover loads the segments found in the directory
+func (s *Spool) recover() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, tmpExt) {
+			// Left over from a replay that crashed before the rename; the
+			// original segment is still in place.
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", name, err)
+			}
+			continue
+		}
+
+		seq, ok := parseSegmentName(name)
+		if !ok {
+			continue
+		}
+
+		size, err := s.recoverSegment(seq)
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			continue
+		}
+
+		s.segments = append(s.segments, segment{seq: seq, size: size})
+		s.size += size
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].seq < s.segments[j].seq
+	})
+	if n := len(s.segments); n > 0 {
+		s.nextSeq = s.segments[n-1].seq + 1
+	}
+
+	return nil
+}
+
+// recoverSegment checks every record of a segment and truncates it at the
+// first invalid one. Empty segments are removed. It returns the size of the
+// valid part.
+func (s *Spool) recoverSegment(seq uint64) (int64, error) {
+	path := s.segmentPath(seq)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read segment %d: %w", seq, err)
+	}
+
+	_, valid := scan(data)
+	if valid == 0 {
+		if err := os.Remove(path); err != nil {
+			return 0, fmt.Errorf("failed to remove segment %d: %w", seq, err)
+		}
+		if len(data) > 0 {
+			logger.Warn("Dropped corrupted spool segment", "segment", seq, "bytes", len(data))
+		}
+		return 0, nil
+	}
+
+	if valid < len(data) {
+		logger.Warn("Truncated corrupted spool segment", "segment", seq, "lost_bytes", len(data)-valid)
+		if err := os.Truncate(path, int64(valid)); err != nil {
+			return 0, fmt.Errorf("failed to truncate segment %d: %w", seq, err)
+		}
+	}
+
+	return int64(valid), nil
+}
+
+// Append writes record to the end of the spool and syncs it to disk
+func (s *Spool) Append(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	n := int64(headerSize + len(record))
+	if s.maxSize > 0 && s.size+n > s.maxSize {
+		return ErrFull
+	}
+
+	last := len(s.segments) - 1
+	if s.active == nil || (s.segments[last].size > 0 && s.segments[last].size+n > s.segmentSize) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		last = len(s.segments) - 1
+	}
+
+	buf := make([]byte, n)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(record)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(record, crcTable))
+	copy(buf[headerSize:], record)
+
+	if _, err := s.active.Write(buf); err != nil {
+		// Cut off whatever part of the record made it to the file, so the
+		// next record does not land behind a torn one.
+		_ = s.active.Truncate(s.segments[last].size)
+		_, _ = s.active.Seek(s.segments[last].size, io.SeekStart)
+		return fmt.Errorf("failed to write spool record: %w", err)
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool segment: %w", err)
+	}
+
+	s.segments[last].size += n
+	s.size += n
+
+	return nil
+}
+
+// rotate seals the active segment and starts a new one
+func (s *Spool) rotate() error {
+	if err := s.seal(); err != nil {
+		return err
+	}
+
+	seq := s.nextSeq
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+
+	s.nextSeq++
+	s.active = f
+	s.segments = append(s.segments, segment{seq: seq})
+
+	return nil
+}
+
+// seal closes the active segment so that it can be replayed
+func (s *Spool) seal() error {
+	if s.active == nil {
+		return nil
+	}
+
+	err := s.active.Close()
+	s.active = nil
+
+	// A segment that never got a record is not worth keeping
+	last := len(s.segments) - 1
+	if s.segments[last].size == 0 {
+		_ = os.Remove(s.segmentPath(s.segments[last].seq))
+		s.segments = s.segments[:last]
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to close spool segment: %w", err)
+	}
+	return nil
+}
+
+// Replay passes the spooled records to fn in the order they were appended
+// and removes each record once fn has accepted it. It stops at the first
+// error of fn and returns it; that record and the ones after it stay in the
+// spool for the next Replay. Records appended while Replay runs are left for
+// the next call as well.
+func (s *Spool) Replay(fn func(record []byte) error) (int, error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	s.mu.Lock()
+	if err := s.seal(); err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	sealed := make([]segment, len(s.segments))
+	copy(sealed, s.segments)
+	s.mu.Unlock()
+
+	replayed := 0
+	for _, seg := range sealed {
+		n, err := s.replaySegment(seg, fn)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+	}
+
+	return replayed, nil
+}
+
+// replaySegment replays one sealed segment. When fn fails part way through,
+// the records already replayed are cut off the front of the segment.
+func (s *Spool) replaySegment(seg segment, fn func(record []byte) error) (int, error) {
+	path := s.segmentPath(seg.seq)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read segment %d: %w", seg.seq, err)
+	}
+
+	records, _ := scan(data)
+
+	offset := 0
+	for i, record := range records {
+		if err := fn(record); err != nil {
+			if offset > 0 {
+				if terr := s.trimSegment(seg.seq, data[offset:]); terr != nil {
+					return i, terr
+				}
+			}
+			return i, err
+		}
+		offset += headerSize + len(record)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return len(records), fmt.Errorf("failed to remove segment %d: %w", seg.seq, err)
+	}
+
+	s.mu.Lock()
+	for i := range s.segments {
+		if s.segments[i].seq == seg.seq {
+			s.size -= s.segments[i].size
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	return len(records), nil
+}
+
+// trimSegment replaces a sealed segment with its remaining records
+func (s *Spool) trimSegment(seq uint64, rest []byte) error {
+	path := s.segmentPath(seq)
+	tmp := path + tmpExt
+
+	if err := writeFileSync(tmp, rest); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write segment %d: %w", seq, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to replace segment %d: %w", seq, err)
+	}
+
+	s.mu.Lock()
+	for i := range s.segments {
+		if s.segments[i].seq == seq {
+			s.size -= s.segments[i].size - int64(len(rest))
+			s.segments[i].size = int64(len(rest))
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Pending reports whether the spool holds records that were not replayed yet
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size > 0
+}
+
+// Size returns the total size of the spooled records in bytes
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Close seals the active segment. Spooled records stay on disk and are
+// recovered by the next Open.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	return s.seal()
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d%s", seq, segmentExt))
+}
+
+func parseSegmentName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, segmentExt) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+// scan splits segment data into records. It stops at the first record that
+// is truncated or fails its checksum and returns the length of the valid
+// prefix of data.
+func scan(data []byte) ([][]byte, int) {
+	var records [][]byte
+
+	offset := 0
+	for len(data)-offset >= headerSize {
+		length := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
+		sum := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+
+		start := offset + headerSize
+		if length > len(data)-start {
+			break
+		}
+
+		record := data[start : start+length]
+		if crc32.Checksum(record, crcTable) != sum {
+			break
+		}
+
+		records = append(records, record)
+		offset = start + length
+	}
+
+	return records, offset
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
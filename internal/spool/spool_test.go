@@ -0,0 +1,168 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func collect(t *testing.T, s *Spool) []string {
+	t.Helper()
+
+	var records []string
+	if _, err := s.Replay(func(record []byte) error {
+		records = append(records, string(record))
+		return nil
+	}); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	return records
+}
+
+func TestSpoolReplayInOrder(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	s.segmentSize = 32
+
+	var want []string
+	for i := 0; i < 10; i++ {
+		record := fmt.Sprintf("record-%d", i)
+		want = append(want, record)
+		if err := s.Append([]byte(record)); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	if len(s.segments) < 2 {
+		t.Fatalf("expected records to be split across segments, got %d", len(s.segments))
+	}
+
+	if got := collect(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if s.Pending() || s.Size() != 0 {
+		t.Errorf("expected empty spool after replay, size %d", s.Size())
+	}
+
+	entries, _ := os.ReadDir(s.dir)
+	if len(entries) != 0 {
+		t.Errorf("expected replayed segments to be removed, found %d files", len(entries))
+	}
+}
+
+func TestSpoolReplayStopsAtError(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	for _, record := range []string{"a", "b", "c"} {
+		if err := s.Append([]byte(record)); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	failure := errors.New("database is down")
+	var seen []string
+	n, err := s.Replay(func(record []byte) error {
+		if string(record) == "b" {
+			return failure
+		}
+		seen = append(seen, string(record))
+		return nil
+	})
+	if err != failure || n != 1 {
+		t.Fatalf("expected 1 record and the callback error, got %d, %v", n, err)
+	}
+
+	if err := s.Append([]byte("d")); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	want := []string{"b", "c", "d"}
+	if got := collect(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after reopening, got %v", want, got)
+	}
+}
+
+func TestSpoolSizeLimit(t *testing.T) {
+	s, err := Open(t.TempDir(), 2*(headerSize+4))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.Append([]byte("1234")); err != nil {
+			t.Fatalf("Append %d failed: %v", i, err)
+		}
+	}
+	if err := s.Append([]byte("1234")); err != ErrFull {
+		t.Errorf("expected ErrFull, got %v", err)
+	}
+
+	collect(t, s)
+	if err := s.Append([]byte("1234")); err != nil {
+		t.Errorf("expected room after replay, got %v", err)
+	}
+}
+
+func TestSpoolRecoversTornSegment(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	for _, record := range []string{"first", "second", "third"} {
+		if err := s.Append([]byte(record)); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	path := s.segmentPath(s.segments[0].seq)
+	s.Close()
+
+	// Flip a byte in the last record and leave half a header behind it, as
+	// a crash in the middle of a write would
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	data = append(data, 0x05, 0x00, 0x00)
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		t.Fatalf("failed to corrupt segment: %v", err)
+	}
+	if err := os.WriteFile(path+tmpExt, []byte("partial"), 0o640); err != nil {
+		t.Fatalf("failed to write leftover: %v", err)
+	}
+
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if want := int64(2*headerSize + len("first") + len("second")); s.Size() != want {
+		t.Errorf("expected %d recovered bytes, got %d", want, s.Size())
+	}
+	if _, err := os.Stat(path + tmpExt); !os.IsNotExist(err) {
+		t.Errorf("expected leftover temp file to be removed, got %v", err)
+	}
+
+	if err := s.Append([]byte("fourth")); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	want := []string{"first", "second", "fourth"}
+	if got := collect(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
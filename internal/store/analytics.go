@@ -3,25 +3,41 @@ package store
 import (
 	"context"
 	"fmt"
+	"net/netip"
+	"time"
 
 	"github.com/MyNameIsWhaaat/shortener/internal/domain"
 	"github.com/MyNameIsWhaaat/shortener/internal/logger"
+	"github.com/jackc/pgx/v5"
 )
 
+var clickEventColumns = []string{"domain", "short_code", "user_agent", "ip", "ip_hash", "referer", "variant", "is_bot", "created_at", "browser", "browser_version", "os", "os_version", "device"}
+
 func (s *PostgresStore) SaveClickEvent(ctx context.Context, event *domain.ClickEvent) error {
 	logger.Info("Saving click event", "short_code", event.ShortCode)
 
 	query := `
-        INSERT INTO click_events (short_code, user_agent, ip, referer, created_at)
-        VALUES ($1, $2, $3, $4, $5)
+        INSERT INTO click_events (domain, short_code, user_agent, ip, ip_hash, referer, variant, is_bot, created_at,
+                                  browser, browser_version, os, os_version, device)
+        VALUES ($1, $2, $3, NULLIF($4, '')::inet, NULLIF($5, ''), $6, NULLIF($7, ''), $8, $9,
+                NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''), NULLIF($14, ''))
     `
 
 	_, err := s.db.Exec(ctx, query,
+		event.Domain,
 		event.ShortCode,
 		event.UserAgent,
 		event.IP,
+		event.IPHash,
 		event.Referer,
+		event.Variant,
+		event.IsBot,
 		event.CreatedAt,
+		event.Browser,
+		event.BrowserVersion,
+		event.OS,
+		event.OSVersion,
+		event.Device,
 	)
 
 	if err != nil {
@@ -33,13 +49,87 @@ func (s *PostgresStore) SaveClickEvent(ctx context.Context, event *domain.ClickE
 	return nil
 }
 
-func (s *PostgresStore) GetAnalytics(ctx context.Context, shortCode string) (*domain.AnalyticsResponse, error) {
-	url, err := s.GetURLByShortCode(ctx, shortCode)
+// SaveClickEvents writes a batch of click events with COPY and adds counts
+// to the click counters of their links in the same transaction.
+func (s *PostgresStore) SaveClickEvents(ctx context.Context, events []*domain.ClickEvent, counts []domain.ClickCount) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"click_events"}, clickEventColumns,
+		pgx.CopyFromSlice(len(events), func(i int) ([]any, error) {
+			event := events[i]
+			return []any{
+				event.Domain,
+				event.ShortCode,
+				event.UserAgent,
+				clickIP(event.IP),
+				nullIfEmpty(event.IPHash),
+				event.Referer,
+				nullIfEmpty(event.Variant),
+				event.IsBot,
+				event.CreatedAt,
+				nullIfEmpty(event.Browser),
+				nullIfEmpty(event.BrowserVersion),
+				nullIfEmpty(event.OS),
+				nullIfEmpty(event.OSVersion),
+				nullIfEmpty(event.Device),
+			}, nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to copy click events: %w", err)
+	}
+
+	if len(counts) > 0 {
+		var (
+			hosts  = make([]string, len(counts))
+			codes  = make([]string, len(counts))
+			clicks = make([]int64, len(counts))
+		)
+		for i, count := range counts {
+			hosts[i], codes[i], clicks[i] = count.Domain, count.ShortCode, count.Clicks
+		}
+
+		query := `
+            UPDATE urls
+            SET clicks = urls.clicks + c.clicks
+            FROM unnest($1::varchar[], $2::varchar[], $3::bigint[]) AS c(domain, short_code, clicks)
+            WHERE urls.domain = c.domain AND urls.short_code = c.short_code
+        `
+		if _, err := tx.Exec(ctx, query, hosts, codes, clicks); err != nil {
+			return fmt.Errorf("failed to update click counters: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit click events: %w", err)
+	}
+
+	return nil
+}
+
+// clickIP converts an address for the inet column. COPY uses the binary
+// format, so unparsable addresses are stored as NULL rather than failing
+// the whole batch.
+func clickIP(ip string) any {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	return addr
+}
+
+func (s *PostgresStore) GetAnalytics(ctx context.Context, host, shortCode string) (*domain.AnalyticsResponse, error) {
+	url, err := s.GetURLByShortCode(ctx, host, shortCode)
 	if err != nil {
 		return nil, err
 	}
 
 	response := &domain.AnalyticsResponse{
+		Domain:       url.Domain,
 		ShortCode:    url.ShortCode,
 		OriginalURL:  url.OriginalURL,
 		CreatedAt:    url.CreatedAt,
@@ -47,25 +137,48 @@ func (s *PostgresStore) GetAnalytics(ctx context.Context, shortCode string) (*do
 		DailyStats:   map[string]int64{},
 		MonthlyStats: map[string]int64{},
 		Devices:      map[string]int64{},
+		Browsers:     map[string]int64{},
+		OS:           map[string]int64{},
 		RecentClicks: []domain.ClickEvent{},
 	}
 
-	if response.DailyStats, err = s.GetDailyStats(ctx, shortCode, 30); err != nil {
+	if response.DailyStats, err = s.GetDailyStats(ctx, host, shortCode, 30); err != nil {
 		logger.Error("GetDailyStats failed", "short_code", shortCode, "error", err)
 		response.DailyStats = map[string]int64{}
 	}
 
-	if response.MonthlyStats, err = s.GetMonthlyStats(ctx, shortCode, 12); err != nil {
+	if response.MonthlyStats, err = s.GetMonthlyStats(ctx, host, shortCode, 12); err != nil {
 		logger.Error("GetMonthlyStats failed", "short_code", shortCode, "error", err)
 		response.MonthlyStats = map[string]int64{}
 	}
 
-	if response.Devices, err = s.GetDeviceStats(ctx, shortCode); err != nil {
+	if response.Devices, err = s.GetDeviceStats(ctx, host, shortCode); err != nil {
 		logger.Error("GetDeviceStats failed", "short_code", shortCode, "error", err)
 		response.Devices = map[string]int64{}
 	}
 
-	if response.RecentClicks, err = s.GetRecentClicks(ctx, shortCode, 10); err != nil {
+	if response.Browsers, err = s.GetBrowserStats(ctx, host, shortCode, false); err != nil {
+		logger.Error("GetBrowserStats failed", "short_code", shortCode, "error", err)
+		response.Browsers = map[string]int64{}
+	}
+
+	if response.OS, err = s.GetOSStats(ctx, host, shortCode, false); err != nil {
+		logger.Error("GetOSStats failed", "short_code", shortCode, "error", err)
+		response.OS = map[string]int64{}
+	}
+
+	if len(url.Variants) > 0 {
+		if response.Variants, err = s.GetVariantStats(ctx, host, shortCode); err != nil {
+			logger.Error("GetVariantStats failed", "short_code", shortCode, "error", err)
+			response.Variants = map[string]int64{}
+		}
+	}
+
+	if response.BotClicks, err = s.countBotClicks(ctx, host, shortCode); err != nil {
+		logger.Error("countBotClicks failed", "short_code", shortCode, "error", err)
+	}
+
+	if response.RecentClicks, err = s.GetRecentClicks(ctx, host, shortCode, 10); err != nil {
 		logger.Error("GetRecentClicks failed", "short_code", shortCode, "error", err)
 		response.RecentClicks = []domain.ClickEvent{}
 	}
@@ -73,17 +186,18 @@ func (s *PostgresStore) GetAnalytics(ctx context.Context, shortCode string) (*do
 	return response, nil
 }
 
-func (s *PostgresStore) GetDailyStats(ctx context.Context, shortCode string, days int) (map[string]int64, error) {
+func (s *PostgresStore) GetDailyStats(ctx context.Context, host, shortCode string, days int) (map[string]int64, error) {
 	query := `
         SELECT TO_CHAR(created_at::timestamp, 'YYYY-MM-DD') AS day, COUNT(*)::bigint
         FROM click_events
-        WHERE short_code = $1
+        WHERE domain = $1 AND short_code = $2
+          AND NOT is_bot
           AND created_at >= NOW() - INTERVAL '30 days'
         GROUP BY TO_CHAR(created_at::timestamp, 'YYYY-MM-DD')
         ORDER BY day DESC
     `
 
-	rows, err := s.db.Query(ctx, query, shortCode)
+	rows, err := s.db.Query(ctx, query, host, shortCode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get daily stats: %w", err)
 	}
@@ -106,17 +220,18 @@ func (s *PostgresStore) GetDailyStats(ctx context.Context, shortCode string, day
 	return stats, nil
 }
 
-func (s *PostgresStore) GetMonthlyStats(ctx context.Context, shortCode string, months int) (map[string]int64, error) {
+func (s *PostgresStore) GetMonthlyStats(ctx context.Context, host, shortCode string, months int) (map[string]int64, error) {
 	query := `
         SELECT TO_CHAR(created_at::timestamp, 'YYYY-MM') AS month, COUNT(*)::bigint
         FROM click_events
-        WHERE short_code = $1
+        WHERE domain = $1 AND short_code = $2
+          AND NOT is_bot
           AND created_at >= NOW() - INTERVAL '12 months'
         GROUP BY TO_CHAR(created_at::timestamp, 'YYYY-MM')
         ORDER BY month DESC
     `
 
-	rows, err := s.db.Query(ctx, query, shortCode)
+	rows, err := s.db.Query(ctx, query, host, shortCode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get monthly stats: %w", err)
 	}
@@ -139,22 +254,23 @@ func (s *PostgresStore) GetMonthlyStats(ctx context.Context, shortCode string, m
 	return stats, nil
 }
 
-func (s *PostgresStore) GetDeviceStats(ctx context.Context, shortCode string) (map[string]int64, error) {
+// GetDeviceStats counts human clicks by device class. Clicks stored before
+// user agents were parsed at ingestion are classified by their user agent.
+func (s *PostgresStore) GetDeviceStats(ctx context.Context, host, shortCode string) (map[string]int64, error) {
 	query := `
         SELECT
-            CASE
-                WHEN user_agent ILIKE '%mobile%' OR user_agent ILIKE '%android%' OR user_agent ILIKE '%iphone%' THEN 'Mobile'
-                WHEN user_agent ILIKE '%tablet%' OR user_agent ILIKE '%ipad%' THEN 'Tablet'
-                WHEN user_agent ILIKE '%bot%' OR user_agent ILIKE '%crawler%' THEN 'Bot'
-                ELSE 'Desktop'
-            END as device_type,
+            COALESCE(device, CASE
+                WHEN user_agent ILIKE '%ipad%' OR user_agent ILIKE '%tablet%' THEN 'tablet'
+                WHEN user_agent ILIKE '%mobi%' OR user_agent ILIKE '%android%' OR user_agent ILIKE '%iphone%' THEN 'mobile'
+                ELSE 'desktop'
+            END) AS device_type,
             COUNT(*)
         FROM click_events
-        WHERE short_code = $1
+        WHERE domain = $1 AND short_code = $2 AND NOT is_bot
         GROUP BY device_type
     `
 
-	rows, err := s.db.Query(ctx, query, shortCode)
+	rows, err := s.db.Query(ctx, query, host, shortCode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get device stats: %w", err)
 	}
@@ -177,16 +293,53 @@ func (s *PostgresStore) GetDeviceStats(ctx context.Context, shortCode string) (m
 	return stats, nil
 }
 
-func (s *PostgresStore) GetRecentClicks(ctx context.Context, shortCode string, limit int) ([]domain.ClickEvent, error) {
+// GetBrowserStats counts human clicks by browser family, or by family and
+// major version when versions is set, e.g. "chrome 126".
+func (s *PostgresStore) GetBrowserStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error) {
+	stats := make(map[string]int64)
+	if err := s.collectCounts(ctx, clientStatsQuery("browser", versions), host, shortCode, stats); err != nil {
+		return nil, fmt.Errorf("failed to get browser stats: %w", err)
+	}
+	return stats, nil
+}
+
+// GetOSStats counts human clicks by operating system, or by system and
+// version when versions is set, e.g. "android 14".
+func (s *PostgresStore) GetOSStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error) {
+	stats := make(map[string]int64)
+	if err := s.collectCounts(ctx, clientStatsQuery("os", versions), host, shortCode, stats); err != nil {
+		return nil, fmt.Errorf("failed to get os stats: %w", err)
+	}
+	return stats, nil
+}
+
+// clientStatsQuery groups human clicks by one of the parsed user agent
+// columns, optionally followed by its version column. Clicks whose client
+// was not recognized, and those stored before parsing, count as "unknown".
+func clientStatsQuery(column string, versions bool) string {
+	key := fmt.Sprintf("COALESCE(%s, 'unknown')", column)
+	if versions {
+		key = fmt.Sprintf("%s || COALESCE(' ' || %s_version, '')", key, column)
+	}
+
+	return fmt.Sprintf(`
+        SELECT %s, COUNT(*)::bigint
+        FROM click_events
+        WHERE domain = $1 AND short_code = $2 AND NOT is_bot
+        GROUP BY 1
+    `, key)
+}
+
+func (s *PostgresStore) GetRecentClicks(ctx context.Context, host, shortCode string, limit int) ([]domain.ClickEvent, error) {
 	query := `
-        SELECT user_agent, ip::text, COALESCE(referer, ''), created_at
+        SELECT user_agent, COALESCE(host(ip), ''), COALESCE(ip_hash, ''), COALESCE(referer, ''), COALESCE(variant, ''), created_at
         FROM click_events
-        WHERE short_code = $1
+        WHERE domain = $1 AND short_code = $2 AND NOT is_bot
         ORDER BY created_at DESC
-        LIMIT $2
+        LIMIT $3
     `
 
-	rows, err := s.db.Query(ctx, query, shortCode, limit)
+	rows, err := s.db.Query(ctx, query, host, shortCode, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get recent clicks: %w", err)
 	}
@@ -195,12 +348,15 @@ func (s *PostgresStore) GetRecentClicks(ctx context.Context, shortCode string, l
 	clicks := make([]domain.ClickEvent, 0)
 	for rows.Next() {
 		var event domain.ClickEvent
+		event.Domain = host
 		event.ShortCode = shortCode
 
 		if err := rows.Scan(
 			&event.UserAgent,
 			&event.IP,
+			&event.IPHash,
 			&event.Referer,
+			&event.Variant,
 			&event.CreatedAt,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan recent click: %w", err)
@@ -215,3 +371,164 @@ func (s *PostgresStore) GetRecentClicks(ctx context.Context, shortCode string, l
 
 	return clicks, nil
 }
+
+func (s *PostgresStore) countBotClicks(ctx context.Context, host, shortCode string) (int64, error) {
+	query := `SELECT COUNT(*)::bigint FROM click_events WHERE domain = $1 AND short_code = $2 AND is_bot`
+
+	var count int64
+	if err := s.db.QueryRow(ctx, query, host, shortCode).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count bot clicks: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetBotStats returns the clicks classified as automated traffic: their total,
+// daily counts for the last 30 days and the most frequent user agents.
+func (s *PostgresStore) GetBotStats(ctx context.Context, host, shortCode string) (*domain.BotStats, error) {
+	if _, err := s.GetURLByShortCode(ctx, host, shortCode); err != nil {
+		return nil, err
+	}
+
+	stats := &domain.BotStats{
+		Domain:     host,
+		ShortCode:  shortCode,
+		DailyStats: map[string]int64{},
+		UserAgents: map[string]int64{},
+	}
+
+	var err error
+	if stats.TotalClicks, err = s.countBotClicks(ctx, host, shortCode); err != nil {
+		return nil, err
+	}
+
+	dailyQuery := `
+        SELECT TO_CHAR(created_at::timestamp, 'YYYY-MM-DD') AS day, COUNT(*)::bigint
+        FROM click_events
+        WHERE domain = $1 AND short_code = $2
+          AND is_bot
+          AND created_at >= NOW() - INTERVAL '30 days'
+        GROUP BY day
+    `
+	if err := s.collectCounts(ctx, dailyQuery, host, shortCode, stats.DailyStats); err != nil {
+		return nil, fmt.Errorf("failed to get daily bot stats: %w", err)
+	}
+
+	agentsQuery := `
+        SELECT COALESCE(NULLIF(user_agent, ''), '(empty)'), COUNT(*)::bigint AS clicks
+        FROM click_events
+        WHERE domain = $1 AND short_code = $2 AND is_bot
+        GROUP BY 1
+        ORDER BY clicks DESC
+        LIMIT 10
+    `
+	if err := s.collectCounts(ctx, agentsQuery, host, shortCode, stats.UserAgents); err != nil {
+		return nil, fmt.Errorf("failed to get bot user agents: %w", err)
+	}
+
+	return stats, nil
+}
+
+// collectCounts runs a query returning (key, count) rows into counts
+func (s *PostgresStore) collectCounts(ctx context.Context, query, host, shortCode string, counts map[string]int64) error {
+	rows, err := s.db.Query(ctx, query, host, shortCode)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var count int64
+		if err := rows.Scan(&key, &count); err != nil {
+			return err
+		}
+		counts[key] = count
+	}
+
+	return rows.Err()
+}
+
+// AnonymizeClickIPs rewrites the client addresses of stored clicks with
+// anonymize, which returns the address or hash to keep for an address seen
+// at a given time. Rows are processed batchSize at a time, each batch in its
+// own statement, so an interrupted run can simply be started again. It
+// returns the number of rows changed.
+func (s *PostgresStore) AnonymizeClickIPs(ctx context.Context, batchSize int, anonymize func(ip string, at time.Time) (addr, hash string)) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	selectQuery := `
+        SELECT id, created_at, host(ip)
+        FROM click_events
+        WHERE ip IS NOT NULL AND (created_at, id) > ($1, $2)
+        ORDER BY created_at, id
+        LIMIT $3
+    `
+	updateQuery := `
+        UPDATE click_events c
+        SET ip = NULLIF(k.ip, '')::inet, ip_hash = NULLIF(k.ip_hash, '')
+        FROM unnest($1::bigint[], $2::timestamptz[], $3::text[], $4::text[]) AS k(id, created_at, ip, ip_hash)
+        WHERE c.id = k.id AND c.created_at = k.created_at
+    `
+
+	var (
+		total  int64
+		lastID int64
+		lastAt time.Time
+	)
+	for {
+		rows, err := s.db.Query(ctx, selectQuery, lastAt, lastID, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to read click addresses: %w", err)
+		}
+
+		var (
+			ids    []int64
+			times  []time.Time
+			ips    []string
+			hashes []string
+		)
+		processed := 0
+		for rows.Next() {
+			var (
+				id int64
+				at time.Time
+				ip string
+			)
+			if err := rows.Scan(&id, &at, &ip); err != nil {
+				rows.Close()
+				return total, fmt.Errorf("failed to scan click address: %w", err)
+			}
+			processed++
+			lastID, lastAt = id, at
+
+			addr, hash := anonymize(ip, at)
+			if addr == ip && hash == "" {
+				continue
+			}
+			ids = append(ids, id)
+			times = append(times, at)
+			ips = append(ips, addr)
+			hashes = append(hashes, hash)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, fmt.Errorf("click addresses rows error: %w", err)
+		}
+
+		if len(ids) > 0 {
+			tag, err := s.db.Exec(ctx, updateQuery, ids, times, ips, hashes)
+			if err != nil {
+				return total, fmt.Errorf("failed to anonymize click addresses: %w", err)
+			}
+			total += tag.RowsAffected()
+			logger.Info("Anonymized click addresses", "rows", total)
+		}
+
+		if processed < batchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+)
+
+func (s *PostgresStore) ListDomains(ctx context.Context) ([]domain.ShortDomain, error) {
+	rows, err := s.db.Query(ctx, `SELECT host, created_at FROM domains ORDER BY host`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains: %w", err)
+	}
+	defer rows.Close()
+
+	domains := make([]domain.ShortDomain, 0)
+	for rows.Next() {
+		var d domain.ShortDomain
+		if err := rows.Scan(&d.Host, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan domain: %w", err)
+		}
+		domains = append(domains, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("domains rows error: %w", err)
+	}
+
+	return domains, nil
+}
+
+func (s *PostgresStore) AddDomain(ctx context.Context, host string) (*domain.ShortDomain, error) {
+	d := &domain.ShortDomain{Host: host}
+	err := s.db.QueryRow(ctx,
+		`INSERT INTO domains (host) VALUES ($1) RETURNING created_at`,
+		host,
+	).Scan(&d.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, domain.ErrDomainExists
+		}
+		return nil, fmt.Errorf("failed to add domain: %w", err)
+	}
+
+	return d, nil
+}
+
+// DeleteDomain removes host from the registry. Hosts that still serve
+// active links are kept and reported as domain.ErrDomainInUse.
+func (s *PostgresStore) DeleteDomain(ctx context.Context, host string) error {
+	query := `
+        DELETE FROM domains
+        WHERE host = $1
+          AND NOT EXISTS (SELECT 1 FROM urls WHERE domain = $1 AND deleted_at IS NULL)
+    `
+
+	tag, err := s.db.Exec(ctx, query, host)
+	if err != nil {
+		return fmt.Errorf("failed to delete domain: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	var exists bool
+	if err := s.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM domains WHERE host = $1)`, host).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check domain: %w", err)
+	}
+	if exists {
+		return domain.ErrDomainInUse
+	}
+
+	return domain.ErrDomainNotFound
+}
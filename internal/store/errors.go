@@ -13,3 +13,19 @@ func isUniqueViolation(err error) bool {
 	}
 	return false
 }
+
+// IsPermanentError reports whether err is a database error that retrying
+// the same statement cannot fix: bad data (class 22), a violated constraint
+// such as a missing partition or a deleted link (class 23), or a statement
+// that does not match the schema (class 42).
+func IsPermanentError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || len(pgErr.Code) < 2 {
+		return false
+	}
+	switch pgErr.Code[:2] {
+	case "22", "23", "42":
+		return true
+	}
+	return false
+}
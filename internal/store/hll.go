@@ -0,0 +1,145 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// hllPrecision gives 4096 registers and a standard error of about 1.6%
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+
+	sketchDense  = 1
+	sketchSparse = 2
+)
+
+var errInvalidSketch = errors.New("invalid visitor sketch")
+
+// sketch is a HyperLogLog sketch of visitor fingerprints
+type sketch struct {
+	registers [hllRegisters]uint8
+}
+
+// add counts a fingerprint
+func (s *sketch) add(fingerprint string) {
+	h := hashFingerprint(fingerprint)
+
+	index := h >> (64 - hllPrecision)
+	// The guard bit caps the rank in case the remaining bits are all zero
+	rank := uint8(bits.LeadingZeros64(h<<hllPrecision|1<<(hllPrecision-1))) + 1
+
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+// merge adds the visitors counted by other
+func (s *sketch) merge(other *sketch) {
+	for i, rank := range other.registers {
+		if rank > s.registers[i] {
+			s.registers[i] = rank
+		}
+	}
+}
+
+// estimate returns the approximate number of distinct fingerprints
+func (s *sketch) estimate() int64 {
+	const m = float64(hllRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	sum, zeros := 0.0, 0
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return int64(math.Round(estimate))
+}
+
+// marshal encodes the sketch for the sketch column. Sketches of links with
+// few visitors are stored as a list of the registers that are set.
+func (s *sketch) marshal() []byte {
+	set := 0
+	for _, rank := range s.registers {
+		if rank != 0 {
+			set++
+		}
+	}
+
+	if set*3 >= hllRegisters {
+		data := make([]byte, 1+hllRegisters)
+		data[0] = sketchDense
+		copy(data[1:], s.registers[:])
+		return data
+	}
+
+	data := make([]byte, 1, 1+set*3)
+	data[0] = sketchSparse
+	for i, rank := range s.registers {
+		if rank != 0 {
+			data = binary.BigEndian.AppendUint16(data, uint16(i))
+			data = append(data, rank)
+		}
+	}
+	return data
+}
+
+// unmarshalSketch decodes a sketch written by marshal. Empty data is an
+// empty sketch.
+func unmarshalSketch(data []byte) (*sketch, error) {
+	s := &sketch{}
+	if len(data) == 0 {
+		return s, nil
+	}
+
+	switch data[0] {
+	case sketchDense:
+		if len(data) != 1+hllRegisters {
+			return nil, errInvalidSketch
+		}
+		copy(s.registers[:], data[1:])
+	case sketchSparse:
+		if (len(data)-1)%3 != 0 {
+			return nil, errInvalidSketch
+		}
+		for i := 1; i < len(data); i += 3 {
+			index := binary.BigEndian.Uint16(data[i : i+2])
+			if int(index) >= hllRegisters {
+				return nil, errInvalidSketch
+			}
+			s.registers[index] = data[i+2]
+		}
+	default:
+		return nil, errInvalidSketch
+	}
+
+	return s, nil
+}
+
+// hashFingerprint hashes a fingerprint to 64 well mixed bits. FNV-1a alone
+// leaves the high bits, which pick the register, poorly distributed, so the
+// result goes through the MurmurHash3 finalizer.
+func hashFingerprint(fingerprint string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(fingerprint))
+	h := f.Sum64()
+
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStore) ReserveIdempotencyKey(ctx context.Context, key, requestHash string, ttl time.Duration) (bool, error) {
+	// An expired key is taken over in place
+	query := `
+        INSERT INTO idempotency_keys (key, request_hash, created_at, expires_at)
+        VALUES ($1, $2, NOW(), NOW() + make_interval(secs => $3))
+        ON CONFLICT (key) DO UPDATE
+        SET request_hash = EXCLUDED.request_hash,
+            status_code = NULL,
+            body = NULL,
+            created_at = EXCLUDED.created_at,
+            expires_at = EXCLUDED.expires_at
+        WHERE idempotency_keys.expires_at <= NOW()
+    `
+
+	tag, err := s.db.Exec(ctx, query, key, requestHash, ttl.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *PostgresStore) GetIdempotencyRecord(ctx context.Context, key string) (*domain.IdempotencyRecord, error) {
+	query := `
+        SELECT request_hash, COALESCE(status_code, 0), COALESCE(body, ''::bytea)
+        FROM idempotency_keys
+        WHERE key = $1 AND expires_at > NOW()
+    `
+
+	var record domain.IdempotencyRecord
+	err := s.db.QueryRow(ctx, query, key).Scan(
+		&record.RequestHash,
+		&record.StatusCode,
+		&record.Body,
+	)
+
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency record: %w", err)
+	}
+
+	return &record, nil
+}
+
+func (s *PostgresStore) SaveIdempotencyRecord(ctx context.Context, key string, record *domain.IdempotencyRecord, ttl time.Duration) error {
+	query := `
+        UPDATE idempotency_keys
+        SET status_code = $2, body = $3, expires_at = NOW() + make_interval(secs => $4)
+        WHERE key = $1 AND request_hash = $5
+    `
+
+	_, err := s.db.Exec(ctx, query, key, record.StatusCode, record.Body, ttl.Seconds(), record.RequestHash)
+	if err != nil {
+		return fmt.Errorf("failed to save idempotency record: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeExpiredIdempotencyKeys deletes the keys whose TTL has run out. Expired
+// keys are otherwise only taken over when a client sends them again. It
+// returns the number of keys deleted.
+func (s *PostgresStore) PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	query := `DELETE FROM idempotency_keys WHERE expires_at <= NOW()`
+
+	tag, err := s.db.Exec(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (s *PostgresStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE key = $1 AND status_code IS NULL`
+
+	if _, err := s.db.Exec(ctx, query, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchURLs finds active links whose short code or alias starts with query
+// or whose destination contains it. Exact code and alias matches come
+// first, then prefix matches, then destinations ranked by trigram similarity.
+func (s *PostgresStore) SearchURLs(ctx context.Context, query string, limit int) ([]*domain.URL, error) {
+	sql := `
+        SELECT ` + urlColumns + `
+        FROM urls
+        WHERE deleted_at IS NULL
+          AND (
+              short_code ILIKE $2 || '%'
+              OR custom_alias ILIKE $2 || '%'
+              OR original_url ILIKE '%' || $2 || '%'
+          )
+        ORDER BY
+            CASE
+                WHEN lower(short_code) = lower($1) OR lower(custom_alias) = lower($1) THEN 0
+                WHEN short_code ILIKE $2 || '%' OR custom_alias ILIKE $2 || '%' THEN 1
+                ELSE 2
+            END,
+            similarity(original_url, $1) DESC,
+            created_at DESC
+        LIMIT $3
+    `
+
+	rows, err := s.db.Query(ctx, sql, query, likeEscaper.Replace(query), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search urls: %w", err)
+	}
+	defer rows.Close()
+
+	urls := make([]*domain.URL, 0)
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan url: %w", err)
+		}
+		urls = append(urls, url)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return urls, nil
+}
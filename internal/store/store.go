@@ -2,30 +2,90 @@ package store
 
 import (
 	"context"
+	"time"
 
 	"github.com/MyNameIsWhaaat/shortener/internal/domain"
 )
 
 type URLStore interface {
 	CreateURL(ctx context.Context, url *domain.URL) error
-	GetURLByShortCode(ctx context.Context, shortCode string) (*domain.URL, error)
-	IncrementClicks(ctx context.Context, shortCode string) error
-	CheckShortCodeExists(ctx context.Context, shortCode string) (bool, error)
-	GetAllURLs(ctx context.Context, limit int) ([]*domain.URL, error)
+	CreateURLs(ctx context.Context, urls []*domain.URL) ([]error, error)
+	UpdateURL(ctx context.Context, url *domain.URL) error
+	SetDisabled(ctx context.Context, host, shortCode string, disabled bool) error
+	DeleteURL(ctx context.Context, host, shortCode string) error
+	GetURLByShortCode(ctx context.Context, host, shortCode string) (*domain.URL, error)
+	FindByOriginalURL(ctx context.Context, host, originalURL string) (*domain.URL, error)
+	FindByOriginalURLs(ctx context.Context, hosts, originalURLs []string) ([]*domain.URL, error)
+	IncrementClicks(ctx context.Context, host, shortCode string) error
+	ReserveClick(ctx context.Context, host, shortCode string) (bool, error)
+	CheckShortCodeExists(ctx context.Context, host, shortCode string) (bool, error)
+	GetAllURLs(ctx context.Context, q domain.ListURLsQuery) ([]*domain.URL, error)
+	SearchURLs(ctx context.Context, query string, limit int) ([]*domain.URL, error)
+	AddTags(ctx context.Context, host, shortCode string, tags []string) error
+	RemoveTag(ctx context.Context, host, shortCode, tag string) error
+	GetTagCounts(ctx context.Context) ([]domain.TagCount, error)
+	SetTargetingRules(ctx context.Context, host, shortCode string, rules []domain.TargetingRule) error
+	SetVariants(ctx context.Context, host, shortCode string, variants []domain.Variant, sticky bool) error
+	SetInterstitial(ctx context.Context, host, shortCode string, enabled bool) error
+	SetDeepLinks(ctx context.Context, host, shortCode string, links domain.DeepLinks) error
+	ListDomains(ctx context.Context) ([]domain.ShortDomain, error)
+	AddDomain(ctx context.Context, host string) (*domain.ShortDomain, error)
+	DeleteDomain(ctx context.Context, host string) error
 }
 
 type AnalyticsStore interface {
 	SaveClickEvent(ctx context.Context, event *domain.ClickEvent) error
-	GetDailyStats(ctx context.Context, shortCode string, days int) (map[string]int64, error)
-	GetMonthlyStats(ctx context.Context, shortCode string, months int) (map[string]int64, error)
-	GetDeviceStats(ctx context.Context, shortCode string) (map[string]int64, error)
-	GetRecentClicks(ctx context.Context, shortCode string, limit int) ([]domain.ClickEvent, error)
-	GetAnalytics(ctx context.Context, shortCode string) (*domain.AnalyticsResponse, error)
+	SaveClickEvents(ctx context.Context, events []*domain.ClickEvent, counts []domain.ClickCount) error
+	GetDailyStats(ctx context.Context, host, shortCode string, days int) (map[string]int64, error)
+	GetMonthlyStats(ctx context.Context, host, shortCode string, months int) (map[string]int64, error)
+	GetDeviceStats(ctx context.Context, host, shortCode string) (map[string]int64, error)
+	GetBrowserStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error)
+	GetOSStats(ctx context.Context, host, shortCode string, versions bool) (map[string]int64, error)
+	GetVariantStats(ctx context.Context, host, shortCode string) (map[string]int64, error)
+	GetRecentClicks(ctx context.Context, host, shortCode string, limit int) ([]domain.ClickEvent, error)
+	GetBotStats(ctx context.Context, host, shortCode string) (*domain.BotStats, error)
+	GetAnalytics(ctx context.Context, host, shortCode string) (*domain.AnalyticsResponse, error)
+}
+
+// IdempotencyStore keeps responses of requests sent with an Idempotency-Key
+// header so that retries can be replayed.
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey claims key for a new request. It returns false if
+	// the key is already taken and has not expired.
+	ReserveIdempotencyKey(ctx context.Context, key, requestHash string, ttl time.Duration) (bool, error)
+
+	// GetIdempotencyRecord returns the record for key, or nil if there is none
+	GetIdempotencyRecord(ctx context.Context, key string) (*domain.IdempotencyRecord, error)
+
+	// SaveIdempotencyRecord stores the final response for a reserved key
+	SaveIdempotencyRecord(ctx context.Context, key string, record *domain.IdempotencyRecord, ttl time.Duration) error
+
+	// ReleaseIdempotencyKey forgets key so the request can be retried
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// VisitorStore keeps HyperLogLog sketches of the visitors of each link, one
+// for all time and one per day and month.
+type VisitorStore interface {
+	// AddVisitors adds the Visitor fingerprints of events to the sketches of
+	// their links. Events without a fingerprint are skipped.
+	AddVisitors(ctx context.Context, events []*domain.ClickEvent) error
+
+	// CountVisitors estimates the unique visitors of a link over all time
+	// and in each of days and months, formatted as domain.VisitorDayLayout
+	// and domain.VisitorMonthLayout. Periods without visitors are left out.
+	CountVisitors(ctx context.Context, host, shortCode string, days, months []string) (*domain.UniqueVisitors, error)
+}
+
+// Pinger checks that the database can be reached
+type Pinger interface {
+	Ping(ctx context.Context) error
 }
 
 type Store interface {
 	URLStore
 	AnalyticsStore
-	Ping(ctx context.Context) error
+	IdempotencyStore
+	Pinger
 	Close() error
 }
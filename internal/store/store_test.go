@@ -3,6 +3,9 @@ package store
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,53 +16,291 @@ var errNotFound = errors.New("not found")
 
 // Mock implementations for testing interfaces
 type mockURLStore struct {
-	urls map[string]*domain.URL
+	urls    map[string]*domain.URL
+	domains map[string]bool
 }
 
 func newMockURLStore() *mockURLStore {
 	return &mockURLStore{
-		urls: make(map[string]*domain.URL),
+		urls:    make(map[string]*domain.URL),
+		domains: make(map[string]bool),
 	}
 }
 
+// linkKey identifies a link by its domain and short code
+func linkKey(host, shortCode string) string {
+	return host + "/" + shortCode
+}
+
 func (m *mockURLStore) CreateURL(ctx context.Context, url *domain.URL) error {
-	m.urls[url.ShortCode] = url
+	m.urls[linkKey(url.Domain, url.ShortCode)] = url
+	return nil
+}
+
+func (m *mockURLStore) CreateURLs(ctx context.Context, urls []*domain.URL) ([]error, error) {
+	errs := make([]error, len(urls))
+	for i, url := range urls {
+		if _, exists := m.urls[linkKey(url.Domain, url.ShortCode)]; exists {
+			errs[i] = domain.ErrShortCodeExists
+			continue
+		}
+		m.urls[linkKey(url.Domain, url.ShortCode)] = url
+	}
+	return errs, nil
+}
+
+func (m *mockURLStore) UpdateURL(ctx context.Context, url *domain.URL) error {
+	existing, ok := m.urls[linkKey(url.Domain, url.ShortCode)]
+	if !ok {
+		return errNotFound
+	}
+	existing.OriginalURL = url.OriginalURL
+	return nil
+}
+
+func (m *mockURLStore) SetDisabled(ctx context.Context, host, shortCode string, disabled bool) error {
+	url, ok := m.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return errNotFound
+	}
+	if disabled {
+		now := time.Now()
+		url.DisabledAt = &now
+	} else {
+		url.DisabledAt = nil
+	}
+	return nil
+}
+
+func (m *mockURLStore) DeleteURL(ctx context.Context, host, shortCode string) error {
+	url, ok := m.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return errNotFound
+	}
+	now := time.Now()
+	url.DeletedAt = &now
 	return nil
 }
 
-func (m *mockURLStore) GetURLByShortCode(ctx context.Context, shortCode string) (*domain.URL, error) {
-	if url, ok := m.urls[shortCode]; ok {
+func (m *mockURLStore) GetURLByShortCode(ctx context.Context, host, shortCode string) (*domain.URL, error) {
+	if url, ok := m.urls[linkKey(host, shortCode)]; ok {
 		return url, nil
 	}
 	return nil, errNotFound
 }
 
-func (m *mockURLStore) IncrementClicks(ctx context.Context, shortCode string) error {
-	if url, ok := m.urls[shortCode]; ok {
+func (m *mockURLStore) FindByOriginalURL(ctx context.Context, host, originalURL string) (*domain.URL, error) {
+	fingerprint := domain.URLFingerprint(originalURL)
+	var found *domain.URL
+	for _, url := range m.urls {
+		if url.Domain != host || url.DeletedAt != nil || url.DisabledAt != nil || url.ExpiresAt != nil || url.MaxClicks != nil || url.PasswordHash != nil {
+			continue
+		}
+		if domain.URLFingerprint(url.OriginalURL) != fingerprint {
+			continue
+		}
+		if found == nil || url.CreatedAt.Before(found.CreatedAt) {
+			found = url
+		}
+	}
+	if found == nil {
+		return nil, errNotFound
+	}
+	return found, nil
+}
+
+func (m *mockURLStore) FindByOriginalURLs(ctx context.Context, hosts, originalURLs []string) ([]*domain.URL, error) {
+	urls := make([]*domain.URL, len(originalURLs))
+	for i := range originalURLs {
+		if url, err := m.FindByOriginalURL(ctx, hosts[i], originalURLs[i]); err == nil {
+			urls[i] = url
+		}
+	}
+	return urls, nil
+}
+
+func (m *mockURLStore) IncrementClicks(ctx context.Context, host, shortCode string) error {
+	if url, ok := m.urls[linkKey(host, shortCode)]; ok {
 		url.Clicks++
 		return nil
 	}
 	return errNotFound
 }
 
-func (m *mockURLStore) CheckShortCodeExists(ctx context.Context, shortCode string) (bool, error) {
-	_, exists := m.urls[shortCode]
+func (m *mockURLStore) ReserveClick(ctx context.Context, host, shortCode string) (bool, error) {
+	url, ok := m.urls[linkKey(host, shortCode)]
+	if !ok || (url.MaxClicks != nil && url.Clicks >= *url.MaxClicks) {
+		return false, nil
+	}
+	url.Clicks++
+	return true, nil
+}
+
+func (m *mockURLStore) CheckShortCodeExists(ctx context.Context, host, shortCode string) (bool, error) {
+	_, exists := m.urls[linkKey(host, shortCode)]
 	return exists, nil
 }
 
-func (m *mockURLStore) GetAllURLs(ctx context.Context, limit int) ([]*domain.URL, error) {
+func (m *mockURLStore) GetAllURLs(ctx context.Context, q domain.ListURLsQuery) ([]*domain.URL, error) {
 	urls := make([]*domain.URL, 0)
-	count := 0
 	for _, url := range m.urls {
-		if limit > 0 && count >= limit {
-			break
+		if !hasTags(url, q.Tags) {
+			continue
 		}
 		urls = append(urls, url)
-		count++
+		if q.Limit > 0 && len(urls) >= q.Limit {
+			break
+		}
 	}
 	return urls, nil
 }
 
+func (m *mockURLStore) SearchURLs(ctx context.Context, query string, limit int) ([]*domain.URL, error) {
+	query = strings.ToLower(query)
+	urls := make([]*domain.URL, 0)
+	for _, url := range m.urls {
+		alias := ""
+		if url.CustomAlias != nil {
+			alias = strings.ToLower(*url.CustomAlias)
+		}
+		if url.DeletedAt != nil {
+			continue
+		}
+		if strings.HasPrefix(strings.ToLower(url.ShortCode), query) || strings.HasPrefix(alias, query) ||
+			strings.Contains(strings.ToLower(url.OriginalURL), query) {
+			urls = append(urls, url)
+		}
+		if limit > 0 && len(urls) >= limit {
+			break
+		}
+	}
+	return urls, nil
+}
+
+func (m *mockURLStore) SetTargetingRules(ctx context.Context, host, shortCode string, rules []domain.TargetingRule) error {
+	url, ok := m.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return errNotFound
+	}
+	url.Targeting = rules
+	return nil
+}
+
+func (m *mockURLStore) SetVariants(ctx context.Context, host, shortCode string, variants []domain.Variant, sticky bool) error {
+	url, ok := m.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return errNotFound
+	}
+	url.Variants = variants
+	url.StickyVariants = sticky
+	return nil
+}
+
+func (m *mockURLStore) SetInterstitial(ctx context.Context, host, shortCode string, enabled bool) error {
+	url, ok := m.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return errNotFound
+	}
+	url.Interstitial = enabled
+	return nil
+}
+
+func (m *mockURLStore) SetDeepLinks(ctx context.Context, host, shortCode string, links domain.DeepLinks) error {
+	url, ok := m.urls[linkKey(host, shortCode)]
+	if !ok || url.DeletedAt != nil {
+		return errNotFound
+	}
+	url.DeepLinks = links
+	return nil
+}
+
+func (m *mockURLStore) AddTags(ctx context.Context, host, shortCode string, tags []string) error {
+	url, ok := m.urls[linkKey(host, shortCode)]
+	if !ok {
+		return errNotFound
+	}
+	for _, tag := range tags {
+		if !hasTags(url, []string{tag}) {
+			url.Tags = append(url.Tags, tag)
+		}
+	}
+	return nil
+}
+
+func (m *mockURLStore) RemoveTag(ctx context.Context, host, shortCode, tag string) error {
+	url, ok := m.urls[linkKey(host, shortCode)]
+	if !ok {
+		return nil
+	}
+	tags := make([]string, 0, len(url.Tags))
+	for _, existing := range url.Tags {
+		if existing != tag {
+			tags = append(tags, existing)
+		}
+	}
+	url.Tags = tags
+	return nil
+}
+
+func (m *mockURLStore) GetTagCounts(ctx context.Context) ([]domain.TagCount, error) {
+	counts := make(map[string]int64)
+	for _, url := range m.urls {
+		for _, tag := range url.Tags {
+			counts[tag]++
+		}
+	}
+	result := make([]domain.TagCount, 0, len(counts))
+	for tag, count := range counts {
+		result = append(result, domain.TagCount{Tag: tag, Count: count})
+	}
+	return result, nil
+}
+
+func (m *mockURLStore) ListDomains(ctx context.Context) ([]domain.ShortDomain, error) {
+	domains := make([]domain.ShortDomain, 0, len(m.domains))
+	for host := range m.domains {
+		domains = append(domains, domain.ShortDomain{Host: host})
+	}
+	return domains, nil
+}
+
+func (m *mockURLStore) AddDomain(ctx context.Context, host string) (*domain.ShortDomain, error) {
+	if m.domains[host] {
+		return nil, domain.ErrDomainExists
+	}
+	m.domains[host] = true
+	return &domain.ShortDomain{Host: host, CreatedAt: time.Now()}, nil
+}
+
+func (m *mockURLStore) DeleteDomain(ctx context.Context, host string) error {
+	if !m.domains[host] {
+		return domain.ErrDomainNotFound
+	}
+	for _, url := range m.urls {
+		if url.Domain == host && url.DeletedAt == nil {
+			return domain.ErrDomainInUse
+		}
+	}
+	delete(m.domains, host)
+	return nil
+}
+
+func hasTags(url *domain.URL, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, existing := range url.Tags {
+			if existing == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 // Tests
 func TestURLStoreInterface(t *testing.T) {
 	store := newMockURLStore()
@@ -79,7 +320,7 @@ func TestURLStoreInterface(t *testing.T) {
 	}
 
 	// Test GetURLByShortCode
-	retrieved, err := store.GetURLByShortCode(ctx, "test123")
+	retrieved, err := store.GetURLByShortCode(ctx, "", "test123")
 	if err != nil {
 		t.Errorf("GetURLByShortCode failed: %v", err)
 	}
@@ -89,23 +330,23 @@ func TestURLStoreInterface(t *testing.T) {
 	}
 
 	// Test CheckShortCodeExists
-	exists, err := store.CheckShortCodeExists(ctx, "test123")
+	exists, err := store.CheckShortCodeExists(ctx, "", "test123")
 	if err != nil || !exists {
 		t.Error("CheckShortCodeExists should return true for existing code")
 	}
 
-	notExists, err := store.CheckShortCodeExists(ctx, "notexist")
+	notExists, err := store.CheckShortCodeExists(ctx, "", "notexist")
 	if err != nil || notExists {
 		t.Error("CheckShortCodeExists should return false for non-existing code")
 	}
 
 	// Test IncrementClicks
-	err = store.IncrementClicks(ctx, "test123")
+	err = store.IncrementClicks(ctx, "", "test123")
 	if err != nil {
 		t.Errorf("IncrementClicks failed: %v", err)
 	}
 
-	retrieved, _ = store.GetURLByShortCode(ctx, "test123")
+	retrieved, _ = store.GetURLByShortCode(ctx, "", "test123")
 	if retrieved.Clicks != 1 {
 		t.Errorf("expected 1 click, got %d", retrieved.Clicks)
 	}
@@ -121,7 +362,7 @@ func TestURLStoreInterface(t *testing.T) {
 		t.Fatalf("Failed to create URL: %v", err)
 	}
 
-	urls, err := store.GetAllURLs(ctx, 10)
+	urls, err := store.GetAllURLs(ctx, domain.ListURLsQuery{Limit: 10})
 	if err != nil {
 		t.Errorf("GetAllURLs failed: %v", err)
 	}
@@ -131,7 +372,7 @@ func TestURLStoreInterface(t *testing.T) {
 	}
 
 	// Test GetAllURLs with limit
-	urls, err = store.GetAllURLs(ctx, 1)
+	urls, err = store.GetAllURLs(ctx, domain.ListURLsQuery{Limit: 1})
 	if err != nil {
 		t.Errorf("GetAllURLs with limit failed: %v", err)
 	}
@@ -144,7 +385,7 @@ func TestGetURLByShortCodeNotFound(t *testing.T) {
 	store := newMockURLStore()
 	ctx := context.Background()
 
-	_, err := store.GetURLByShortCode(ctx, "nonexistent")
+	_, err := store.GetURLByShortCode(ctx, "", "nonexistent")
 	if err == nil {
 		t.Error("expected error for non-existent URL")
 	}
@@ -154,8 +395,68 @@ func TestIncrementClicksNotFound(t *testing.T) {
 	store := newMockURLStore()
 	ctx := context.Background()
 
-	err := store.IncrementClicks(ctx, "nonexistent")
+	err := store.IncrementClicks(ctx, "", "nonexistent")
 	if err == nil {
 		t.Error("expected error when incrementing non-existent URL")
 	}
 }
+
+func TestLikeEscaper(t *testing.T) {
+	tests := map[string]string{
+		"example":    "example",
+		"100%":       `100\%`,
+		"my_link":    `my\_link`,
+		`back\slash`: `back\\slash`,
+	}
+
+	for input, expected := range tests {
+		if got := likeEscaper.Replace(input); got != expected {
+			t.Errorf("likeEscaper.Replace(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
+
+func TestVisitorSketch(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 1000, 50000} {
+		s := &sketch{}
+		for i := 0; i < n; i++ {
+			s.add(fmt.Sprintf("visitor-%d", i))
+			// Repeated visits do not count again
+			s.add(fmt.Sprintf("visitor-%d", i))
+		}
+
+		decoded, err := unmarshalSketch(s.marshal())
+		if err != nil {
+			t.Fatalf("n=%d: failed to decode sketch: %v", n, err)
+		}
+		if decoded.registers != s.registers {
+			t.Errorf("n=%d: sketch changed after encoding", n)
+		}
+
+		got := decoded.estimate()
+		if diff := math.Abs(float64(got - int64(n))); diff > 0.05*float64(n) {
+			t.Errorf("n=%d: estimate %d is off by more than 5%%", n, got)
+		}
+	}
+}
+
+func TestVisitorSketchMerge(t *testing.T) {
+	a, b := &sketch{}, &sketch{}
+	for i := 0; i < 2000; i++ {
+		a.add(fmt.Sprintf("visitor-%d", i))
+		b.add(fmt.Sprintf("visitor-%d", i+1000))
+	}
+	a.merge(b)
+
+	if got := a.estimate(); math.Abs(float64(got-3000)) > 150 {
+		t.Errorf("expected about 3000 visitors after merge, got %d", got)
+	}
+
+	if _, err := unmarshalSketch([]byte{sketchDense, 1, 2}); err == nil {
+		t.Error("expected truncated sketch to be rejected")
+	}
+	empty, err := unmarshalSketch(nil)
+	if err != nil || empty.estimate() != 0 {
+		t.Errorf("expected empty sketch for no data, got %v, %v", empty, err)
+	}
+}
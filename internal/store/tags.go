@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+)
+
+func (s *PostgresStore) AddTags(ctx context.Context, host, shortCode string, tags []string) error {
+	query := `
+        INSERT INTO url_tags (url_id, tag)
+        SELECT urls.id, tag
+        FROM urls, unnest($3::varchar[]) AS tag
+        WHERE urls.domain = $1 AND urls.short_code = $2 AND urls.deleted_at IS NULL
+        ON CONFLICT (url_id, tag) DO NOTHING
+    `
+
+	if _, err := s.db.Exec(ctx, query, host, shortCode, tags); err != nil {
+		return fmt.Errorf("failed to add tags: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) RemoveTag(ctx context.Context, host, shortCode, tag string) error {
+	query := `
+        DELETE FROM url_tags
+        USING urls
+        WHERE url_tags.url_id = urls.id
+          AND urls.domain = $1
+          AND urls.short_code = $2
+          AND url_tags.tag = $3
+    `
+
+	if _, err := s.db.Exec(ctx, query, host, shortCode, tag); err != nil {
+		return fmt.Errorf("failed to remove tag: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetTagCounts(ctx context.Context) ([]domain.TagCount, error) {
+	query := `
+        SELECT url_tags.tag, COUNT(*)::bigint
+        FROM url_tags
+        JOIN urls ON urls.id = url_tags.url_id
+        WHERE urls.deleted_at IS NULL
+        GROUP BY url_tags.tag
+        ORDER BY COUNT(*) DESC, url_tags.tag
+    `
+
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tag counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make([]domain.TagCount, 0)
+	for rows.Next() {
+		var count domain.TagCount
+		if err := rows.Scan(&count.Tag, &count.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan tag count: %w", err)
+		}
+		counts = append(counts, count)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("tag counts rows error: %w", err)
+	}
+
+	return counts, nil
+}
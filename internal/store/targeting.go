@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/jackc/pgx/v5"
+)
+
+// targetingArrays holds targeting rules column by column for unnest
+type targetingArrays struct {
+	devices      []string
+	oses         []string
+	languages    []string
+	destinations []string
+}
+
+func (a *targetingArrays) append(rule domain.TargetingRule) {
+	a.devices = append(a.devices, rule.Device)
+	a.oses = append(a.oses, rule.OS)
+	a.languages = append(a.languages, rule.Language)
+	a.destinations = append(a.destinations, rule.URL)
+}
+
+func targetingColumns(rules []domain.TargetingRule) targetingArrays {
+	var a targetingArrays
+	for _, rule := range rules {
+		a.append(rule)
+	}
+	return a
+}
+
+// SetTargetingRules replaces the targeting rules of a link, keeping the
+// order in which they are given.
+func (s *PostgresStore) SetTargetingRules(ctx context.Context, host, shortCode string, rules []domain.TargetingRule) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var urlID int64
+	err = tx.QueryRow(ctx,
+		`SELECT id FROM urls WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL FOR UPDATE`,
+		host, shortCode,
+	).Scan(&urlID)
+	if err == pgx.ErrNoRows {
+		return domain.ErrURLNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock url: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM url_targeting_rules WHERE url_id = $1`, urlID); err != nil {
+		return fmt.Errorf("failed to clear targeting rules: %w", err)
+	}
+
+	columns := targetingColumns(rules)
+	query := `
+        INSERT INTO url_targeting_rules (url_id, position, device, os, language, destination)
+        SELECT $1, r.position, NULLIF(r.device, ''), NULLIF(r.os, ''), NULLIF(r.language, ''), r.destination
+        FROM unnest($2::varchar[], $3::varchar[], $4::varchar[], $5::text[])
+            WITH ORDINALITY AS r(device, os, language, destination, position)
+    `
+	if _, err := tx.Exec(ctx, query, urlID,
+		columns.devices, columns.oses, columns.languages, columns.destinations,
+	); err != nil {
+		return fmt.Errorf("failed to insert targeting rules: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit targeting rules: %w", err)
+	}
+
+	return nil
+}
@@ -2,19 +2,140 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/MyNameIsWhaaat/shortener/internal/domain"
 	"github.com/jackc/pgx/v5"
 )
 
+const urlColumns = `id, domain, short_code, original_url, custom_alias, created_at, clicks,
+            expires_at, max_clicks, disabled_at, deleted_at, password_hash, redirect_type,
+            forward_query, forward_path, utm_source, utm_medium, utm_campaign, sticky_variants, interstitial,
+            ios_url, android_url, fallback_url,
+            ARRAY(SELECT tag FROM url_tags WHERE url_tags.url_id = urls.id ORDER BY tag) AS tags,
+            (SELECT json_agg(json_build_object(
+                        'device', device, 'os', os, 'language', language, 'url', destination
+                    ) ORDER BY position)
+             FROM url_targeting_rules WHERE url_targeting_rules.url_id = urls.id) AS targeting,
+            (SELECT json_agg(json_build_object(
+                        'name', name, 'url', destination, 'weight', weight
+                    ) ORDER BY position)
+             FROM url_variants WHERE url_variants.url_id = urls.id) AS variants`
+
+// scanURL reads a row selected with urlColumns
+func scanURL(row pgx.Row) (*domain.URL, error) {
+	var (
+		url                               domain.URL
+		utmSource, utmMedium, utmCampaign *string
+		iosURL, androidURL, fallbackURL   *string
+		targeting, variants               []byte
+	)
+	if err := row.Scan(
+		&url.ID,
+		&url.Domain,
+		&url.ShortCode,
+		&url.OriginalURL,
+		&url.CustomAlias,
+		&url.CreatedAt,
+		&url.Clicks,
+		&url.ExpiresAt,
+		&url.MaxClicks,
+		&url.DisabledAt,
+		&url.DeletedAt,
+		&url.PasswordHash,
+		&url.RedirectType,
+		&url.ForwardQuery,
+		&url.ForwardPath,
+		&utmSource,
+		&utmMedium,
+		&utmCampaign,
+		&url.StickyVariants,
+		&url.Interstitial,
+		&iosURL,
+		&androidURL,
+		&fallbackURL,
+		&url.Tags,
+		&targeting,
+		&variants,
+	); err != nil {
+		return nil, err
+	}
+
+	if targeting != nil {
+		if err := json.Unmarshal(targeting, &url.Targeting); err != nil {
+			return nil, fmt.Errorf("failed to decode targeting rules: %w", err)
+		}
+	}
+
+	if variants != nil {
+		if err := json.Unmarshal(variants, &url.Variants); err != nil {
+			return nil, fmt.Errorf("failed to decode variants: %w", err)
+		}
+	}
+
+	utm := &domain.UTMParams{Source: deref(utmSource), Medium: deref(utmMedium), Campaign: deref(utmCampaign)}
+	if !utm.IsEmpty() {
+		url.UTM = utm
+	}
+
+	url.DeepLinks = domain.DeepLinks{IOSURL: deref(iosURL), AndroidURL: deref(androidURL), FallbackURL: deref(fallbackURL)}
+
+	url.Protected = url.PasswordHash != nil
+	return &url, nil
+}
+
+// utmColumns returns the nullable utm_source, utm_medium and utm_campaign values
+func utmColumns(utm *domain.UTMParams) [3]*string {
+	if utm == nil {
+		return [3]*string{}
+	}
+	return [3]*string{nullIfEmpty(utm.Source), nullIfEmpty(utm.Medium), nullIfEmpty(utm.Campaign)}
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
 func (s *PostgresStore) CreateURL(ctx context.Context, url *domain.URL) error {
 	query := `
-        INSERT INTO urls (short_code, original_url, custom_alias, created_at, clicks)
-        VALUES ($1, $2, $3, $4, $5)
-        RETURNING id
+        WITH inserted AS (
+            INSERT INTO urls (short_code, original_url, custom_alias, created_at, clicks, expires_at, max_clicks, password_hash, original_url_hash, redirect_type,
+                              forward_query, utm_source, utm_medium, utm_campaign, sticky_variants, interstitial,
+                              ios_url, android_url, fallback_url, domain, forward_path)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $20, $24, $25, $26, $27, $28, $29)
+            RETURNING id
+        ), tagged AS (
+            INSERT INTO url_tags (url_id, tag)
+            SELECT inserted.id, tag FROM inserted, unnest($15::varchar[]) AS tag
+        ), targeted AS (
+            INSERT INTO url_targeting_rules (url_id, position, device, os, language, destination)
+            SELECT inserted.id, r.position, NULLIF(r.device, ''), NULLIF(r.os, ''), NULLIF(r.language, ''), r.destination
+            FROM inserted, unnest($16::varchar[], $17::varchar[], $18::varchar[], $19::text[])
+                WITH ORDINALITY AS r(device, os, language, destination, position)
+        ), split AS (
+            INSERT INTO url_variants (url_id, position, name, destination, weight)
+            SELECT inserted.id, v.position, v.name, v.destination, v.weight
+            FROM inserted, unnest($21::varchar[], $22::text[], $23::int[])
+                WITH ORDINALITY AS v(name, destination, weight, position)
+        )
+        SELECT id FROM inserted
     `
 
+	utm := utmColumns(url.UTM)
+	rules := targetingColumns(url.Targeting)
+	variants := variantColumns(url.Variants)
 	err := s.db.QueryRow(
 		ctx,
 		query,
@@ -23,6 +144,30 @@ func (s *PostgresStore) CreateURL(ctx context.Context, url *domain.URL) error {
 		url.CustomAlias,
 		url.CreatedAt,
 		url.Clicks,
+		url.ExpiresAt,
+		url.MaxClicks,
+		url.PasswordHash,
+		domain.URLFingerprint(url.OriginalURL),
+		url.RedirectStatus(),
+		url.ForwardQuery,
+		utm[0],
+		utm[1],
+		utm[2],
+		url.Tags,
+		rules.devices,
+		rules.oses,
+		rules.languages,
+		rules.destinations,
+		url.StickyVariants,
+		variants.names,
+		variants.destinations,
+		variants.weights,
+		url.Interstitial,
+		nullIfEmpty(url.IOSURL),
+		nullIfEmpty(url.AndroidURL),
+		nullIfEmpty(url.FallbackURL),
+		url.Domain,
+		url.ForwardPath,
 	).Scan(&url.ID)
 
 	if err != nil {
@@ -35,23 +180,267 @@ func (s *PostgresStore) CreateURL(ctx context.Context, url *domain.URL) error {
 	return nil
 }
 
-func (s *PostgresStore) GetURLByShortCode(ctx context.Context, shortCode string) (*domain.URL, error) {
+// CreateURLs inserts all urls in a single statement. Rows whose short code is
+// already taken on their domain are skipped and reported as
+// domain.ErrShortCodeExists in the returned slice, which is aligned with urls.
+// Short codes must be distinct within urls on each domain.
+func (s *PostgresStore) CreateURLs(ctx context.Context, urls []*domain.URL) ([]error, error) {
 	query := `
-        SELECT id, short_code, original_url, custom_alias, created_at, clicks
-        FROM urls
-        WHERE short_code = $1
+        WITH inserted AS (
+            INSERT INTO urls (short_code, original_url, custom_alias, created_at, clicks, expires_at, max_clicks, password_hash, original_url_hash, redirect_type,
+                              forward_query, utm_source, utm_medium, utm_campaign, sticky_variants, interstitial,
+                              ios_url, android_url, fallback_url, domain, forward_path)
+            SELECT * FROM unnest(
+                $1::varchar[], $2::text[], $3::varchar[], $4::timestamptz[],
+                $5::bigint[], $6::timestamptz[], $7::bigint[], $8::text[], $9::char(64)[],
+                $10::smallint[], $11::boolean[], $12::varchar[], $13::varchar[], $14::varchar[],
+                $23::boolean[], $29::boolean[], $30::text[], $31::text[], $32::text[],
+                $33::varchar[], $34::boolean[]
+            )
+            ON CONFLICT (domain, short_code) DO NOTHING
+            RETURNING id, domain, short_code
+        ), tagged AS (
+            INSERT INTO url_tags (url_id, tag)
+            SELECT inserted.id, t.tag
+            FROM inserted
+            JOIN unnest($35::varchar[], $15::varchar[], $16::varchar[]) AS t(domain, short_code, tag)
+                ON t.domain = inserted.domain AND t.short_code = inserted.short_code
+        ), targeted AS (
+            INSERT INTO url_targeting_rules (url_id, position, device, os, language, destination)
+            SELECT inserted.id, r.position, NULLIF(r.device, ''), NULLIF(r.os, ''), NULLIF(r.language, ''), r.destination
+            FROM inserted
+            JOIN unnest($36::varchar[], $17::varchar[], $18::int[], $19::varchar[], $20::varchar[], $21::varchar[], $22::text[])
+                AS r(domain, short_code, position, device, os, language, destination)
+                ON r.domain = inserted.domain AND r.short_code = inserted.short_code
+        ), split AS (
+            INSERT INTO url_variants (url_id, position, name, destination, weight)
+            SELECT inserted.id, v.position, v.name, v.destination, v.weight
+            FROM inserted
+            JOIN unnest($37::varchar[], $24::varchar[], $25::int[], $26::varchar[], $27::text[], $28::int[])
+                AS v(domain, short_code, position, name, destination, weight)
+                ON v.domain = inserted.domain AND v.short_code = inserted.short_code
+        )
+        SELECT id, domain, short_code FROM inserted
     `
 
-	var url domain.URL
-	err := s.db.QueryRow(ctx, query, shortCode).Scan(
-		&url.ID,
-		&url.ShortCode,
-		&url.OriginalURL,
-		&url.CustomAlias,
-		&url.CreatedAt,
-		&url.Clicks,
+	var (
+		shortCodes   = make([]string, len(urls))
+		originalURLs = make([]string, len(urls))
+		aliases      = make([]*string, len(urls))
+		createdAts   = make([]time.Time, len(urls))
+		clicks       = make([]int64, len(urls))
+		expiresAts   = make([]*time.Time, len(urls))
+		maxClicks    = make([]*int64, len(urls))
+		passwords    = make([]*string, len(urls))
+		hashes       = make([]string, len(urls))
+		redirects    = make([]int, len(urls))
+		forwards     = make([]bool, len(urls))
+		paths        = make([]bool, len(urls))
+		utmSources   = make([]*string, len(urls))
+		utmMediums   = make([]*string, len(urls))
+		utmCampaigns = make([]*string, len(urls))
+		stickies     = make([]bool, len(urls))
+		interstitial = make([]bool, len(urls))
+		iosURLs      = make([]*string, len(urls))
+		androidURLs  = make([]*string, len(urls))
+		fallbacks    = make([]*string, len(urls))
+		hosts        = make([]string, len(urls))
+		tagHosts     []string
+		tagCodes     []string
+		tags         []string
+		ruleHosts    []string
+		ruleCodes    []string
+		positions    []int
+		rules        targetingArrays
+		splitHosts   []string
+		splitCodes   []string
+		splitIndexes []int
+		variants     variantArrays
 	)
+	for i, url := range urls {
+		shortCodes[i] = url.ShortCode
+		originalURLs[i] = url.OriginalURL
+		aliases[i] = url.CustomAlias
+		createdAts[i] = url.CreatedAt
+		clicks[i] = url.Clicks
+		expiresAts[i] = url.ExpiresAt
+		maxClicks[i] = url.MaxClicks
+		passwords[i] = url.PasswordHash
+		hashes[i] = domain.URLFingerprint(url.OriginalURL)
+		redirects[i] = url.RedirectStatus()
+		forwards[i] = url.ForwardQuery
+		paths[i] = url.ForwardPath
+		utm := utmColumns(url.UTM)
+		utmSources[i], utmMediums[i], utmCampaigns[i] = utm[0], utm[1], utm[2]
+		for _, tag := range url.Tags {
+			tagHosts = append(tagHosts, url.Domain)
+			tagCodes = append(tagCodes, url.ShortCode)
+			tags = append(tags, tag)
+		}
+		for j, rule := range url.Targeting {
+			ruleHosts = append(ruleHosts, url.Domain)
+			ruleCodes = append(ruleCodes, url.ShortCode)
+			positions = append(positions, j+1)
+			rules.append(rule)
+		}
+		stickies[i] = url.StickyVariants
+		interstitial[i] = url.Interstitial
+		iosURLs[i] = nullIfEmpty(url.IOSURL)
+		androidURLs[i] = nullIfEmpty(url.AndroidURL)
+		fallbacks[i] = nullIfEmpty(url.FallbackURL)
+		hosts[i] = url.Domain
+		for j, variant := range url.Variants {
+			splitHosts = append(splitHosts, url.Domain)
+			splitCodes = append(splitCodes, url.ShortCode)
+			splitIndexes = append(splitIndexes, j+1)
+			variants.append(variant)
+		}
+	}
+
+	rows, err := s.db.Query(ctx, query,
+		shortCodes, originalURLs, aliases, createdAts,
+		clicks, expiresAts, maxClicks, passwords, hashes,
+		redirects, forwards, utmSources, utmMediums, utmCampaigns,
+		tagCodes, tags,
+		ruleCodes, positions, rules.devices, rules.oses, rules.languages, rules.destinations,
+		stickies, splitCodes, splitIndexes, variants.names, variants.destinations, variants.weights,
+		interstitial, iosURLs, androidURLs, fallbacks, hosts, paths,
+		tagHosts, ruleHosts, splitHosts,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create urls: %w", err)
+	}
+	defer rows.Close()
+
+	// Created ids by domain and short code
+	type linkKey struct{ host, shortCode string }
+	ids := make(map[linkKey]int64, len(urls))
+	for rows.Next() {
+		var id int64
+		var key linkKey
+		if err := rows.Scan(&id, &key.host, &key.shortCode); err != nil {
+			return nil, fmt.Errorf("failed to scan created url: %w", err)
+		}
+		ids[key] = id
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	errs := make([]error, len(urls))
+	for i, url := range urls {
+		id, ok := ids[linkKey{url.Domain, url.ShortCode}]
+		if !ok {
+			errs[i] = domain.ErrShortCodeExists
+			continue
+		}
+		url.ID = id
+	}
+
+	return errs, nil
+}
+
+func (s *PostgresStore) UpdateURL(ctx context.Context, url *domain.URL) error {
+	query := `
+        UPDATE urls
+        SET original_url = $3, original_url_hash = $4
+        WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL
+    `
+
+	tag, err := s.db.Exec(ctx, query, url.Domain, url.ShortCode, url.OriginalURL, domain.URLFingerprint(url.OriginalURL))
+	if err != nil {
+		return fmt.Errorf("failed to update url: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrURLNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) SetDisabled(ctx context.Context, host, shortCode string, disabled bool) error {
+	query := `
+        UPDATE urls
+        SET disabled_at = CASE WHEN $3::boolean THEN COALESCE(disabled_at, NOW()) ELSE NULL END
+        WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL
+    `
+
+	tag, err := s.db.Exec(ctx, query, host, shortCode, disabled)
+	if err != nil {
+		return fmt.Errorf("failed to set url disabled state: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrURLNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) SetInterstitial(ctx context.Context, host, shortCode string, enabled bool) error {
+	query := `UPDATE urls SET interstitial = $3 WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL`
+
+	tag, err := s.db.Exec(ctx, query, host, shortCode, enabled)
+	if err != nil {
+		return fmt.Errorf("failed to set url interstitial: %w", err)
+	}
 
+	if tag.RowsAffected() == 0 {
+		return domain.ErrURLNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) SetDeepLinks(ctx context.Context, host, shortCode string, links domain.DeepLinks) error {
+	query := `
+        UPDATE urls
+        SET ios_url = $3, android_url = $4, fallback_url = $5
+        WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL
+    `
+
+	tag, err := s.db.Exec(ctx, query, host, shortCode,
+		nullIfEmpty(links.IOSURL), nullIfEmpty(links.AndroidURL), nullIfEmpty(links.FallbackURL))
+	if err != nil {
+		return fmt.Errorf("failed to set url deep links: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrURLNotFound
+	}
+
+	return nil
+}
+
+// DeleteURL marks the link as deleted. The row is kept as a tombstone so
+// that the short code stays reserved.
+func (s *PostgresStore) DeleteURL(ctx context.Context, host, shortCode string) error {
+	query := `UPDATE urls SET deleted_at = NOW() WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL`
+
+	tag, err := s.db.Exec(ctx, query, host, shortCode)
+	if err != nil {
+		return fmt.Errorf("failed to delete url: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrURLNotFound
+	}
+
+	return nil
+}
+
+// GetURLByShortCode returns the link with the given code on host. The empty
+// host stands for the default domain.
+func (s *PostgresStore) GetURLByShortCode(ctx context.Context, host, shortCode string) (*domain.URL, error) {
+	query := `
+        SELECT ` + urlColumns + `
+        FROM urls
+        WHERE domain = $1 AND short_code = $2
+    `
+
+	url, err := scanURL(s.db.QueryRow(ctx, query, host, shortCode))
 	if err == pgx.ErrNoRows {
 		return nil, domain.ErrURLNotFound
 	}
@@ -59,13 +448,102 @@ func (s *PostgresStore) GetURLByShortCode(ctx context.Context, shortCode string)
 		return nil, fmt.Errorf("failed to get url: %w", err)
 	}
 
-	return &url, nil
+	return url, nil
 }
 
-func (s *PostgresStore) IncrementClicks(ctx context.Context, shortCode string) error {
-	query := `UPDATE urls SET clicks = clicks + 1 WHERE short_code = $1`
+// reusableConditions restricts a query on urls to active links without
+// lifetime limits, password or redirect options, which are the only ones
+// handed out again for the same destination. $1 is the default redirect
+// type.
+const reusableConditions = `
+          deleted_at IS NULL
+          AND disabled_at IS NULL
+          AND expires_at IS NULL
+          AND max_clicks IS NULL
+          AND password_hash IS NULL
+          AND redirect_type = $1
+          AND forward_query = FALSE
+          AND forward_path = FALSE
+          AND interstitial = FALSE
+          AND ios_url IS NULL AND android_url IS NULL AND fallback_url IS NULL
+          AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+          AND NOT EXISTS (SELECT 1 FROM url_targeting_rules WHERE url_targeting_rules.url_id = urls.id)
+          AND NOT EXISTS (SELECT 1 FROM url_variants WHERE url_variants.url_id = urls.id)`
+
+// FindByOriginalURL returns the oldest active link on host to the same
+// normalized destination that has no lifetime limits, password or redirect
+// options.
+func (s *PostgresStore) FindByOriginalURL(ctx context.Context, host, originalURL string) (*domain.URL, error) {
+	query := `
+        SELECT ` + urlColumns + `
+        FROM urls
+        WHERE original_url_hash = $2
+          AND domain = $3
+          AND ` + reusableConditions + `
+        ORDER BY created_at ASC
+        LIMIT 1
+    `
 
-	tag, err := s.db.Exec(ctx, query, shortCode)
+	url, err := scanURL(s.db.QueryRow(ctx, query, domain.DefaultRedirectType, domain.URLFingerprint(originalURL), host))
+	if err == pgx.ErrNoRows {
+		return nil, domain.ErrURLNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find url by original url: %w", err)
+	}
+
+	return url, nil
+}
+
+// FindByOriginalURLs looks up FindByOriginalURL for each pair of hosts and
+// originalURLs in one query. The result is aligned with originalURLs and
+// holds nil where there is no such link.
+func (s *PostgresStore) FindByOriginalURLs(ctx context.Context, hosts, originalURLs []string) ([]*domain.URL, error) {
+	hashes := make([]string, len(originalURLs))
+	for i, originalURL := range originalURLs {
+		hashes[i] = domain.URLFingerprint(originalURL)
+	}
+
+	query := `
+        SELECT DISTINCT ON (domain, original_url_hash) ` + urlColumns + `
+        FROM urls
+        WHERE (domain, original_url_hash) IN (SELECT * FROM unnest($2::varchar[], $3::char(64)[]))
+          AND ` + reusableConditions + `
+        ORDER BY domain, original_url_hash, created_at ASC
+    `
+
+	rows, err := s.db.Query(ctx, query, domain.DefaultRedirectType, hosts, hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find urls by original url: %w", err)
+	}
+	defer rows.Close()
+
+	type destination struct{ host, hash string }
+	found := make(map[destination]*domain.URL)
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan url: %w", err)
+		}
+		found[destination{url.Domain, domain.URLFingerprint(url.OriginalURL)}] = url
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	urls := make([]*domain.URL, len(originalURLs))
+	for i := range originalURLs {
+		urls[i] = found[destination{hosts[i], hashes[i]}]
+	}
+
+	return urls, nil
+}
+
+func (s *PostgresStore) IncrementClicks(ctx context.Context, host, shortCode string) error {
+	query := `UPDATE urls SET clicks = clicks + 1 WHERE domain = $1 AND short_code = $2`
+
+	tag, err := s.db.Exec(ctx, query, host, shortCode)
 	if err != nil {
 		return fmt.Errorf("failed to increment clicks: %w", err)
 	}
@@ -77,11 +555,29 @@ func (s *PostgresStore) IncrementClicks(ctx context.Context, shortCode string) e
 	return nil
 }
 
-func (s *PostgresStore) CheckShortCodeExists(ctx context.Context, shortCode string) (bool, error) {
-	query := `SELECT EXISTS(SELECT 1 FROM urls WHERE short_code = $1)`
+// ReserveClick adds a click to the counter of a link unless its click budget
+// is spent, in one statement so concurrent clicks cannot overrun the budget.
+// It reports false when the budget is spent or the link does not exist.
+func (s *PostgresStore) ReserveClick(ctx context.Context, host, shortCode string) (bool, error) {
+	query := `
+        UPDATE urls SET clicks = clicks + 1
+        WHERE domain = $1 AND short_code = $2
+          AND (max_clicks IS NULL OR clicks < max_clicks)
+    `
+
+	tag, err := s.db.Exec(ctx, query, host, shortCode)
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve click: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *PostgresStore) CheckShortCodeExists(ctx context.Context, host, shortCode string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM urls WHERE domain = $1 AND short_code = $2)`
 
 	var exists bool
-	err := s.db.QueryRow(ctx, query, shortCode).Scan(&exists)
+	err := s.db.QueryRow(ctx, query, host, shortCode).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("failed to check short code: %w", err)
 	}
@@ -89,15 +585,54 @@ func (s *PostgresStore) CheckShortCodeExists(ctx context.Context, shortCode stri
 	return exists, nil
 }
 
-func (s *PostgresStore) GetAllURLs(ctx context.Context, limit int) ([]*domain.URL, error) {
+// urlOrderKeys maps a listing order to the column used as the leading
+// keyset key; id always breaks ties.
+var urlOrderKeys = map[domain.URLOrder]string{
+	domain.URLOrderCreated: "created_at",
+	domain.URLOrderClicks:  "clicks",
+}
+
+// GetAllURLs returns a page of active links in the requested order, starting
+// after q.After when set. When q.Tags is not empty only links carrying all of
+// the given tags are returned.
+func (s *PostgresStore) GetAllURLs(ctx context.Context, q domain.ListURLsQuery) ([]*domain.URL, error) {
+	key, ok := urlOrderKeys[q.Order]
+	if !ok {
+		key = urlOrderKeys[domain.URLOrderCreated]
+	}
+
 	query := `
-        SELECT id, short_code, original_url, custom_alias, created_at, clicks
+        SELECT ` + urlColumns + `
         FROM urls
-        ORDER BY created_at DESC
+        WHERE deleted_at IS NULL
+          AND (
+              COALESCE(cardinality($2::varchar[]), 0) = 0
+              OR (
+                  SELECT COUNT(*) FROM url_tags
+                  WHERE url_tags.url_id = urls.id AND url_tags.tag = ANY($2::varchar[])
+              ) = cardinality($2::varchar[])
+          )
+          AND ($3::boolean IS FALSE OR (` + key + `, id) < ($4, $5))
+        ORDER BY ` + key + ` DESC, id DESC
         LIMIT $1
     `
 
-	rows, err := s.db.Query(ctx, query, limit)
+	var (
+		hasCursor bool
+		afterKey  any
+		afterID   int64
+	)
+	if q.After != nil {
+		hasCursor = true
+		afterID = q.After.ID
+		if key == "clicks" {
+			afterKey = q.After.Clicks
+		} else {
+			afterKey = q.After.CreatedAt
+		}
+	}
+
+	rows, err := s.db.Query(ctx, query, q.Limit, q.Tags, hasCursor, afterKey, afterID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all urls: %w", err)
 	}
@@ -105,18 +640,11 @@ func (s *PostgresStore) GetAllURLs(ctx context.Context, limit int) ([]*domain.UR
 
 	var urls []*domain.URL
 	for rows.Next() {
-		var url domain.URL
-		if err := rows.Scan(
-			&url.ID,
-			&url.ShortCode,
-			&url.OriginalURL,
-			&url.CustomAlias,
-			&url.CreatedAt,
-			&url.Clicks,
-		); err != nil {
+		url, err := scanURL(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan url: %w", err)
 		}
-		urls = append(urls, &url)
+		urls = append(urls, url)
 	}
 
 	if err := rows.Err(); err != nil {
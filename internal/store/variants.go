@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/jackc/pgx/v5"
+)
+
+// variantArrays holds variants column by column for unnest
+type variantArrays struct {
+	names        []string
+	destinations []string
+	weights      []int
+}
+
+func (a *variantArrays) append(variant domain.Variant) {
+	a.names = append(a.names, variant.Name)
+	a.destinations = append(a.destinations, variant.URL)
+	a.weights = append(a.weights, variant.Weight)
+}
+
+func variantColumns(variants []domain.Variant) variantArrays {
+	var a variantArrays
+	for _, variant := range variants {
+		a.append(variant)
+	}
+	return a
+}
+
+// SetVariants replaces the split-test variants of a link
+func (s *PostgresStore) SetVariants(ctx context.Context, host, shortCode string, variants []domain.Variant, sticky bool) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var urlID int64
+	err = tx.QueryRow(ctx,
+		`UPDATE urls SET sticky_variants = $3 WHERE domain = $1 AND short_code = $2 AND deleted_at IS NULL RETURNING id`,
+		host, shortCode, sticky,
+	).Scan(&urlID)
+	if err == pgx.ErrNoRows {
+		return domain.ErrURLNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update url: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM url_variants WHERE url_id = $1`, urlID); err != nil {
+		return fmt.Errorf("failed to clear variants: %w", err)
+	}
+
+	columns := variantColumns(variants)
+	query := `
+        INSERT INTO url_variants (url_id, position, name, destination, weight)
+        SELECT $1, v.position, v.name, v.destination, v.weight
+        FROM unnest($2::varchar[], $3::text[], $4::int[])
+            WITH ORDINALITY AS v(name, destination, weight, position)
+    `
+	if _, err := tx.Exec(ctx, query, urlID, columns.names, columns.destinations, columns.weights); err != nil {
+		return fmt.Errorf("failed to insert variants: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit variants: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetVariantStats(ctx context.Context, host, shortCode string) (map[string]int64, error) {
+	query := `
+        SELECT variant, COUNT(*)::bigint
+        FROM click_events
+        WHERE domain = $1 AND short_code = $2 AND variant IS NOT NULL AND NOT is_bot
+        GROUP BY variant
+    `
+
+	rows, err := s.db.Query(ctx, query, host, shortCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := make(map[string]int64)
+	for rows.Next() {
+		var variant string
+		var count int64
+		if err := rows.Scan(&variant, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan variant stats: %w", err)
+		}
+		stats[variant] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("variant stats rows error: %w", err)
+	}
+
+	return stats, nil
+}
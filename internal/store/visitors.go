@@ -0,0 +1,238 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/MyNameIsWhaaat/shortener/internal/domain"
+	"github.com/MyNameIsWhaaat/shortener/internal/logger"
+)
+
+// totalPeriod is the period of the all-time sketch of a link
+const totalPeriod = ""
+
+// sketchKey identifies the sketch of a link for one period
+type sketchKey struct {
+	host      string
+	shortCode string
+	period    string
+}
+
+// AddVisitors merges the fingerprints of events into the visitor sketches
+// in visitor_sketches. The rows are locked while they are merged, so batches
+// written concurrently do not lose each other's visitors.
+func (s *PostgresStore) AddVisitors(ctx context.Context, events []*domain.ClickEvent) error {
+	batch := make(map[sketchKey]*sketch)
+	for _, event := range events {
+		if event.Visitor == "" {
+			continue
+		}
+
+		day, month := domain.VisitorPeriods(event.CreatedAt)
+		for _, period := range []string{totalPeriod, day, month} {
+			key := sketchKey{host: event.Domain, shortCode: event.ShortCode, period: period}
+			if batch[key] == nil {
+				batch[key] = &sketch{}
+			}
+			batch[key].add(event.Visitor)
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// A fixed order keeps concurrent batches from locking rows in opposite
+	// orders
+	keys := make([]sketchKey, 0, len(batch))
+	for key := range batch {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.host != b.host {
+			return a.host < b.host
+		}
+		if a.shortCode != b.shortCode {
+			return a.shortCode < b.shortCode
+		}
+		return a.period < b.period
+	})
+
+	var (
+		hosts   = make([]string, len(keys))
+		codes   = make([]string, len(keys))
+		periods = make([]string, len(keys))
+	)
+	for i, key := range keys {
+		hosts[i], codes[i], periods[i] = key.host, key.shortCode, key.period
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Create missing rows first, so that every sketch can be locked below.
+	// Links that no longer exist are skipped.
+	insertQuery := `
+        INSERT INTO visitor_sketches (domain, short_code, period, sketch)
+        SELECT k.domain, k.short_code, k.period, ''::bytea
+        FROM unnest($1::varchar[], $2::varchar[], $3::varchar[]) WITH ORDINALITY AS k(domain, short_code, period, n)
+        JOIN urls u ON u.domain = k.domain AND u.short_code = k.short_code
+        ORDER BY k.n
+        ON CONFLICT (domain, short_code, period) DO NOTHING
+    `
+	if _, err := tx.Exec(ctx, insertQuery, hosts, codes, periods); err != nil {
+		return fmt.Errorf("failed to create visitor sketches: %w", err)
+	}
+
+	selectQuery := `
+        SELECT v.domain, v.short_code, v.period, v.sketch
+        FROM visitor_sketches v
+        JOIN unnest($1::varchar[], $2::varchar[], $3::varchar[]) AS k(domain, short_code, period)
+          ON v.domain = k.domain AND v.short_code = k.short_code AND v.period = k.period
+        ORDER BY v.domain, v.short_code, v.period
+        FOR UPDATE OF v
+    `
+	rows, err := tx.Query(ctx, selectQuery, hosts, codes, periods)
+	if err != nil {
+		return fmt.Errorf("failed to lock visitor sketches: %w", err)
+	}
+
+	var (
+		updateHosts, updateCodes, updatePeriods []string
+		sketches                                [][]byte
+	)
+	for rows.Next() {
+		var (
+			key  sketchKey
+			data []byte
+		)
+		if err := rows.Scan(&key.host, &key.shortCode, &key.period, &data); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan visitor sketch: %w", err)
+		}
+
+		stored, err := unmarshalSketch(data)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to decode visitor sketch of %s: %w", key.shortCode, err)
+		}
+		stored.merge(batch[key])
+
+		updateHosts = append(updateHosts, key.host)
+		updateCodes = append(updateCodes, key.shortCode)
+		updatePeriods = append(updatePeriods, key.period)
+		sketches = append(sketches, stored.marshal())
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("visitor sketches rows error: %w", err)
+	}
+
+	updateQuery := `
+        UPDATE visitor_sketches v
+        SET sketch = k.sketch, updated_at = NOW()
+        FROM unnest($1::varchar[], $2::varchar[], $3::varchar[], $4::bytea[]) AS k(domain, short_code, period, sketch)
+        WHERE v.domain = k.domain AND v.short_code = k.short_code AND v.period = k.period
+    `
+	if _, err := tx.Exec(ctx, updateQuery, updateHosts, updateCodes, updatePeriods, sketches); err != nil {
+		return fmt.Errorf("failed to update visitor sketches: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit visitor sketches: %w", err)
+	}
+
+	return nil
+}
+
+// CountVisitors estimates unique visitors from the stored sketches
+func (s *PostgresStore) CountVisitors(ctx context.Context, host, shortCode string, days, months []string) (*domain.UniqueVisitors, error) {
+	periods := append([]string{totalPeriod}, days...)
+	periods = append(periods, months...)
+
+	query := `
+        SELECT period, sketch
+        FROM visitor_sketches
+        WHERE domain = $1 AND short_code = $2 AND period = ANY($3::varchar[])
+    `
+	rows, err := s.db.Query(ctx, query, host, shortCode, periods)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get visitor sketches: %w", err)
+	}
+	defer rows.Close()
+
+	visitors := &domain.UniqueVisitors{
+		Daily:   make(map[string]int64),
+		Monthly: make(map[string]int64),
+	}
+	for rows.Next() {
+		var (
+			period string
+			data   []byte
+		)
+		if err := rows.Scan(&period, &data); err != nil {
+			return nil, fmt.Errorf("failed to scan visitor sketch: %w", err)
+		}
+
+		stored, err := unmarshalSketch(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode visitor sketch: %w", err)
+		}
+
+		count := stored.estimate()
+		switch {
+		case period == totalPeriod:
+			visitors.Total = count
+		case count == 0:
+		case len(period) == len(domain.VisitorDayLayout):
+			visitors.Daily[period] = count
+		default:
+			visitors.Monthly[period] = count
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("visitor sketches rows error: %w", err)
+	}
+
+	return visitors, nil
+}
+
+// PruneVisitorSketches deletes the daily sketches of days before oldestDay
+// and the monthly sketches of months before oldestMonth, batchSize rows per
+// statement. All-time sketches are kept. It returns the number of sketches
+// deleted.
+func (s *PostgresStore) PruneVisitorSketches(ctx context.Context, batchSize int, oldestDay, oldestMonth string) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	query := `
+        DELETE FROM visitor_sketches
+        WHERE ctid IN (
+            SELECT ctid
+            FROM visitor_sketches
+            WHERE (length(period) = $1 AND period < $2)
+               OR (length(period) = $3 AND period < $4)
+            LIMIT $5
+        )
+    `
+
+	var total int64
+	for {
+		tag, err := s.db.Exec(ctx, query, len(domain.VisitorDayLayout), oldestDay, len(domain.VisitorMonthLayout), oldestMonth, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to prune visitor sketches: %w", err)
+		}
+		total += tag.RowsAffected()
+
+		if tag.RowsAffected() < int64(batchSize) {
+			return total, nil
+		}
+		logger.Info("Pruned visitor sketches", "rows", total)
+	}
+}
@@ -2,13 +2,89 @@ package ui
 
 import (
 	"embed"
+	"html/template"
 	"io/fs"
 	"net/http"
+	"time"
 )
 
 //go:embed assets/index.html
 var content embed.FS
 
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+type passwordPage struct {
+	ShortCode string
+	Error     string
+}
+
+// Preview describes the page shown before following a short link. The
+// destination is left out of the page for protected links.
+type Preview struct {
+	ShortCode    string
+	Destination  string
+	CreatedAt    time.Time
+	Clicks       int64
+	Protected    bool
+	Interstitial bool
+	ContinueURL  string
+}
+
+// Bridge describes the page that opens an app through a custom scheme and
+// falls back to a web URL when the app is not installed.
+type Bridge struct {
+	ShortCode   string
+	AppURL      string
+	FallbackURL string
+}
+
+type bridgePage struct {
+	ShortCode string
+	// AppURL has been validated by the caller; marking it as a URL keeps
+	// html/template from replacing custom schemes with #ZgotmplZ.
+	AppURL      template.URL
+	FallbackURL string
+}
+
+// Branding customizes the pages shown to visitors of short links
+type Branding struct {
+	Name       string
+	LogoURL    string
+	SupportURL string
+}
+
+const defaultBrandName = "URL Shortener"
+
+// ErrorState is the reason a short link cannot be followed
+type ErrorState int
+
+const (
+	ErrorNotFound ErrorState = iota
+	ErrorExpired
+	ErrorDisabled
+	ErrorDeleted
+)
+
+var errorTexts = map[ErrorState]struct{ title, message string }{
+	ErrorNotFound: {"Ссылка не найдена", "Такой короткой ссылки не существует. Проверьте адрес"},
+	ErrorExpired:  {"Срок действия ссылки истёк", "Ссылка больше недоступна: истёк срок её действия или лимит переходов"},
+	ErrorDisabled: {"Ссылка отключена", "Владелец временно отключил ссылку"},
+	ErrorDeleted:  {"Ссылка удалена", "Владелец удалил ссылку"},
+}
+
+type errorPage struct {
+	Brand      string
+	LogoURL    string
+	SupportURL string
+	Status     int
+	Title      string
+	Message    string
+	ShortCode  string
+}
+
 func Register(mux *http.ServeMux) {
 	sub, _ := fs.Sub(content, "assets")
 	mux.Handle("/ui/", http.StripPrefix("/ui/", http.FileServer(http.FS(sub))))
@@ -25,3 +101,49 @@ func Register(mux *http.ServeMux) {
 		http.Redirect(w, r, "/ui/", http.StatusFound)
 	})
 }
+
+// RenderPasswordForm writes the password prompt for a protected link
+func RenderPasswordForm(w http.ResponseWriter, shortCode, errMsg string, status int) error {
+	return render(w, "password.html", passwordPage{ShortCode: shortCode, Error: errMsg}, status)
+}
+
+// RenderPreview writes the preview or interstitial page of a link
+func RenderPreview(w http.ResponseWriter, page Preview, status int) error {
+	return render(w, "preview.html", page, status)
+}
+
+// RenderBridge writes the deep link bridge page of a link
+func RenderBridge(w http.ResponseWriter, bridge Bridge, status int) error {
+	return render(w, "bridge.html", bridgePage{
+		ShortCode:   bridge.ShortCode,
+		AppURL:      template.URL(bridge.AppURL),
+		FallbackURL: bridge.FallbackURL,
+	}, status)
+}
+
+// RenderError writes the branded error page for a short link that cannot
+// be followed
+func RenderError(w http.ResponseWriter, state ErrorState, shortCode string, branding Branding, status int) error {
+	texts := errorTexts[state]
+	brand := branding.Name
+	if brand == "" {
+		brand = defaultBrandName
+	}
+
+	return render(w, "error.html", errorPage{
+		Brand:      brand,
+		LogoURL:    branding.LogoURL,
+		SupportURL: branding.SupportURL,
+		Status:     status,
+		Title:      texts.title,
+		Message:    texts.message,
+		ShortCode:  shortCode,
+	}, status)
+}
+
+func render(w http.ResponseWriter, name string, data any, status int) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	return templates.ExecuteTemplate(w, name, data)
+}
@@ -0,0 +1,3 @@
+ALTER TABLE urls
+    DROP COLUMN IF EXISTS max_clicks,
+    DROP COLUMN IF EXISTS expires_at;
\ No newline at end of file
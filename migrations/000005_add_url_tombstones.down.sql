@@ -0,0 +1,3 @@
+ALTER TABLE urls
+    DROP COLUMN IF EXISTS deleted_at,
+    DROP COLUMN IF EXISTS disabled_at;
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_urls_original_url_hash;
+
+ALTER TABLE urls
+    DROP COLUMN IF EXISTS original_url_hash;
\ No newline at end of file
@@ -0,0 +1,10 @@
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS original_url_hash CHAR(64);
+
+-- Existing rows are fingerprinted by their exact URL; new rows use the
+-- normalized form computed by the application.
+UPDATE urls
+SET original_url_hash = encode(sha256(original_url::bytea), 'hex')
+WHERE original_url_hash IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_urls_original_url_hash ON urls(original_url_hash);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS url_tags;
\ No newline at end of file
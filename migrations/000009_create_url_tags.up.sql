@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS url_tags (
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    tag VARCHAR(50) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (url_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_url_tags_tag ON url_tags(tag);
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_urls_clicks_id;
+DROP INDEX IF EXISTS idx_urls_created_at_id;
\ No newline at end of file
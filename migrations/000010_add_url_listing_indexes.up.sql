@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_urls_created_at_id ON urls(created_at DESC, id DESC) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_urls_clicks_id ON urls(clicks DESC, id DESC) WHERE deleted_at IS NULL;
\ No newline at end of file
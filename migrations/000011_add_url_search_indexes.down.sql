@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_urls_custom_alias_trgm;
+DROP INDEX IF EXISTS idx_urls_short_code_trgm;
+DROP INDEX IF EXISTS idx_urls_original_url_trgm;
\ No newline at end of file
@@ -0,0 +1,5 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_urls_original_url_trgm ON urls USING gin (original_url gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_urls_short_code_trgm ON urls USING gin (short_code gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_urls_custom_alias_trgm ON urls USING gin (custom_alias gin_trgm_ops);
\ No newline at end of file
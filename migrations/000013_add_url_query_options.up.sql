@@ -0,0 +1,5 @@
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS forward_query BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS utm_source VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS utm_medium VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS utm_campaign VARCHAR(255);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS url_targeting_rules;
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS url_targeting_rules (
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    device VARCHAR(20),
+    os VARCHAR(20),
+    language VARCHAR(35),
+    destination TEXT NOT NULL,
+    PRIMARY KEY (url_id, position),
+    CHECK (device IS NOT NULL OR os IS NOT NULL OR language IS NOT NULL)
+);
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS url_variants (
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    destination TEXT NOT NULL,
+    weight INT NOT NULL CHECK (weight > 0),
+    PRIMARY KEY (url_id, position),
+    UNIQUE (url_id, name)
+);
+
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS sticky_variants BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE click_events
+    ADD COLUMN IF NOT EXISTS variant VARCHAR(50);
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE urls
+    DROP COLUMN IF EXISTS fallback_url,
+    DROP COLUMN IF EXISTS android_url,
+    DROP COLUMN IF EXISTS ios_url;
\ No newline at end of file
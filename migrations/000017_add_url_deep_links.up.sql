@@ -0,0 +1,4 @@
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS ios_url TEXT,
+    ADD COLUMN IF NOT EXISTS android_url TEXT,
+    ADD COLUMN IF NOT EXISTS fallback_url TEXT;
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_click_events_bots;
+
+ALTER TABLE click_events
+    DROP COLUMN IF EXISTS is_bot;
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE click_events
+    ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_click_events_bots
+    ON click_events (short_code, created_at)
+    WHERE is_bot;
\ No newline at end of file
@@ -0,0 +1,36 @@
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM urls WHERE domain <> '') THEN
+        RAISE EXCEPTION 'links on non-default domains exist, move or delete them before rolling back';
+    END IF;
+END
+$$;
+
+DROP INDEX IF EXISTS idx_click_events_bots;
+
+DROP INDEX IF EXISTS idx_click_events_domain_short_code;
+
+ALTER TABLE click_events
+    DROP CONSTRAINT IF EXISTS click_events_domain_short_code_fkey;
+
+ALTER TABLE urls
+    DROP CONSTRAINT IF EXISTS urls_domain_short_code_key;
+
+ALTER TABLE urls
+    ADD CONSTRAINT urls_short_code_key UNIQUE (short_code);
+
+ALTER TABLE click_events
+    ADD CONSTRAINT click_events_short_code_fkey
+    FOREIGN KEY (short_code) REFERENCES urls (short_code) ON DELETE CASCADE;
+
+ALTER TABLE click_events
+    DROP COLUMN IF EXISTS domain;
+
+ALTER TABLE urls
+    DROP COLUMN IF EXISTS domain;
+
+CREATE INDEX IF NOT EXISTS idx_click_events_bots
+    ON click_events (short_code, created_at)
+    WHERE is_bot;
+
+DROP TABLE IF EXISTS domains;
\ No newline at end of file
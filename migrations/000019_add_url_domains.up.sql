@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS domains (
+    host VARCHAR(255) PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS domain VARCHAR(255) NOT NULL DEFAULT '';
+
+ALTER TABLE click_events
+    ADD COLUMN IF NOT EXISTS domain VARCHAR(255) NOT NULL DEFAULT '';
+
+ALTER TABLE click_events
+    DROP CONSTRAINT IF EXISTS click_events_short_code_fkey;
+
+ALTER TABLE urls
+    DROP CONSTRAINT IF EXISTS urls_short_code_key;
+
+ALTER TABLE urls
+    ADD CONSTRAINT urls_domain_short_code_key UNIQUE (domain, short_code);
+
+ALTER TABLE click_events
+    ADD CONSTRAINT click_events_domain_short_code_fkey
+    FOREIGN KEY (domain, short_code) REFERENCES urls (domain, short_code) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_click_events_domain_short_code
+    ON click_events (domain, short_code, created_at);
+
+DROP INDEX IF EXISTS idx_click_events_bots;
+
+CREATE INDEX IF NOT EXISTS idx_click_events_bots
+    ON click_events (domain, short_code, created_at)
+    WHERE is_bot;
\ No newline at end of file
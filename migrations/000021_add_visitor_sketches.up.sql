@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS visitor_sketches (
+    domain VARCHAR(255) NOT NULL DEFAULT '',
+    short_code VARCHAR(50) NOT NULL,
+    period VARCHAR(10) NOT NULL,
+    sketch BYTEA NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (domain, short_code, period),
+    FOREIGN KEY (domain, short_code) REFERENCES urls (domain, short_code) ON DELETE CASCADE
+);
\ No newline at end of file
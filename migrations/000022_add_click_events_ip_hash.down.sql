@@ -0,0 +1,2 @@
+ALTER TABLE click_events
+    DROP COLUMN IF EXISTS ip_hash;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE click_events
+    ADD COLUMN IF NOT EXISTS ip_hash VARCHAR(32);
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE click_events
+    DROP COLUMN IF EXISTS device,
+    DROP COLUMN IF EXISTS os_version,
+    DROP COLUMN IF EXISTS os,
+    DROP COLUMN IF EXISTS browser_version,
+    DROP COLUMN IF EXISTS browser;
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE click_events
+    ADD COLUMN IF NOT EXISTS browser VARCHAR(32),
+    ADD COLUMN IF NOT EXISTS browser_version VARCHAR(16),
+    ADD COLUMN IF NOT EXISTS os VARCHAR(32),
+    ADD COLUMN IF NOT EXISTS os_version VARCHAR(16),
+    ADD COLUMN IF NOT EXISTS device VARCHAR(16);
\ No newline at end of file